@@ -0,0 +1,114 @@
+package recog
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// AssetInfo is a typed view of the values extracted by a fingerprint match
+type AssetInfo struct {
+	Description string  `json:"description,omitempty"`
+	Certainty   float64 `json:"certainty,omitempty"`
+
+	OSVendor  string `json:"os_vendor,omitempty"`
+	OSProduct string `json:"os_product,omitempty"`
+	OSFamily  string `json:"os_family,omitempty"`
+	OSVersion string `json:"os_version,omitempty"`
+	OSEdition string `json:"os_edition,omitempty"`
+	OSBuild   string `json:"os_build,omitempty"`
+	OSArch    string `json:"os_arch,omitempty"`
+	OSDevice  string `json:"os_device,omitempty"`
+
+	HWVendor  string `json:"hw_vendor,omitempty"`
+	HWProduct string `json:"hw_product,omitempty"`
+	HWFamily  string `json:"hw_family,omitempty"`
+	HWVersion string `json:"hw_version,omitempty"`
+	HWModel   string `json:"hw_model,omitempty"`
+	HWDevice  string `json:"hw_device,omitempty"`
+
+	ServiceVendor  string `json:"service_vendor,omitempty"`
+	ServiceProduct string `json:"service_product,omitempty"`
+	ServiceFamily  string `json:"service_family,omitempty"`
+	ServiceVersion string `json:"service_version,omitempty"`
+	ServiceEdition string `json:"service_edition,omitempty"`
+
+	HostName string `json:"host_name,omitempty"`
+
+	// Device is the reconciled hw.device or os.device value
+	Device string `json:"device,omitempty"`
+
+	// CPE23 holds every *.cpe23 value, including repeated values, ordered by key name
+	CPE23 []string `json:"cpe23,omitempty"`
+
+	// Extra holds any values without a typed field
+	Extra map[string]string `json:"extra,omitempty"`
+}
+
+// assetFields maps value keys to the AssetInfo field they populate
+var assetFields = map[string]func(a *AssetInfo) *string{
+	MatchedKey:        func(a *AssetInfo) *string { return &a.Description },
+	legacyMatchedKey:  func(a *AssetInfo) *string { return &a.Description },
+	"os.vendor":       func(a *AssetInfo) *string { return &a.OSVendor },
+	"os.product":      func(a *AssetInfo) *string { return &a.OSProduct },
+	"os.family":       func(a *AssetInfo) *string { return &a.OSFamily },
+	"os.version":      func(a *AssetInfo) *string { return &a.OSVersion },
+	"os.edition":      func(a *AssetInfo) *string { return &a.OSEdition },
+	"os.build":        func(a *AssetInfo) *string { return &a.OSBuild },
+	"os.arch":         func(a *AssetInfo) *string { return &a.OSArch },
+	"os.device":       func(a *AssetInfo) *string { return &a.OSDevice },
+	"hw.vendor":       func(a *AssetInfo) *string { return &a.HWVendor },
+	"hw.product":      func(a *AssetInfo) *string { return &a.HWProduct },
+	"hw.family":       func(a *AssetInfo) *string { return &a.HWFamily },
+	"hw.version":      func(a *AssetInfo) *string { return &a.HWVersion },
+	"hw.model":        func(a *AssetInfo) *string { return &a.HWModel },
+	"hw.device":       func(a *AssetInfo) *string { return &a.HWDevice },
+	"service.vendor":  func(a *AssetInfo) *string { return &a.ServiceVendor },
+	"service.product": func(a *AssetInfo) *string { return &a.ServiceProduct },
+	"service.family":  func(a *AssetInfo) *string { return &a.ServiceFamily },
+	"service.version": func(a *AssetInfo) *string { return &a.ServiceVersion },
+	"service.edition": func(a *AssetInfo) *string { return &a.ServiceEdition },
+	"host.name":       func(a *AssetInfo) *string { return &a.HostName },
+	DeviceKey:         func(a *AssetInfo) *string { return &a.Device },
+}
+
+// ToAsset converts the match values into an AssetInfo
+func (m *FingerprintMatch) ToAsset() AssetInfo {
+	a := AssetInfo{}
+
+	keys := make([]string, 0, len(m.Values))
+	for k := range m.Values {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	for _, k := range keys {
+		v := m.Values[k]
+		if field, ok := assetFields[k]; ok {
+			*field(&a) = v
+			continue
+		}
+		if k == CertaintyKey || k == legacyCertaintyKey {
+			if certainty, err := strconv.ParseFloat(v, 64); err == nil {
+				a.Certainty = certainty
+				continue
+			}
+		}
+		if strings.HasPrefix(k, ReservedPrefix) {
+			continue
+		}
+		if strings.HasSuffix(k, ".cpe23") {
+			if values, ok := m.MultiValues[k]; ok {
+				a.CPE23 = append(a.CPE23, values...)
+				continue
+			}
+			a.CPE23 = append(a.CPE23, v)
+			continue
+		}
+		if a.Extra == nil {
+			a.Extra = make(map[string]string)
+		}
+		a.Extra[k] = v
+	}
+	return a
+}
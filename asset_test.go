@@ -0,0 +1,59 @@
+package recog
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestToAsset(t *testing.T) {
+	xmlData := `<fingerprints matches="x509.subject" protocol="x509">
+  <fingerprint pattern="^CN=iDRACdefault[0-9A-F]{12},OU=iDRAC Group,O=Dell Inc\.">
+    <description>Dell iDRAC</description>
+    <param pos="0" name="hw.vendor" value="Dell"/>
+    <param pos="0" name="hw.product" value="iDRAC"/>
+  </fingerprint>
+</fingerprints>`
+	fdb, err := LoadFingerprintDB("x509_subjects.xml", []byte(xmlData))
+	if err != nil {
+		t.Fatalf("LoadFingerprintDB() failed: %s", err)
+	}
+
+	m := fdb.MatchFirst("CN=iDRACdefault0023AEF89AD1,OU=iDRAC Group,O=Dell Inc.,L=Round Rock,C=US")
+	if !m.Matched {
+		t.Fatalf("failed to match the iDRAC subject: %#v", m)
+	}
+	a := m.ToAsset()
+	if a.HWVendor != "Dell" || a.HWProduct != "iDRAC" || a.Description == "" || a.Certainty == 0 {
+		t.Errorf("ToAsset() returned unexpected values: %#v", a)
+	}
+
+	m = &FingerprintMatch{
+		Matched: true,
+		Values: map[string]string{
+			"matched":         "Apache on Ubuntu",
+			"fp.certainty":    "0.85",
+			"service.vendor":  "Apache",
+			"service.product": "HTTPD",
+			"service.version": "2.4.41",
+			"service.cpe23":   "cpe:/a:apache:http_server:2.4.41",
+			"os.vendor":       "Ubuntu",
+			"os.product":      "Linux",
+			"os.cpe23":        "cpe:/o:canonical:ubuntu_linux:-",
+			"apache.info":     "mod_ssl",
+		},
+	}
+	expected := AssetInfo{
+		Description:    "Apache on Ubuntu",
+		Certainty:      0.85,
+		OSVendor:       "Ubuntu",
+		OSProduct:      "Linux",
+		ServiceVendor:  "Apache",
+		ServiceProduct: "HTTPD",
+		ServiceVersion: "2.4.41",
+		CPE23:          []string{"cpe:/o:canonical:ubuntu_linux:-", "cpe:/a:apache:http_server:2.4.41"},
+		Extra:          map[string]string{"apache.info": "mod_ssl"},
+	}
+	if a := m.ToAsset(); !reflect.DeepEqual(a, expected) {
+		t.Errorf("ToAsset() = %#v, expected %#v", a, expected)
+	}
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/xml"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/runZeroInc/recog-go"
+)
+
+var (
+	minCertainty = flag.Float64("min-certainty", 0.85, "Only emit examples for matches with at least this certainty")
+	maxPerFP     = flag.Int("max", 0, "Emit at most this many examples per fingerprint, 0 for no limit")
+	decode       = flag.String("decode", "", "Decode each banner from this encoding (base64 or hex)")
+)
+
+// candidate is an example proposed for a fingerprint
+type candidate struct {
+	fp      *recog.Fingerprint
+	example *recog.FingerprintExample
+}
+
+// generator proposes examples for the banners it is given, skipping banners that
+// were already seen or that an existing example of the fingerprint already holds
+type generator struct {
+	fdb          *recog.FingerprintDB
+	minCertainty float64
+	max          int
+
+	seen   map[string]bool
+	counts map[*recog.Fingerprint]int
+	known  map[*recog.Fingerprint]map[string]bool
+}
+
+func newGenerator(fdb *recog.FingerprintDB, minCertainty float64, max int) *generator {
+	g := &generator{
+		fdb:          fdb,
+		minCertainty: minCertainty,
+		max:          max,
+		seen:         make(map[string]bool),
+		counts:       make(map[*recog.Fingerprint]int),
+		known:        make(map[*recog.Fingerprint]map[string]bool),
+	}
+	for _, fp := range fdb.Fingerprints {
+		g.known[fp] = make(map[string]bool)
+		for _, ex := range fp.Examples {
+			data := ex.Text
+			switch ex.AttributeMap["_encoding"] {
+			case "base64":
+				if blob, err := base64.RawStdEncoding.DecodeString(strings.TrimRight(strings.Join(strings.Fields(data), ""), "=")); err == nil {
+					data = string(blob)
+				}
+			case "base64url":
+				if blob, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(strings.Join(strings.Fields(data), ""), "=")); err == nil {
+					data = string(blob)
+				}
+			}
+			g.known[fp][data] = true
+		}
+	}
+	return g
+}
+
+// add matches a banner, returning a candidate example or nil
+func (g *generator) add(banner string) *candidate {
+	if g.seen[banner] {
+		return nil
+	}
+	g.seen[banner] = true
+
+	m := g.fdb.MatchFirst(banner)
+	if !m.Matched || len(m.Errors) > 0 {
+		return nil
+	}
+	certainty, err := strconv.ParseFloat(m.Values[recog.CertaintyKey], 64)
+	if err != nil || certainty < g.minCertainty {
+		return nil
+	}
+	fp := m.Fingerprint()
+	if g.known[fp][banner] || (g.max > 0 && g.counts[fp] >= g.max) {
+		return nil
+	}
+	g.counts[fp]++
+	return &candidate{fp: fp, example: m.ToExample(banner)}
+}
+
+// decodeBanner decodes data from the named encoding, an empty encoding returns data as-is
+func decodeBanner(data string, encoding string) (string, error) {
+	switch encoding {
+	case "":
+		return data, nil
+	case "base64":
+		blob, err := base64.StdEncoding.DecodeString(data)
+		return string(blob), err
+	case "hex":
+		blob, err := hex.DecodeString(data)
+		return string(blob), err
+	}
+	return "", fmt.Errorf("unsupported encoding %s", encoding)
+}
+
+// generate reads one banner per line from r and writes an example element for each
+// candidate, preceded by a comment naming its fingerprint
+func generate(r io.Reader, w io.Writer, g *generator, encoding string) error {
+	enc := xml.NewEncoder(w)
+	scanner := bufio.NewScanner(r)
+
+	// Use a 8mb line length buffer to handle large encoded banners
+	buf := make([]byte, 0, 1024*1024*8)
+	scanner.Buffer(buf, 1024*1024*8)
+
+	for scanner.Scan() {
+		banner, err := decodeBanner(scanner.Text(), encoding)
+		if err != nil {
+			log.Printf("bad %s banner %q: %s", encoding, scanner.Text(), err)
+			continue
+		}
+		c := g.add(banner)
+		if c == nil {
+			continue
+		}
+		name := c.fp.Pattern
+		if c.fp.Description != nil && c.fp.Description.Text != "" {
+			name = c.fp.Description.Text
+		}
+		if err := enc.EncodeToken(xml.Comment(" " + xmlCommentSafe(name) + " ")); err != nil {
+			return err
+		}
+		if err := enc.EncodeElement(c.example, xml.StartElement{Name: xml.Name{Local: "example"}}); err != nil {
+			return err
+		}
+		if err := enc.Flush(); err != nil {
+			return err
+		}
+		fmt.Fprintln(w)
+	}
+	return scanner.Err()
+}
+
+// xmlCommentSafe replaces the "--" sequences that XML comments may not contain
+func xmlCommentSafe(s string) string {
+	for i := 0; i+1 < len(s); i++ {
+		if s[i] == '-' && s[i+1] == '-' {
+			s = s[:i+1] + " " + s[i+1:]
+		}
+	}
+	return s
+}
+
+func main() {
+	log.SetFlags(0)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage %s [options] XML_FINGERPRINT_FILE [BANNER_FILE]\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "Matches each line of the banner file, or stdin, against the fingerprints and\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "writes <example> elements for review. Duplicate banners and banners that are\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "already examples of their fingerprint are skipped.\n\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "Options:\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() < 1 {
+		log.Fatalf("missing: recog xml file")
+	}
+	if _, err := decodeBanner("", *decode); err != nil {
+		log.Fatal(err)
+	}
+
+	fdb, err := recog.LoadFingerprintDBFromFile(flag.Arg(0))
+	if err != nil {
+		log.Fatalf("error loading fingerprints from %s: %s", flag.Arg(0), err)
+	}
+
+	in := io.Reader(os.Stdin)
+	if flag.NArg() > 1 {
+		fd, err := os.Open(flag.Arg(1))
+		if err != nil {
+			log.Fatalf("could not open file: %s %s", flag.Arg(1), err)
+		}
+		defer fd.Close()
+		in = fd
+	}
+
+	w := bufio.NewWriter(os.Stdout)
+	defer w.Flush()
+	if err := generate(in, w, newGenerator(&fdb, *minCertainty, *maxPerFP), *decode); err != nil {
+		log.Fatalf("error generating examples: %s", err)
+	}
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/runZeroInc/recog-go"
+)
+
+func TestGenerate(t *testing.T) {
+	fdb, err := recog.LoadFingerprintDBFromFile("testdata/test.xml")
+	if err != nil {
+		t.Fatalf("LoadFingerprintDBFromFile() failed: %s", err)
+	}
+	fd, err := os.Open("testdata/banners.txt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fd.Close()
+
+	var out bytes.Buffer
+	if err := generate(fd, &out, newGenerator(&fdb, 0.85, 0), ""); err != nil {
+		t.Fatalf("generate() failed: %s", err)
+	}
+
+	// The output is a sequence of example elements that verify against the database
+	var examples struct {
+		Examples []*recog.FingerprintExample `xml:"example"`
+	}
+	if err := xml.Unmarshal([]byte("<examples>"+out.String()+"</examples>"), &examples); err != nil {
+		t.Fatalf("generate() wrote invalid XML: %s\n%s", err, out.String())
+	}
+	if len(examples.Examples) != 2 {
+		t.Fatalf("generate() wrote %d examples, expected 2:\n%s", len(examples.Examples), out.String())
+	}
+	for i, version := range []string{"2", "3"} {
+		ex := examples.Examples[i]
+		if ex.Text != "Acme Server v"+version || !strings.Contains(out.String(), `service.version="`+version+`"`) {
+			t.Errorf("generate() example %d = %q", i, ex.Text)
+		}
+	}
+	if !strings.Contains(out.String(), "<!-- Acme Server -->") {
+		t.Errorf("generate() did not name the fingerprint:\n%s", out.String())
+	}
+
+	fp := fdb.Fingerprints[0]
+	fp.Examples = examples.Examples
+	if err := fp.Normalize(); err != nil {
+		t.Fatalf("Normalize() failed: %s", err)
+	}
+	if err := fp.VerifyExamples(""); err != nil {
+		t.Errorf("VerifyExamples() failed for the generated examples: %s", err)
+	}
+}
+
+func TestGenerateLimits(t *testing.T) {
+	fdb, err := recog.LoadFingerprintDBFromFile("testdata/test.xml")
+	if err != nil {
+		t.Fatalf("LoadFingerprintDBFromFile() failed: %s", err)
+	}
+
+	g := newGenerator(&fdb, 0.5, 1)
+	if c := g.add("Acme Other"); c == nil || c.fp != fdb.Fingerprints[1] {
+		t.Errorf("add() rejected a match meeting the minimum certainty")
+	}
+	if c := g.add("Acme Else"); c != nil {
+		t.Errorf("add() exceeded the limit of examples per fingerprint")
+	}
+	if c := g.add("Acme"); c != nil {
+		t.Errorf("add() proposed an existing example")
+	}
+}
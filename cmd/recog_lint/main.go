@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	recog "github.com/runZeroInc/recog-go"
+)
+
+// issue is a single problem found while linting a fingerprint database
+type issue struct {
+	File        string `json:"file"`
+	Check       string `json:"check"`
+	Fingerprint string `json:"fingerprint,omitempty"`
+	Message     string `json:"message"`
+}
+
+// report summarizes the result of linting a directory
+type report struct {
+	Databases    int      `json:"databases"`
+	Fingerprints int      `json:"fingerprints"`
+	Issues       []*issue `json:"issues"`
+}
+
+var (
+	jsonOutput  = flag.Bool("json", false, "Write the report as JSON")
+	identifiers = flag.String("identifiers", "", "Path to the recog identifiers directory used to report unknown and unused fields")
+)
+
+func visit(files *[]string) filepath.WalkFunc {
+	return func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if info.IsDir() || filepath.Ext(path) != ".xml" {
+			return nil
+		}
+
+		*files = append(*files, path)
+		return nil
+	}
+}
+
+func main() {
+	log.SetFlags(0)
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage %s [options] XML_FINGERPRINT_DIRECTORY\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "Validates fingerprints, verifies their examples, and detects shadowed fingerprints.\n\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "Options:\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(1)
+	}
+
+	var files []string
+	if err := filepath.Walk(flag.Arg(0), visit(&files)); err != nil {
+		log.Fatal(err)
+	}
+
+	rep := &report{Issues: []*issue{}}
+	fields := make(map[string]bool)
+	for _, file := range files {
+		lint(rep, file, fields)
+	}
+
+	if *identifiers != "" {
+		if err := lintFields(rep, fields); err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	if *jsonOutput {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		if err := enc.Encode(rep); err != nil {
+			log.Fatal(err)
+		}
+	} else {
+		for _, i := range rep.Issues {
+			if i.Fingerprint != "" {
+				fmt.Printf("%s: %s: %q: %s\n", i.File, i.Check, i.Fingerprint, i.Message)
+			} else {
+				fmt.Printf("%s: %s: %s\n", i.File, i.Check, i.Message)
+			}
+		}
+		fmt.Printf("checked %d fingerprints in %d databases, found %d issues\n", rep.Fingerprints, rep.Databases, len(rep.Issues))
+	}
+
+	if len(rep.Issues) > 0 {
+		os.Exit(1)
+	}
+}
+
+// lint loads a single database and runs each check, recording param names in fields
+func lint(rep *report, file string, fields map[string]bool) {
+	fdb, err := recog.LoadFingerprintDBFromFile(file)
+	if err != nil {
+		rep.Issues = append(rep.Issues, &issue{File: file, Check: "load", Message: err.Error()})
+		return
+	}
+	rep.Databases++
+	rep.Fingerprints += len(fdb.Fingerprints)
+
+	for _, fp := range fdb.Fingerprints {
+		desc := description(fp)
+		if err := fp.Validate(); err != nil {
+			rep.Issues = append(rep.Issues, &issue{File: file, Check: "validate", Fingerprint: desc, Message: err.Error()})
+		}
+		if err := fp.VerifyExamples(fdb.ExamplesPath); err != nil {
+			rep.Issues = append(rep.Issues, &issue{File: file, Check: "examples", Fingerprint: desc, Message: err.Error()})
+		}
+		for _, param := range fp.Params {
+			fields[param.Name] = true
+		}
+	}
+
+	for _, shadow := range fdb.FindShadows() {
+		rep.Issues = append(rep.Issues, &issue{
+			File:        file,
+			Check:       "shadow",
+			Fingerprint: description(shadow.Fingerprint),
+			Message:     fmt.Sprintf("example %q is matched by earlier fingerprint %q", shadow.Example.Text, description(shadow.ShadowedBy)),
+		})
+	}
+}
+
+// lintFields compares the param names used by fingerprints with the fields reference file
+func lintFields(rep *report, fields map[string]bool) error {
+	path := filepath.Join(*identifiers, "fields.txt")
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to load identifiers: %s", err)
+	}
+	defer f.Close()
+
+	known := make(map[string]bool)
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if field := strings.TrimSpace(scanner.Text()); field != "" {
+			known[field] = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read identifiers: %s", err)
+	}
+
+	for _, field := range sortedKeys(fields) {
+		// Temporary params are never reported
+		if !known[field] && !strings.HasPrefix(field, "_tmp.") {
+			rep.Issues = append(rep.Issues, &issue{File: path, Check: "identifiers", Message: fmt.Sprintf("unknown field %s", field)})
+		}
+	}
+	for _, field := range sortedKeys(known) {
+		if !fields[field] {
+			rep.Issues = append(rep.Issues, &issue{File: path, Check: "identifiers", Message: fmt.Sprintf("unused field %s", field)})
+		}
+	}
+	return nil
+}
+
+func description(fp *recog.Fingerprint) string {
+	if fp.Description == nil {
+		return fp.Pattern
+	}
+	return fp.Description.Text
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
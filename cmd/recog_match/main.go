@@ -2,12 +2,18 @@ package main
 
 import (
 	"bufio"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
+	"flag"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"sort"
 	"strings"
+	"text/tabwriter"
 
 	recog "github.com/runZeroInc/recog-go"
 )
@@ -27,23 +33,140 @@ func visit(files *[]string) filepath.WalkFunc {
 	}
 }
 
-func fingerprint(fingerprints []recog.FingerprintDB, text string) {
+var (
+	ndjson  = flag.Bool("ndjson", false, "Write one JSON record per match including the input, the matched text, and the database name")
+	summary = flag.Bool("summary", false, "Print a table of match counts by fingerprint and the unmatched count instead of each match")
+	input   = flag.String("input", "", "Read id,banner lines from a CSV file instead of the arguments or stdin")
+	decode  = flag.String("decode", "", "Decode each banner read with -input from this encoding (base64 or hex)")
+)
+
+// matchRecord is the NDJSON output format
+type matchRecord struct {
+	ID          string            `json:"id,omitempty"`
+	Input       string            `json:"input"`
+	MatchedText string            `json:"matched_text"`
+	Database    string            `json:"database"`
+	Values      map[string]string `json:"values"`
+}
+
+// matchSummary counts the matched fingerprints and unmatched inputs
+type matchSummary struct {
+	Counts    map[string]int
+	Total     int
+	Unmatched int
+}
+
+func fingerprint(fingerprints []recog.FingerprintDB, id string, text string, stats *matchSummary) {
+	matched := false
 	for _, fdb := range fingerprints {
 		match := fdb.MatchFirst(text)
-		if match.Matched {
-			j, _ := json.Marshal(match.Values)
+		if !match.Matched {
+			continue
+		}
+		matched = true
+		if *summary {
+			stats.Counts[fdb.Name+": "+match.Values["matched"]]++
+			continue
+		}
+		if *ndjson {
+			j, _ := json.Marshal(matchRecord{ID: id, Input: text, MatchedText: match.MatchedText, Database: fdb.Name, Values: match.Values})
 			fmt.Printf("%s\n", j)
+			continue
+		}
+		j, _ := json.Marshal(match.Values)
+		if id != "" {
+			fmt.Printf("%s %s\n", id, j)
+			continue
+		}
+		fmt.Printf("%s\n", j)
+	}
+
+	stats.Total++
+	if !matched {
+		stats.Unmatched++
+	}
+}
+
+// decodeBanner decodes data from the named encoding, an empty encoding returns data as-is
+func decodeBanner(data string, encoding string) (string, error) {
+	switch encoding {
+	case "":
+		return data, nil
+	case "base64":
+		blob, err := base64.StdEncoding.DecodeString(data)
+		return string(blob), err
+	case "hex":
+		blob, err := hex.DecodeString(data)
+		return string(blob), err
+	}
+	return "", fmt.Errorf("unsupported encoding %s", encoding)
+}
+
+// readCSV calls fn with the id and decoded banner of each id,banner line in r.
+// Malformed lines and decoding errors are logged and skipped.
+func readCSV(r io.Reader, encoding string, fn func(id string, text string)) error {
+	scanner := bufio.NewScanner(r)
+
+	// Use a 8mb line length buffer to handle large encoded banners
+	buf := make([]byte, 0, 1024*1024*8)
+	scanner.Buffer(buf, 1024*1024*8)
+
+	for scanner.Scan() {
+		data := scanner.Text()
+		bits := strings.SplitN(data, ",", 2)
+		if len(bits) != 2 {
+			log.Printf("bad line: %s", data)
+			continue
 		}
+
+		text, err := decodeBanner(bits[1], encoding)
+		if err != nil {
+			log.Printf("bad %s for %s: %s", encoding, bits[0], err)
+			continue
+		}
+		fn(bits[0], text)
+	}
+	return scanner.Err()
+}
+
+// printSummary writes the fingerprint counts sorted by descending count
+func printSummary(stats *matchSummary) {
+	names := make([]string, 0, len(stats.Counts))
+	for name := range stats.Counts {
+		names = append(names, name)
 	}
+	sort.Slice(names, func(i, j int) bool {
+		if stats.Counts[names[i]] != stats.Counts[names[j]] {
+			return stats.Counts[names[i]] > stats.Counts[names[j]]
+		}
+		return names[i] < names[j]
+	})
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "COUNT\tFINGERPRINT\n")
+	for _, name := range names {
+		fmt.Fprintf(w, "%d\t%s\n", stats.Counts[name], name)
+	}
+	fmt.Fprintf(w, "%d\t(unmatched)\n", stats.Unmatched)
+	fmt.Fprintf(w, "%d\t(total inputs)\n", stats.Total)
+	w.Flush()
 }
 
 func main() {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage %s [options] XML_FINGERPRINT_DIRECTORY [TEXT]\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "Matches the text, or each line of stdin, against the fingerprints.\n\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "Options:\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
 	var files []string
-	if len(os.Args) < 2 {
+	if flag.NArg() < 1 {
 		log.Fatalf("missing: recog xml directory")
 	}
 
-	err := filepath.Walk(os.Args[1], visit(&files))
+	err := filepath.Walk(flag.Arg(0), visit(&files))
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -59,14 +182,35 @@ func main() {
 
 	var text string
 
-	text = strings.Join(os.Args[2:], " ")
-	if len(text) < 1 {
+	stats := &matchSummary{Counts: make(map[string]int)}
+	text = strings.Join(flag.Args()[1:], " ")
+	if *input != "" {
+		if _, err := decodeBanner("", *decode); err != nil {
+			log.Fatal(err)
+		}
+		fd, err := os.Open(*input)
+		if err != nil {
+			log.Fatalf("could not open file: %s %s", *input, err)
+		}
+		defer fd.Close()
+
+		err = readCSV(fd, *decode, func(id string, text string) {
+			fingerprint(fingerprints, id, text, stats)
+		})
+		if err != nil {
+			log.Fatalf("error reading %s: %s", *input, err)
+		}
+	} else if len(text) < 1 {
 		scanner := bufio.NewScanner(os.Stdin)
 		for scanner.Scan() {
 			text = scanner.Text()
-			fingerprint(fingerprints, text)
+			fingerprint(fingerprints, "", text, stats)
 		}
 	} else {
-		fingerprint(fingerprints, text)
+		fingerprint(fingerprints, "", text, stats)
+	}
+
+	if *summary {
+		printSummary(stats)
 	}
 }
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"os"
+	"reflect"
+	"testing"
+)
+
+func TestReadCSV(t *testing.T) {
+	fd, err := os.Open("testdata/banners.csv")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer fd.Close()
+
+	got := map[string]string{}
+	err = readCSV(fd, "base64", func(id string, text string) {
+		got[id] = text
+	})
+	if err != nil {
+		t.Fatalf("readCSV() failed: %s", err)
+	}
+
+	expected := map[string]string{
+		"host1": "OpenSSH_7.4",
+		"host2": "Server: Apache\r\n",
+		"host4": "",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("readCSV() returned %q, expected %q", got, expected)
+	}
+}
+
+func TestDecodeBanner(t *testing.T) {
+	tests := []struct {
+		data     string
+		encoding string
+		expected string
+		valid    bool
+	}{
+		{"OpenSSH_7.4", "", "OpenSSH_7.4", true},
+		{"T3BlblNTSF83LjQ=", "base64", "OpenSSH_7.4", true},
+		{"4f70656e535348", "hex", "OpenSSH", true},
+		{"zz", "hex", "", false},
+		{"data", "rot13", "", false},
+	}
+	for _, tc := range tests {
+		got, err := decodeBanner(tc.data, tc.encoding)
+		if tc.valid && (err != nil || got != tc.expected) {
+			t.Errorf("decodeBanner(%q, %q) = %q, %v, expected %q", tc.data, tc.encoding, got, err, tc.expected)
+		}
+		if !tc.valid && err == nil {
+			t.Errorf("decodeBanner(%q, %q) should have failed", tc.data, tc.encoding)
+		}
+	}
+}
@@ -1,14 +1,15 @@
 package main
 
 import (
-	"bufio"
 	"bytes"
+	"encoding/json"
 	"encoding/xml"
 	"flag"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"regexp"
 	"sort"
 	"strings"
 	"sync"
@@ -19,30 +20,68 @@ import (
 
 type set map[string]struct{}
 
-var stdIdentifiers = map[string]set{
-	"device":          nil,
+// identifierParams maps each identifier class to the params whose values it tracks.
+// The "fields" class is special and tracks the names of all params.
+var identifierParams = map[string][]string{
+	"device":          {"os.device", "service.device", "hw.device"},
 	"fields":          nil,
-	"hw_family":       nil,
-	"hw_product":      nil,
-	"os_architecture": nil,
-	"os_family":       nil,
-	"os_product":      nil,
-	"service_family":  nil,
-	"service_product": nil,
-	"vendor":          nil,
+	"hw_family":       {"hw.family"},
+	"hw_product":      {"hw.product"},
+	"os_architecture": {"os.arch"},
+	"os_family":       {"os.family"},
+	"os_product":      {"os.product"},
+	"service_family":  {"service.family"},
+	"service_product": {"service.product", "service.component.product"},
+	"vendor":          {"os.vendor", "service.vendor", "service.component.vendor", "hw.vendor"},
 }
 
-var curIdentifiers = map[string]set{
-	"device":          make(set),
-	"fields":          make(set),
-	"hw_family":       make(set),
-	"hw_product":      make(set),
-	"os_architecture": make(set),
-	"os_family":       make(set),
-	"os_product":      make(set),
-	"service_family":  make(set),
-	"service_product": make(set),
-	"vendor":          make(set),
+var (
+	stdIdentifiers = map[string]set{}
+	curIdentifiers = map[string]set{}
+
+	// paramClasses maps each param name to the identifier classes tracking it
+	paramClasses = map[string][]string{}
+)
+
+// initIdentifiers prepares the identifier sets and param lookup for the given classes
+func initIdentifiers(classes map[string][]string) {
+	stdIdentifiers = make(map[string]set, len(classes))
+	curIdentifiers = make(map[string]set, len(classes))
+	paramClasses = make(map[string][]string)
+	for identifier, params := range classes {
+		stdIdentifiers[identifier] = nil
+		curIdentifiers[identifier] = make(set)
+		for _, param := range params {
+			paramClasses[param] = append(paramClasses[param], identifier)
+		}
+	}
+	curIdentifiers["fields"] = make(set)
+}
+
+// loadConfig reads a JSON object mapping identifier classes to param names, such as
+// {"service_module": ["service.module"]}, and merges it with the default classes.
+// Classes in the config replace the default params for a class of the same name.
+func loadConfig(path string) (map[string][]string, error) {
+	classes := make(map[string][]string, len(identifierParams))
+	for identifier, params := range identifierParams {
+		classes[identifier] = params
+	}
+	if path == "" {
+		return classes, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %s", err)
+	}
+	var custom map[string][]string
+	if err := json.Unmarshal(data, &custom); err != nil {
+		return nil, fmt.Errorf("failed to parse config %s: %s", path, err)
+	}
+	for identifier, params := range custom {
+		classes[identifier] = params
+	}
+	return classes, nil
 }
 
 func (s *set) add(key string) {
@@ -65,8 +104,10 @@ var (
 	asyncErr  = atomic.Value{}
 	recogHome = os.Getenv("RECOG_HOME")
 
-	write = flag.Bool("w", false, "Write newly discovered identifiers to the identifiers reference files")
-	zero  = flag.Bool("z", false, "Whether to exit with a zero exit code on success")
+	write  = flag.Bool("w", false, "Write the discovered identifiers to the identifiers reference files, removing stale entries")
+	merge  = flag.Bool("merge", false, "Add newly discovered identifiers to the identifiers reference files, preserving existing entries")
+	zero   = flag.Bool("z", false, "Whether to exit with a zero exit code on success")
+	config = flag.String("c", "", "JSON file mapping additional identifier classes to param names")
 )
 
 func main() {
@@ -81,6 +122,12 @@ func main() {
 		invalidUsage()
 	}
 
+	classes, err := loadConfig(*config)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	initIdentifiers(classes)
+
 	for identifier := range stdIdentifiers {
 		current, err := loadIdentifiers(identifier)
 		if err != nil {
@@ -172,13 +219,53 @@ func extractParams(file string, wg *sync.WaitGroup, errCh chan error, paramCh ch
 		switch se := t.(type) {
 		case xml.StartElement:
 			elem = se.Name.Local
-			if elem == "param" {
-				var param recog.FingerprintParam
-				decoder.DecodeElement(&param, &se)
-				paramCh <- &param
+			if elem == "fingerprint" {
+				var fp recog.Fingerprint
+				decoder.DecodeElement(&fp, &se)
+				for _, param := range resolveParams(fp.Params) {
+					paramCh <- param
+				}
+			}
+		}
+	}
+}
+
+var reInterpolation = regexp.MustCompile(`\{([^\s{}]+)\}`)
+
+// resolveParams substitutes interpolated values that only reference other pos=0
+// params with static values, so identifiers assembled from those are tracked too.
+// Values that reference captures or other interpolated values are left unchanged.
+func resolveParams(params []*recog.FingerprintParam) []*recog.FingerprintParam {
+	static := make(map[string]string)
+	for _, param := range params {
+		if param.Position == "0" && !strings.Contains(param.Value, "{") {
+			static[param.Name] = param.Value
+		}
+	}
+
+	ret := make([]*recog.FingerprintParam, 0, len(params))
+	for _, param := range params {
+		if param.Position != "0" || !strings.Contains(param.Value, "{") {
+			ret = append(ret, param)
+			continue
+		}
+
+		resolved := true
+		value := reInterpolation.ReplaceAllStringFunc(param.Value, func(ref string) string {
+			v, ok := static[ref[1:len(ref)-1]]
+			if !ok {
+				resolved = false
+				return ref
 			}
+			return v
+		})
+		if !resolved {
+			ret = append(ret, param)
+			continue
 		}
+		ret = append(ret, &recog.FingerprintParam{Position: param.Position, Name: param.Name, Value: value})
 	}
+	return ret
 }
 
 func waitForErrs() chan error {
@@ -219,25 +306,8 @@ func waitForParams(wg *sync.WaitGroup) chan *recog.FingerprintParam {
 				strings.Contains(param.Value, "{") {
 				continue
 			}
-			switch param.Name {
-			case "os.vendor", "service.vendor", "service.component.vendor", "hw.vendor":
-				addToSet(curIdentifiers["vendor"], param.Value)
-			case "os.device", "service.device", "hw.device":
-				addToSet(curIdentifiers["device"], param.Value)
-			case "os.arch":
-				addToSet(curIdentifiers["os_architecture"], param.Value)
-			case "os.product":
-				addToSet(curIdentifiers["os_product"], param.Value)
-			case "os.family":
-				addToSet(curIdentifiers["os_family"], param.Value)
-			case "hw.product":
-				addToSet(curIdentifiers["hw_product"], param.Value)
-			case "hw.family":
-				addToSet(curIdentifiers["hw_family"], param.Value)
-			case "service.product", "service.component.product":
-				addToSet(curIdentifiers["service_product"], param.Value)
-			case "service.family":
-				addToSet(curIdentifiers["service_family"], param.Value)
+			for _, identifier := range paramClasses[param.Name] {
+				addToSet(curIdentifiers[identifier], param.Value)
 			}
 		}
 		wg.Done()
@@ -255,12 +325,15 @@ func loadIdentifiers(identifier string) (set, error) {
 		return nil, fmt.Errorf("failed to load %q identifiers: %s; is $RECOG_HOME configured", identifier, err)
 	}
 
-	identifiers := make(set)
-	scanner := bufio.NewScanner(bytes.NewReader(data))
-	for scanner.Scan() {
-		identifiers.add(scanner.Text())
+	values, err := recog.ReadIdentifiers(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %q identifiers: %s", identifier, err)
 	}
 
+	identifiers := make(set)
+	for _, v := range values {
+		identifiers.add(v)
+	}
 	return identifiers, nil
 }
 
@@ -276,7 +349,8 @@ func handleChanges(current set, original set, msg string, identifier string, wg
 
 	changes := false
 	for _, key := range original.keys() {
-		if _, ok := current[key]; ok {
+		// Existing entries are preserved when merging, so they are never removed
+		if _, ok := current[key]; ok || *merge {
 			continue
 		}
 
@@ -295,7 +369,19 @@ func handleChanges(current set, original set, msg string, identifier string, wg
 		changes = true
 	}
 
-	if *write && changes {
+	switch {
+	case *merge && changes:
+		merged := make(set)
+		for key := range original {
+			merged.add(key)
+		}
+		for key := range current {
+			merged.add(key)
+		}
+		if err := writeIdentifiers(identifier, merged.keys()); err != nil {
+			errCh <- err
+		}
+	case *write && changes:
 		if err := writeIdentifiers(identifier, current.keys()); err != nil {
 			errCh <- err
 		}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/runZeroInc/recog-go"
+)
+
+func TestCustomIdentifierClass(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "classes.json")
+	if err := os.WriteFile(path, []byte(`{"service_module": ["service.module"]}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	classes, err := loadConfig(path)
+	if err != nil {
+		t.Fatalf("loadConfig() failed: %s", err)
+	}
+	if _, ok := classes["vendor"]; !ok {
+		t.Errorf("loadConfig() should retain the default classes")
+	}
+	initIdentifiers(classes)
+
+	pwg := sync.WaitGroup{}
+	paramCh := waitForParams(&pwg)
+	paramCh <- &recog.FingerprintParam{Position: "0", Name: "service.module", Value: "mod_ssl"}
+	paramCh <- &recog.FingerprintParam{Position: "0", Name: "service.vendor", Value: "Apache"}
+	paramCh <- &recog.FingerprintParam{Position: "1", Name: "service.module"}
+	close(paramCh)
+	pwg.Wait()
+
+	if got := curIdentifiers["service_module"].keys(); len(got) != 1 || got[0] != "mod_ssl" {
+		t.Errorf("service_module identifiers = %v, expected [mod_ssl]", got)
+	}
+	if got := curIdentifiers["vendor"].keys(); len(got) != 1 || got[0] != "Apache" {
+		t.Errorf("vendor identifiers = %v, expected [Apache]", got)
+	}
+	if got := curIdentifiers["fields"].keys(); len(got) != 2 {
+		t.Errorf("fields identifiers = %v, expected both param names", got)
+	}
+}
+
+func TestResolveParams(t *testing.T) {
+	params := resolveParams([]*recog.FingerprintParam{
+		{Position: "0", Name: "service.vendor", Value: "Acme"},
+		{Position: "0", Name: "_tmp.family", Value: "Widget"},
+		{Position: "0", Name: "service.product", Value: "{service.vendor} {_tmp.family} Server"},
+		{Position: "1", Name: "service.version"},
+		{Position: "0", Name: "service.family", Value: "{service.version}"},
+	})
+
+	expected := map[string]string{
+		"service.vendor":  "Acme",
+		"_tmp.family":     "Widget",
+		"service.product": "Acme Widget Server",
+		"service.version": "",
+		"service.family":  "{service.version}",
+	}
+	if len(params) != len(expected) {
+		t.Fatalf("resolveParams() returned %d params, expected %d", len(params), len(expected))
+	}
+	for _, param := range params {
+		if param.Value != expected[param.Name] {
+			t.Errorf("%s = %q, expected %q", param.Name, param.Value, expected[param.Name])
+		}
+	}
+
+	initIdentifiers(identifierParams)
+	pwg := sync.WaitGroup{}
+	paramCh := waitForParams(&pwg)
+	for _, param := range params {
+		paramCh <- param
+	}
+	close(paramCh)
+	pwg.Wait()
+
+	if got := curIdentifiers["service_product"].keys(); len(got) != 1 || got[0] != "Acme Widget Server" {
+		t.Errorf("service_product identifiers = %v, expected the interpolated product", got)
+	}
+	if got := curIdentifiers["service_family"].keys(); len(got) != 0 {
+		t.Errorf("service_family identifiers = %v, unresolved values should be skipped", got)
+	}
+}
+
+func TestMergeIdentifiers(t *testing.T) {
+	recogHome = t.TempDir()
+	if err := os.Mkdir(filepath.Join(recogHome, "identifiers"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := writeIdentifiers("vendor", []string{"Apache", "Manual Vendor"}); err != nil {
+		t.Fatal(err)
+	}
+
+	original, err := loadIdentifiers("vendor")
+	if err != nil {
+		t.Fatalf("loadIdentifiers() failed: %s", err)
+	}
+	current := make(set)
+	current.add("Apache")
+	current.add("Acme")
+
+	*merge = true
+	defer func() { *merge = false }()
+
+	wg := sync.WaitGroup{}
+	errCh := make(chan error, 1)
+	msgCh := make(chan string, 10)
+	wg.Add(1)
+	handleChanges(current, original, "VENDOR", "vendor", &wg, errCh, msgCh)
+	close(errCh)
+	if err := <-errCh; err != nil {
+		t.Fatalf("handleChanges() failed: %s", err)
+	}
+
+	merged, err := loadIdentifiers("vendor")
+	if err != nil {
+		t.Fatalf("loadIdentifiers() failed: %s", err)
+	}
+	expected := []string{"Acme", "Apache", "Manual Vendor"}
+	if got := merged.keys(); !reflect.DeepEqual(got, expected) {
+		t.Errorf("merged identifiers = %v, expected %v", got, expected)
+	}
+	if foundRemoved {
+		t.Errorf("merging should not report manual entries as removed")
+	}
+	if !foundNew {
+		t.Errorf("merging should report newly discovered identifiers")
+	}
+}
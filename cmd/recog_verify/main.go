@@ -1,6 +1,9 @@
 package main
 
 import (
+	"flag"
+	"fmt"
+	"io/ioutil"
 	"os"
 	"path/filepath"
 
@@ -24,13 +27,133 @@ func visit(files *[]string) filepath.WalkFunc {
 	}
 }
 
+var (
+	coverage        = flag.Bool("coverage", false, "Report fingerprints without examples and captured params that no example asserts")
+	requireExamples = flag.Bool("require-examples", false, "Fail verification for fingerprints without examples")
+	examplesDir     = flag.String("examples-dir", "", "Directory holding the example files of a database read from stdin")
+)
+
+// fingerprintName returns the description of a fingerprint, or its pattern if it has none
+func fingerprintName(fp *recog.Fingerprint) string {
+	if fp.Description != nil && fp.Description.Text != "" {
+		return fp.Description.Text
+	}
+	return fp.Pattern
+}
+
+// reportCoverage logs the example coverage of a database
+func reportCoverage(file string, fdb *recog.FingerprintDB) {
+	report := fdb.ExampleCoverage()
+	for _, fp := range report.Untested {
+		log.Warnf("%s: fingerprint %q has no examples", file, fingerprintName(fp))
+	}
+	for _, pc := range report.Unasserted {
+		log.Warnf("%s: fingerprint %q param %s is not asserted by any example", file, fingerprintName(pc.Fingerprint), pc.Param)
+	}
+	log.Printf("%s: %d of %d fingerprints have no examples, %d captured params are not asserted",
+		file, len(report.Untested), len(fdb.Fingerprints), len(report.Unasserted))
+}
+
+// verify validates a loaded database and verifies its examples, logging any errors.
+// Fingerprints without examples fail when requireExamples is set.
+func verify(file string, fdb *recog.FingerprintDB, requireExamples bool) error {
+	var hasErr error
+	log.Printf("loaded %d fingerprints from %s", len(fdb.Fingerprints), file)
+	err := fdb.Validate()
+	if err != nil {
+		log.Errorf("error validating fingerprints in %s: %s", file, err)
+		hasErr = err
+	}
+	for _, warning := range fdb.Warnings() {
+		log.Warnf("%s: %s", file, warning)
+	}
+	fdb.RequireExamples = requireExamples
+	err = fdb.VerifyExamples("")
+	if err != nil {
+		log.Errorf("error verifying examples in %s: %s", file, err)
+		hasErr = err
+	}
+	if *coverage {
+		reportCoverage(file, fdb)
+	}
+	return hasErr
+}
+
+// checkExamples reports fingerprints without any examples, logging each one
+func checkExamples(file string, fdb *recog.FingerprintDB) error {
+	var hasErr error
+	for _, fp := range fdb.Fingerprints {
+		if len(fp.Examples) == 0 {
+			hasErr = fmt.Errorf("'%s' has no examples", fp.Pattern)
+			log.Errorf("error verifying examples in %s: %s", file, hasErr)
+		}
+	}
+	return hasErr
+}
+
+// skipExampleFiles removes examples held in external files, which cannot be
+// resolved without the path of the database, logging each one
+func skipExampleFiles(file string, fdb *recog.FingerprintDB) {
+	for _, fp := range fdb.Fingerprints {
+		examples := fp.Examples[:0]
+		for _, ex := range fp.Examples {
+			if fname, ok := ex.AttributeMap["_filename"]; ok {
+				log.Warnf("%s: fingerprint %q example file %s cannot be resolved from stdin, skipping", file, fingerprintName(fp), fname)
+				continue
+			}
+			examples = append(examples, ex)
+		}
+		fp.Examples = examples
+	}
+}
+
 func main() {
-	var files []string
-	if len(os.Args) < 2 {
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage %s [options] XML_FINGERPRINT_DIRECTORY\n", os.Args[0])
+		fmt.Fprintf(flag.CommandLine.Output(), "Validates fingerprints and verifies them against their examples.\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "A directory of - reads a single database from stdin, its example files\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "are skipped unless -examples-dir is set.\n\n")
+		fmt.Fprintf(flag.CommandLine.Output(), "Options:\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if flag.NArg() < 1 {
 		log.Fatalf("missing: recog xml directory")
 	}
 
-	err := filepath.Walk(os.Args[1], visit(&files))
+	if flag.Arg(0) == "-" {
+		xmlData, err := ioutil.ReadAll(os.Stdin)
+		if err != nil {
+			log.Fatalf("error reading stdin: %s", err)
+		}
+		fdb, err := recog.LoadFingerprintDB("stdin", xmlData)
+		if err != nil {
+			log.Fatalf("error loading fingerprints from stdin: %s", err)
+		}
+		var hasErr error
+		require := *requireExamples
+		if *examplesDir != "" {
+			fdb.ExamplesPath = *examplesDir
+		} else {
+			// Examples in files count as examples even though they cannot be verified
+			if require {
+				hasErr = checkExamples("stdin", &fdb)
+				require = false
+			}
+			skipExampleFiles("stdin", &fdb)
+		}
+		if err := verify("stdin", &fdb, require); err != nil {
+			hasErr = err
+		}
+		if hasErr != nil {
+			os.Exit(1)
+		}
+		os.Exit(0)
+	}
+
+	var files []string
+	err := filepath.Walk(flag.Arg(0), visit(&files))
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -42,11 +165,7 @@ func main() {
 		if err != nil {
 			log.Fatalf("error loading fingerprints from %s: %s", file, err)
 		}
-		log.Printf("loaded %d fingerprints from %s", len(fdb.Fingerprints), file)
-		fpath := file[:len(file)-len(filepath.Ext(file))]
-		err = fdb.VerifyExamples(fpath)
-		if err != nil {
-			log.Errorf("error verifying examples in %s: %s", file, err)
+		if err := verify(file, &fdb, *requireExamples); err != nil {
 			hasErr = err
 		}
 	}
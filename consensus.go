@@ -0,0 +1,79 @@
+package recog
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ConsensusValue is the value of an identifier that the most certain matches agree on
+type ConsensusValue struct {
+	// Value is the value with the highest weight
+	Value string
+	// Weight is the summed certainty of the matches reporting Value
+	Weight float64
+	// Alternatives are the other values reported for the identifier, by descending weight
+	Alternatives []WeightedValue
+}
+
+// WeightedValue is a value reported for an identifier and the summed certainty of its matches
+type WeightedValue struct {
+	Value  string
+	Weight float64
+}
+
+// Consensus reconciles the values of several matches, such as those returned by
+// FingerprintSet.MatchEverywhere. Each match adds its certainty to the weight of every
+// value it reports, so a value reported by two matches of certainty 0.5 outweighs one
+// reported by a single match of certainty 0.85. Repeated values count once per match.
+// Ties are broken by the value, so the result does not depend on the order of matches.
+// Unmatched matches, temporary values, and the matched and certainty keys are ignored.
+func Consensus(matches []*FingerprintMatch) map[string]ConsensusValue {
+	weights := make(map[string]map[string]float64)
+	for _, m := range matches {
+		if m == nil || !m.Matched {
+			continue
+		}
+		certainty, _ := strconv.ParseFloat(m.Values[CertaintyKey], 64)
+		for k, v := range m.Values {
+			if k == legacyMatchedKey || k == legacyCertaintyKey || strings.HasPrefix(k, ReservedPrefix) || strings.HasPrefix(k, "_tmp.") {
+				continue
+			}
+			values, ok := m.MultiValues[k]
+			if !ok {
+				values = []string{v}
+			}
+			seen := make(map[string]bool, len(values))
+			for _, v := range values {
+				if v == "" || seen[v] {
+					continue
+				}
+				seen[v] = true
+				if weights[k] == nil {
+					weights[k] = make(map[string]float64)
+				}
+				weights[k][v] += certainty
+			}
+		}
+	}
+
+	ret := make(map[string]ConsensusValue, len(weights))
+	for k, values := range weights {
+		ranked := make([]WeightedValue, 0, len(values))
+		for v, w := range values {
+			ranked = append(ranked, WeightedValue{Value: v, Weight: w})
+		}
+		sort.Slice(ranked, func(i, j int) bool {
+			if ranked[i].Weight != ranked[j].Weight {
+				return ranked[i].Weight > ranked[j].Weight
+			}
+			return ranked[i].Value < ranked[j].Value
+		})
+		cv := ConsensusValue{Value: ranked[0].Value, Weight: ranked[0].Weight}
+		if len(ranked) > 1 {
+			cv.Alternatives = ranked[1:]
+		}
+		ret[k] = cv
+	}
+	return ret
+}
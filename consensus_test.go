@@ -0,0 +1,42 @@
+package recog
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestConsensus(t *testing.T) {
+	newMatch := func(certainty string, values map[string]string) *FingerprintMatch {
+		m := &FingerprintMatch{Matched: true, Values: map[string]string{CertaintyKey: certainty, legacyCertaintyKey: certainty, MatchedKey: "test", legacyMatchedKey: "test"}}
+		for k, v := range values {
+			m.Values[k] = v
+		}
+		return m
+	}
+
+	matches := []*FingerprintMatch{
+		newMatch("0.85", map[string]string{"os.vendor": "Cisco", "os.product": "IOS"}),
+		newMatch("0.5", map[string]string{"os.vendor": "Cisco", "os.product": "IOS XE"}),
+		newMatch("0.5", map[string]string{"os.vendor": "Cisco", "os.product": "IOS XE"}),
+		newMatch("1.0", map[string]string{"os.vendor": "Cisco", "hw.product": "Catalyst"}),
+		{Matched: false, Values: map[string]string{"os.vendor": "Juniper"}},
+	}
+
+	expected := map[string]ConsensusValue{
+		"os.vendor":  {Value: "Cisco", Weight: 2.85},
+		"os.product": {Value: "IOS XE", Weight: 1.0, Alternatives: []WeightedValue{{Value: "IOS", Weight: 0.85}}},
+		"hw.product": {Value: "Catalyst", Weight: 1.0},
+	}
+	if cs := Consensus(matches); !reflect.DeepEqual(cs, expected) {
+		t.Errorf("Consensus() = %+v, expected %+v", cs, expected)
+	}
+
+	// The order of matches does not change the result of a tie
+	tied := []*FingerprintMatch{
+		newMatch("0.5", map[string]string{"os.product": "b"}),
+		newMatch("0.5", map[string]string{"os.product": "a"}),
+	}
+	if cs := Consensus(tied); cs["os.product"].Value != "a" {
+		t.Errorf("Consensus() chose %q for a tie, expected \"a\"", cs["os.product"].Value)
+	}
+}
@@ -0,0 +1,156 @@
+package recog
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// CPEDictionary reports which CPE vendors and products are known, such as those
+// listed in a copy of the NVD CPE dictionary. Parts are "a", "o", or "h".
+type CPEDictionary interface {
+	HasVendor(part string, vendor string) bool
+	HasProduct(part string, vendor string, product string) bool
+}
+
+// CPESet is a CPEDictionary held in memory
+type CPESet map[string]bool
+
+// NewCPESet returns a CPESet holding the vendor and product of each CPE
+func NewCPESet(cpes ...string) (CPESet, error) {
+	set := make(CPESet)
+	for _, cpe := range cpes {
+		if err := set.Add(cpe); err != nil {
+			return nil, err
+		}
+	}
+	return set, nil
+}
+
+// Add adds the vendor and product of a CPE 2.2 URI or CPE 2.3 formatted string
+func (s CPESet) Add(cpe string) error {
+	part, vendor, product, ok := parseCPE(cpe)
+	if !ok {
+		return fmt.Errorf("malformed cpe %s", cpe)
+	}
+	s[part+":"+vendor] = true
+	s[part+":"+vendor+":"+product] = true
+	return nil
+}
+
+// HasVendor reports whether the set has a product from vendor
+func (s CPESet) HasVendor(part string, vendor string) bool {
+	return s[part+":"+vendor]
+}
+
+// HasProduct reports whether the set has the product
+func (s CPESet) HasProduct(part string, vendor string, product string) bool {
+	return s[part+":"+vendor+":"+product]
+}
+
+// parseCPE returns the part, vendor, and product of a CPE 2.2 URI such as
+// cpe:/a:openbsd:openssh:7.4 or a CPE 2.3 formatted string such as
+// cpe:2.3:a:openbsd:openssh:7.4:*:*:*:*:*:*:*
+func parseCPE(cpe string) (part string, vendor string, product string, ok bool) {
+	var rest string
+	switch {
+	case strings.HasPrefix(cpe, "cpe:/"):
+		rest = strings.TrimPrefix(cpe, "cpe:/")
+	case strings.HasPrefix(cpe, "cpe:2.3:"):
+		rest = strings.TrimPrefix(cpe, "cpe:2.3:")
+	default:
+		return "", "", "", false
+	}
+
+	bits := strings.SplitN(rest, ":", 4)
+	if len(bits) < 3 || bits[1] == "" || bits[2] == "" {
+		return "", "", "", false
+	}
+	switch bits[0] {
+	case "a", "o", "h":
+	default:
+		return "", "", "", false
+	}
+	return bits[0], strings.ToLower(bits[1]), strings.ToLower(bits[2]), true
+}
+
+// CPEProblem describes a CPE param that is malformed or not found in a CPEDictionary
+type CPEProblem struct {
+	Fingerprint *Fingerprint
+	Param       string
+	CPE         string
+	Problem     string
+}
+
+// ValidateCPEsAgainst checks that the vendor and product of every CPE param value in
+// the database exist in the dictionary. Versions are ignored, and CPEs with templates
+// in the vendor or product are skipped since they are only known once matched.
+func ValidateCPEsAgainst(dict CPEDictionary, fdb *FingerprintDB) []CPEProblem {
+	ret := []CPEProblem{}
+	for _, fp := range fdb.Fingerprints {
+		for _, p := range fp.Params {
+			if p.Position != "0" || !strings.HasPrefix(p.Value, "cpe:") {
+				continue
+			}
+
+			problem := CPEProblem{Fingerprint: fp, Param: p.Name, CPE: p.Value}
+			part, vendor, product, ok := parseCPE(p.Value)
+			switch {
+			case !ok:
+				problem.Problem = "malformed cpe"
+			case varSubPattern.MatchString(vendor) || varSubPattern.MatchString(product):
+				continue
+			case !dict.HasVendor(part, vendor):
+				problem.Problem = fmt.Sprintf("unknown vendor %s", vendor)
+			case !dict.HasProduct(part, vendor, product):
+				problem.Problem = fmt.Sprintf("unknown product %s for vendor %s", product, vendor)
+			default:
+				continue
+			}
+			ret = append(ret, problem)
+		}
+	}
+	return ret
+}
+
+// CPEResult is a CPE value produced by a match
+type CPEResult struct {
+	// CPE is the value, such as cpe:/a:openbsd:openssh:7.4
+	CPE string
+	// Key is the param name holding the value, such as service.cpe23
+	Key string
+	// Kind is "os", "service", or "hw", taken from the first part of the key
+	Kind string
+	// Part is the CPE part, "a", "o", or "h", or empty when the CPE is malformed
+	Part string
+	// Fingerprint is the fingerprint that produced the value
+	Fingerprint *Fingerprint
+}
+
+// CPEs returns every *.cpe23 value of the match, including repeated values, ordered by key name
+func (m *FingerprintMatch) CPEs() []CPEResult {
+	keys := make([]string, 0, len(m.Values))
+	for k := range m.Values {
+		if strings.HasSuffix(k, ".cpe23") {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+
+	var ret []CPEResult
+	for _, k := range keys {
+		values, ok := m.MultiValues[k]
+		if !ok {
+			values = []string{m.Values[k]}
+		}
+		kind := strings.SplitN(k, ".", 2)[0]
+		for _, v := range values {
+			if v == "" {
+				continue
+			}
+			part, _, _, _ := parseCPE(v)
+			ret = append(ret, CPEResult{CPE: v, Key: k, Kind: kind, Part: part, Fingerprint: m.fingerprint})
+		}
+	}
+	return ret
+}
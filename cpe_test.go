@@ -0,0 +1,96 @@
+package recog
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestValidateCPEsAgainst(t *testing.T) {
+	dict, err := NewCPESet(
+		"cpe:2.3:a:openbsd:openssh:7.4:*:*:*:*:*:*:*",
+		"cpe:/a:apache:http_server:2.4.6",
+		"cpe:/o:linux:linux_kernel",
+	)
+	if err != nil {
+		t.Fatalf("NewCPESet() failed: %s", err)
+	}
+	if _, err := NewCPESet("cpe:/x:bad"); err == nil {
+		t.Errorf("NewCPESet() should fail for a malformed cpe")
+	}
+
+	xmlData := `<fingerprints matches="test">
+  <fingerprint pattern="^OpenSSH_(\S+)$">
+    <description>OpenSSH</description>
+    <param pos="1" name="service.version"/>
+    <param pos="0" name="service.cpe23" value="cpe:/a:openbsd:openssh:{service.version}"/>
+    <param pos="0" name="os.cpe23" value="cpe:/o:linux:linux_kernel:-"/>
+  </fingerprint>
+  <fingerprint pattern="^Apache">
+    <description>Apache typo</description>
+    <param pos="0" name="service.cpe23" value="cpe:/a:apache:http_sever:-"/>
+  </fingerprint>
+  <fingerprint pattern="^Acme">
+    <description>Unknown vendor</description>
+    <param pos="0" name="service.cpe23" value="cpe:/a:acme:server:-"/>
+  </fingerprint>
+  <fingerprint pattern="^Broken">
+    <description>Malformed</description>
+    <param pos="0" name="service.cpe23" value="cpe:/a:broken"/>
+  </fingerprint>
+  <fingerprint pattern="^(\S+) Server">
+    <description>Templated vendor</description>
+    <param pos="1" name="service.vendor"/>
+    <param pos="0" name="service.cpe23" value="cpe:/a:{service.vendor}:server:-"/>
+  </fingerprint>
+</fingerprints>`
+	fdb, err := LoadFingerprintDB("test.xml", []byte(xmlData))
+	if err != nil {
+		t.Fatalf("LoadFingerprintDB() failed: %s", err)
+	}
+
+	problems := ValidateCPEsAgainst(dict, &fdb)
+	expected := map[string]string{
+		"Apache typo":    "unknown product http_sever for vendor apache",
+		"Unknown vendor": "unknown vendor acme",
+		"Malformed":      "malformed cpe",
+	}
+	if len(problems) != len(expected) {
+		t.Fatalf("ValidateCPEsAgainst() returned %d problems, expected %d: %#v", len(problems), len(expected), problems)
+	}
+	for _, problem := range problems {
+		desc := problem.Fingerprint.Description.Text
+		if problem.Problem != expected[desc] || problem.Param != "service.cpe23" {
+			t.Errorf("%s: unexpected problem %q for %s", desc, problem.Problem, problem.CPE)
+		}
+	}
+}
+
+func TestMatchCPEs(t *testing.T) {
+	fp := &Fingerprint{
+		Pattern: `^OpenSSH_(\S+) Ubuntu$`,
+		Params: []*FingerprintParam{
+			{Position: "1", Name: "service.version"},
+			{Position: "0", Name: "service.cpe23", Value: "cpe:/a:openbsd:openssh:{service.version}"},
+			{Position: "0", Name: "os.cpe23", Value: "cpe:/o:canonical:ubuntu_linux:-"},
+			{Position: "0", Name: "service.component.cpe23", Value: "cpe:/a:openssl:openssl:-"},
+			{Position: "0", Name: "service.component.cpe23", Value: "cpe:/a:zlib:zlib:-"},
+		},
+	}
+	if err := fp.Normalize(); err != nil {
+		t.Fatalf("Normalize() failed: %s", err)
+	}
+	m := fp.Match("OpenSSH_7.4 Ubuntu")
+	if !m.Matched {
+		t.Fatalf("Match() failed: %v", m.Errors)
+	}
+
+	expected := []CPEResult{
+		{CPE: "cpe:/o:canonical:ubuntu_linux:-", Key: "os.cpe23", Kind: "os", Part: "o", Fingerprint: fp},
+		{CPE: "cpe:/a:openssl:openssl:-", Key: "service.component.cpe23", Kind: "service", Part: "a", Fingerprint: fp},
+		{CPE: "cpe:/a:zlib:zlib:-", Key: "service.component.cpe23", Kind: "service", Part: "a", Fingerprint: fp},
+		{CPE: "cpe:/a:openbsd:openssh:7.4", Key: "service.cpe23", Kind: "service", Part: "a", Fingerprint: fp},
+	}
+	if cpes := m.CPEs(); !reflect.DeepEqual(cpes, expected) {
+		t.Errorf("CPEs() = %+v, expected %+v", cpes, expected)
+	}
+}
@@ -0,0 +1,78 @@
+package recog
+
+import (
+	"fmt"
+)
+
+// ErrParamIndex is reported when a param position is not a valid capture group index
+type ErrParamIndex struct {
+	Name     string
+	Position string
+	// Err is the parse error of the position, if any
+	Err error
+}
+
+func (e *ErrParamIndex) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("param index %s is invalid: %s", e.Position, e.Err)
+	}
+	return fmt.Sprintf("param index %s is invalid", e.Position)
+}
+
+// Unwrap returns the parse error of the position
+func (e *ErrParamIndex) Unwrap() error {
+	return e.Err
+}
+
+// ErrCaptureMissing is reported when a param refers to a capture group the match did not produce
+type ErrCaptureMissing struct {
+	Name     string
+	Position string
+	// Captured is the number of submatches, including the whole match
+	Captured int
+}
+
+func (e *ErrCaptureMissing) Error() string {
+	return fmt.Sprintf("param index %s was not captured (%d elements)", e.Position, e.Captured)
+}
+
+// ErrSubstitution is reported when a {key} template in a param value cannot be resolved
+type ErrSubstitution struct {
+	// Name is the param holding the template
+	Name string
+	// Key is the unresolved template key
+	Key string
+	// Context is set when the key refers to a caller-supplied {_ctx.*} value
+	Context bool
+	// Cycle is set when the key refers back to the param being substituted
+	Cycle bool
+}
+
+func (e *ErrSubstitution) Error() string {
+	if e.Context {
+		return fmt.Sprintf("context value %s was not provided", e.Key)
+	}
+	if e.Cycle {
+		return fmt.Sprintf("param %s could not be substituted: cyclic reference from %s", e.Key, e.Name)
+	}
+	return fmt.Sprintf("param %s could not be substituted", e.Key)
+}
+
+// ErrExample is reported by VerifyExamples when an example matches with errors
+type ErrExample struct {
+	Pattern string
+	Data    string
+	Errors  []error
+}
+
+func (e *ErrExample) Error() string {
+	return fmt.Sprintf("failed to match '%s' (%s) with errors: %v", e.Pattern, e.Data, e.Errors)
+}
+
+// Unwrap returns the first match error
+func (e *ErrExample) Unwrap() error {
+	if len(e.Errors) == 0 {
+		return nil
+	}
+	return e.Errors[0]
+}
@@ -0,0 +1,90 @@
+package recog
+
+import (
+	"errors"
+	"strconv"
+	"testing"
+)
+
+func TestMatchErrors(t *testing.T) {
+	fp := &Fingerprint{
+		Pattern: `^Acme v(\d+)`,
+		Params: []*FingerprintParam{
+			{Position: "x", Name: "service.edition"},
+			{Position: "-1", Name: "service.family"},
+			{Position: "2", Name: "service.version"},
+			{Position: "0", Name: "service.product", Value: "Acme {service.vendor}"},
+			{Position: "0", Name: "host.name", Value: "{_ctx.host}"},
+		},
+	}
+	if err := fp.Normalize(); err != nil {
+		t.Fatalf("Normalize() failed: %s", err)
+	}
+
+	m := fp.Match("Acme v1")
+	if !m.Matched || len(m.Errors) != 5 {
+		t.Fatalf("Match() returned unexpected errors: %#v", m)
+	}
+
+	indexes := map[string]bool{}
+	substitutions := map[string]bool{}
+	for _, err := range m.Errors {
+		var indexErr *ErrParamIndex
+		var captureErr *ErrCaptureMissing
+		var subErr *ErrSubstitution
+		switch {
+		case errors.As(err, &indexErr):
+			indexes[indexErr.Name] = true
+			if indexErr.Name == "service.edition" {
+				var numErr *strconv.NumError
+				if !errors.As(err, &numErr) {
+					t.Errorf("ErrParamIndex should wrap the parse error: %#v", indexErr.Err)
+				}
+			}
+		case errors.As(err, &captureErr):
+			if captureErr.Name != "service.version" || captureErr.Position != "2" || captureErr.Captured != 2 {
+				t.Errorf("unexpected ErrCaptureMissing: %#v", captureErr)
+			}
+		case errors.As(err, &subErr):
+			substitutions[subErr.Key] = subErr.Context
+			if subErr.Key == "service.vendor" && subErr.Name != "service.product" {
+				t.Errorf("ErrSubstitution has the wrong param: %#v", subErr)
+			}
+		default:
+			t.Errorf("unexpected error type %T: %s", err, err)
+		}
+	}
+	if !indexes["service.edition"] || !indexes["service.family"] {
+		t.Errorf("missing ErrParamIndex errors: %v", indexes)
+	}
+	if context, ok := substitutions["service.vendor"]; !ok || context {
+		t.Errorf("missing ErrSubstitution for service.vendor: %v", substitutions)
+	}
+	if context, ok := substitutions["_ctx.host"]; !ok || !context {
+		t.Errorf("missing context ErrSubstitution for _ctx.host: %v", substitutions)
+	}
+}
+
+func TestExampleErrors(t *testing.T) {
+	fp := &Fingerprint{
+		Pattern:  `^Acme v(\d+)`,
+		Params:   []*FingerprintParam{{Position: "2", Name: "service.version"}},
+		Examples: []*FingerprintExample{{Text: "Acme v1"}},
+	}
+	if err := fp.Normalize(); err != nil {
+		t.Fatalf("Normalize() failed: %s", err)
+	}
+
+	err := fp.VerifyExamples(".")
+	var exampleErr *ErrExample
+	if !errors.As(err, &exampleErr) || exampleErr.Data != "Acme v1" {
+		t.Fatalf("VerifyExamples() should return an ErrExample: %#v", err)
+	}
+	var captureErr *ErrCaptureMissing
+	if !errors.As(err, &captureErr) {
+		t.Errorf("ErrExample should wrap the match error: %#v", exampleErr.Errors)
+	}
+	if expected := "failed to match '" + fp.PatternCompiled.String() + "' (Acme v1) with errors: [param index 2 was not captured (2 elements)]"; err.Error() != expected {
+		t.Errorf("unexpected message %q", err.Error())
+	}
+}
@@ -0,0 +1,53 @@
+package recog
+
+import (
+	"encoding/base64"
+	"encoding/xml"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// ToExample returns an example of the fingerprint that produced the match, holding the
+// input and the values of the match as the attributes it asserts. Values set by the
+// library, such as the matched description and certainty, are left out. Input that
+// holds control characters other than newlines and tabs, or that is not valid UTF-8,
+// is base64 encoded since XML cannot hold it as text.
+func (m *FingerprintMatch) ToExample(input string) *FingerprintExample {
+	ex := &FingerprintExample{Text: input, AttributeMap: make(map[string]string)}
+	if needsEncoding(input) {
+		ex.Text = base64.StdEncoding.EncodeToString([]byte(input))
+		ex.AttributeMap["_encoding"] = "base64"
+	}
+
+	for k, v := range m.Values {
+		if k == legacyMatchedKey || k == legacyCertaintyKey || strings.HasPrefix(k, ReservedPrefix) || strings.HasPrefix(k, "_tmp.") {
+			continue
+		}
+		ex.AttributeMap[k] = v
+	}
+
+	keys := make([]string, 0, len(ex.AttributeMap))
+	for k := range ex.AttributeMap {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		ex.Values = append(ex.Values, xml.Attr{Name: xml.Name{Local: k}, Value: ex.AttributeMap[k]})
+	}
+	return ex
+}
+
+// needsEncoding reports whether data cannot be held as example text
+func needsEncoding(data string) bool {
+	if !utf8.ValidString(data) {
+		return true
+	}
+	for _, r := range data {
+		if r != '\n' && r != '\t' && unicode.IsControl(r) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,56 @@
+package recog
+
+import (
+	"encoding/xml"
+	"testing"
+)
+
+func TestToExample(t *testing.T) {
+	xmlData := `<fingerprints matches="test">
+  <fingerprint pattern="^Acme Server v(\d+)">
+    <description>Acme</description>
+    <param pos="1" name="service.version"/>
+    <param pos="0" name="service.vendor" value="Acme"/>
+    <param pos="0" name="service.cpe23" value="cpe:/a:acme:server:{service.version}"/>
+  </fingerprint>
+</fingerprints>`
+	fdb, err := LoadFingerprintDB("test.xml", []byte(xmlData))
+	if err != nil {
+		t.Fatalf("LoadFingerprintDB() failed: %s", err)
+	}
+	fp := fdb.Fingerprints[0]
+
+	for _, input := range []string{"Acme Server v2", "Acme Server v3\r\n\x00\x01"} {
+		m := fdb.MatchFirst(input)
+		if !m.Matched {
+			t.Fatalf("MatchFirst(%q) failed to match", input)
+		}
+		ex := m.ToExample(input)
+		if _, ok := ex.AttributeMap[legacyCertaintyKey]; ok {
+			t.Errorf("ToExample(%q) kept the library value %s", input, legacyCertaintyKey)
+		}
+		if ex.AttributeMap["service.version"] != m.Values["service.version"] {
+			t.Errorf("ToExample(%q) service.version = %q", input, ex.AttributeMap["service.version"])
+		}
+
+		// The example survives a round trip through XML and verifies
+		data, err := xml.Marshal(ex)
+		if err != nil {
+			t.Fatalf("xml.Marshal() failed: %s", err)
+		}
+		parsed := &FingerprintExample{}
+		if err := xml.Unmarshal(data, parsed); err != nil {
+			t.Fatalf("xml.Unmarshal(%s) failed: %s", data, err)
+		}
+		fp.Examples = []*FingerprintExample{parsed}
+		if err := fp.Normalize(); err != nil {
+			t.Fatalf("Normalize() failed: %s", err)
+		}
+		if len(fp.Examples[0].AttributeMap) != len(ex.AttributeMap) {
+			t.Errorf("ToExample(%q) attributes %v did not survive XML: %v", input, ex.AttributeMap, fp.Examples[0].AttributeMap)
+		}
+		if err := fp.VerifyExamples(""); err != nil {
+			t.Errorf("VerifyExamples() failed for the example of %q: %s", input, err)
+		}
+	}
+}
@@ -0,0 +1,103 @@
+package recog
+
+import (
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"math/bits"
+	"strconv"
+)
+
+// FaviconDatabases lists the databases consulted by MatchFavicon, in order, with the
+// hash function their fingerprints are written against. The embedded favicons.xml
+// database uses MD5 hashes, while favicon.mmh3 databases are user-provided.
+var FaviconDatabases = []struct {
+	Name string
+	Hash func(data []byte) string
+}{
+	{"favicon.md5", FaviconMD5},
+	{"favicon.mmh3", FaviconHash},
+}
+
+// FaviconMD5 returns the hex-encoded MD5 hash of a favicon
+func FaviconMD5(data []byte) string {
+	sum := md5.Sum(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// FaviconHash returns the favicon hash popularized by Shodan: the signed 32-bit
+// MurmurHash3 of the base64 encoding of the favicon, with a newline after every 76
+// characters and at the end, formatted as a decimal number. Empty data is not encoded.
+func FaviconHash(data []byte) string {
+	if len(data) == 0 {
+		return strconv.Itoa(int(int32(murmur3(nil, 0))))
+	}
+
+	encoded := base64.StdEncoding.EncodeToString(data)
+	wrapped := make([]byte, 0, len(encoded)+len(encoded)/76+1)
+	for len(encoded) > 76 {
+		wrapped = append(wrapped, encoded[:76]...)
+		wrapped = append(wrapped, '\n')
+		encoded = encoded[76:]
+	}
+	wrapped = append(wrapped, encoded...)
+	wrapped = append(wrapped, '\n')
+	return strconv.Itoa(int(int32(murmur3(wrapped, 0))))
+}
+
+// murmur3 returns the 32-bit x86 MurmurHash3 of data
+func murmur3(data []byte, seed uint32) uint32 {
+	const c1, c2 = 0xcc9e2d51, 0x1b873593
+
+	h := seed
+	nblocks := len(data) / 4
+	for i := 0; i < nblocks; i++ {
+		k := binary.LittleEndian.Uint32(data[i*4:])
+		k *= c1
+		k = bits.RotateLeft32(k, 15)
+		k *= c2
+		h ^= k
+		h = bits.RotateLeft32(h, 13)
+		h = h*5 + 0xe6546b64
+	}
+
+	tail := data[nblocks*4:]
+	var k uint32
+	switch len(tail) {
+	case 3:
+		k ^= uint32(tail[2]) << 16
+		fallthrough
+	case 2:
+		k ^= uint32(tail[1]) << 8
+		fallthrough
+	case 1:
+		k ^= uint32(tail[0])
+		k *= c1
+		k = bits.RotateLeft32(k, 15)
+		k *= c2
+		h ^= k
+	}
+
+	h ^= uint32(len(data))
+	h ^= h >> 16
+	h *= 0x85ebca6b
+	h ^= h >> 13
+	h *= 0xc2b2ae35
+	h ^= h >> 16
+	return h
+}
+
+// MatchFavicon hashes a favicon and matches it against each loaded database in
+// FaviconDatabases, returning the first match
+func (fs *FingerprintSet) MatchFavicon(data []byte) *FingerprintMatch {
+	for _, db := range FaviconDatabases {
+		if _, ok := fs.Databases[db.Name]; !ok {
+			continue
+		}
+		if m := fs.MatchFirst(db.Name, db.Hash(data)); m.Matched {
+			return m
+		}
+	}
+	return &FingerprintMatch{Matched: false}
+}
@@ -0,0 +1,79 @@
+package recog
+
+import (
+	"testing"
+)
+
+func TestMurmur3(t *testing.T) {
+	tests := []struct {
+		data     string
+		expected int32
+	}{
+		{"", 0},
+		{"foo", -156908512},
+		{"hello", 613153351},
+	}
+	for _, tc := range tests {
+		if got := int32(murmur3([]byte(tc.data), 0)); got != tc.expected {
+			t.Errorf("murmur3(%q) = %d, expected %d", tc.data, got, tc.expected)
+		}
+	}
+}
+
+func TestFaviconHash(t *testing.T) {
+	// 512 bytes encode to several wrapped base64 lines, matching
+	// mmh3.hash(codecs.encode(data, "base64")) in Python
+	data := make([]byte, 512)
+	for i := range data {
+		data[i] = byte(i)
+	}
+	if got := FaviconHash(data); got != "-1173581353" {
+		t.Errorf("FaviconHash() = %s, expected -1173581353", got)
+	}
+
+	tests := []struct {
+		size     int
+		expected string
+	}{
+		{0, "0"},
+		{3, "304933308"},
+		{57, "459585070"},
+		{114, "1266597604"},
+	}
+	for _, tc := range tests {
+		if got := FaviconHash(data[:tc.size]); got != tc.expected {
+			t.Errorf("FaviconHash() of %d bytes = %s, expected %s", tc.size, got, tc.expected)
+		}
+	}
+}
+
+func TestMatchFavicon(t *testing.T) {
+	data := make([]byte, 512)
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	xmlData := `<fingerprints matches="favicon.mmh3">
+  <fingerprint pattern="^-1173581353$">
+    <description>Acme appliance</description>
+    <param pos="0" name="service.product" value="Acme"/>
+  </fingerprint>
+</fingerprints>`
+	fdb, err := LoadFingerprintDB("favicon_mmh3.xml", []byte(xmlData))
+	if err != nil {
+		t.Fatalf("LoadFingerprintDB() failed: %s", err)
+	}
+
+	fset := NewFingerprintSet()
+	if m := fset.MatchFavicon(data); m.Matched {
+		t.Errorf("MatchFavicon() should not match without favicon databases: %#v", m)
+	}
+	fset.addDatabase(&fdb)
+	if m := fset.MatchFavicon(data); !m.Matched || m.Values["service.product"] != "Acme" {
+		t.Errorf("MatchFavicon() failed to match the favicon hash: %#v", m)
+	}
+
+	if got := FaviconMD5([]byte("favicon")); got != "d02a42d9cb3dec9320e5f550278911c7" {
+		t.Errorf("FaviconMD5() = %s", got)
+	}
+}
@@ -1,16 +1,24 @@
 package recog
 
 import (
+	"crypto/sha256"
 	"encoding/base64"
+	"encoding/hex"
 	"encoding/xml"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"regexp"
 	"regexp/syntax"
+	"sort"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
+	"unicode"
 
 	log "github.com/sirupsen/logrus"
 )
@@ -25,6 +33,58 @@ type FingerprintParam struct {
 	Position string `xml:"pos,attr"  json:"pos,omitempty"`
 	Name     string `xml:"name,attr"  json:"name,omitempty"`
 	Value    string `xml:"value,attr,omitempty"  json:"value,omitempty"`
+	// Require is the index of a capture group that must be non-empty for the param
+	// to be set, such as an optional version group
+	Require string `xml:"require,attr,omitempty" json:"require,omitempty"`
+	// Trim removes leading and trailing whitespace from the captured value when
+	// "true", and TrimChars removes any of the given characters. Captured values
+	// are trimmed before templates that reference them are interpolated.
+	Trim      string `xml:"trim,attr,omitempty" json:"trim,omitempty"`
+	TrimChars string `xml:"trim_chars,attr,omitempty" json:"trim_chars,omitempty"`
+	// Map names a lookup table of the fingerprint that translates the captured value,
+	// after it is trimmed, such as a numeric model code to a product name
+	Map string `xml:"map,attr,omitempty" json:"map,omitempty"`
+}
+
+// FingerprintMap is a lookup table translating captured values, see FingerprintParam.Map.
+// Values without an entry are translated to Default, or kept as captured when Default is empty.
+type FingerprintMap struct {
+	Name    string                 `xml:"name,attr" json:"name,omitempty"`
+	Default string                 `xml:"default,attr,omitempty" json:"default,omitempty"`
+	Entries []*FingerprintMapEntry `xml:"entry,omitempty" json:"entry,omitempty"`
+}
+
+// FingerprintMapEntry translates a captured Key to Value
+type FingerprintMapEntry struct {
+	Key   string `xml:"key,attr" json:"key"`
+	Value string `xml:"value,attr" json:"value"`
+}
+
+// trim applies the Trim and TrimChars options to a captured value
+func (p *FingerprintParam) trim(v string) string {
+	if p.Trim == "" && p.TrimChars == "" {
+		return v
+	}
+	space, _ := strconv.ParseBool(p.Trim)
+	return strings.TrimFunc(v, func(r rune) bool {
+		return (space && unicode.IsSpace(r)) || strings.ContainsRune(p.TrimChars, r)
+	})
+}
+
+// lookup translates a captured value with the map named by the param, if any
+func (fp *Fingerprint) lookup(p *FingerprintParam, v string) string {
+	if p.Map == "" {
+		return v
+	}
+	if mapped, ok := fp.lookups[p.Map][v]; ok {
+		return mapped
+	}
+	for _, m := range fp.Maps {
+		if m.Name == p.Map && m.Default != "" {
+			return m.Default
+		}
+	}
+	return v
 }
 
 // FingerprintExample contains an example match string
@@ -37,7 +97,7 @@ type FingerprintExample struct {
 
 // Fingerprint represents a unique Recog fingerprint definition
 type Fingerprint struct {
-	XMLName         xml.Name                `xml:"fingerprint"`
+	XMLName         xml.Name                `xml:"fingerprint" json:"-"`
 	Pattern         string                  `xml:"pattern,attr" json:"pattern,omitempty"`
 	Flags           string                  `xml:"flags,attr,omitempty"  json:"flags,omitempty"`
 	Description     *FingerprintDescription `xml:"description,omitempty" json:"description,omitempty"`
@@ -45,124 +105,672 @@ type Fingerprint struct {
 	Params          []*FingerprintParam     `xml:"param,omitempty" json:"param,omitempty"`
 	Certainty       string                  `xml:"certainty,attr,omitempty" json:"certainty,omitempty"`
 	PatternCompiled *regexp.Regexp          `xml:"-" json:"-"`
+	// PatternFolded is a case-insensitive variant of PatternCompiled, compiled
+	// when the owning FingerprintDB has FoldCase set
+	PatternFolded *regexp.Regexp `xml:"-" json:"-"`
+	// Patterns are alternative patterns sharing the same params, given as
+	// pattern elements and tried in order after the pattern attribute
+	Patterns         []string         `xml:"pattern,omitempty" json:"patterns,omitempty"`
+	PatternsCompiled []*regexp.Regexp `xml:"-" json:"-"`
+	// AntiPatterns veto a match of the fingerprint when any of them also matches the data
+	AntiPatterns         []string         `xml:"anti-pattern,omitempty" json:"anti_patterns,omitempty"`
+	AntiPatternsCompiled []*regexp.Regexp `xml:"-" json:"-"`
+	// Anchored overrides FingerprintDB.Anchored for this fingerprint when set to
+	// "true" or "false"
+	Anchored string `xml:"anchored,attr,omitempty" json:"anchored,omitempty"`
+	// Tags is a comma or space separated list of tags, such as deprecated or
+	// vendor:cisco, used to select fingerprints with MatchFirstWithTags
+	Tags    string   `xml:"tags,attr,omitempty" json:"tags,omitempty"`
+	TagList []string `xml:"-" json:"-"`
+	// Maps are lookup tables used by params to translate captured values
+	Maps []*FingerprintMap `xml:"map,omitempty" json:"map,omitempty"`
+
+	// lookups indexes the entries of Maps by map name and key
+	lookups map[string]map[string]string
+	// examplesPath overrides FingerprintDB.ExamplesPath for a fingerprint added from a
+	// partial database, whose external examples live next to the partial database
+	examplesPath string
+
+	// translations describes the rewrites applied to the patterns by Normalize
+	translations []string
+	// matchers and matchersFolded hold every compiled pattern in match order
+	matchers       []*regexp.Regexp
+	matchersFolded []*regexp.Regexp
+	// antiFolded holds case-insensitive variants of AntiPatternsCompiled
+	antiFolded []*regexp.Regexp
+	// anyCRLF is set by the REG_LINE_ANY_CRLF flag, see lineBreaks
+	anyCRLF bool
+	// minLength is the minimum input length the patterns can match
+	minLength int
+
+	// compileOnce is set when compiling the patterns was deferred by a lazy normalize
+	compileOnce *sync.Once
+	compileOpts compileOptions
+	compileErr  error
+}
+
+// compileOptions controls how the patterns of a fingerprint are compiled
+type compileOptions struct {
+	// fold also compiles case-insensitive variants of the patterns
+	fold bool
+	// lazy defers compiling the patterns until first use, unless a budget is set
+	lazy bool
+	// anchored anchors the patterns unless the fingerprint overrides it
+	anchored bool
+	// maxCaptures limits the capture groups of each pattern, DefaultMaxCaptureGroups if zero
+	maxCaptures int
+	// budget limits the total program size of the patterns, including the
+	// case-insensitive variants, see Limits.MaxProgramSize
+	budget *programBudget
 }
 
+// DefaultMaxCaptureGroups is the number of capture groups a fingerprint pattern may
+// declare when FingerprintDB.MaxCaptureGroups is not set. The embedded fingerprints
+// use far fewer.
+const DefaultMaxCaptureGroups = 100
+
 var flagsPattern = regexp.MustCompile("[|,]")
 
 // Normalize processes a fingerprint to make it easier to use
 func (fp *Fingerprint) Normalize() error {
+	return fp.normalize(compileOptions{})
+}
+
+// normalize processes a fingerprint and compiles its patterns as described by opts
+func (fp *Fingerprint) normalize(opts compileOptions) error {
+	for _, ex := range fp.Examples {
+		ex.AttributeMap = make(map[string]string)
+		for _, attr := range ex.Values {
+			ex.AttributeMap[attr.Name.Local] = attr.Value
+		}
+	}
+
+	fp.TagList = strings.FieldsFunc(fp.Tags, func(r rune) bool {
+		return r == ',' || unicode.IsSpace(r)
+	})
+
+	fp.lookups = nil
+	for _, m := range fp.Maps {
+		if fp.lookups == nil {
+			fp.lookups = make(map[string]map[string]string, len(fp.Maps))
+		}
+		entries := make(map[string]string, len(m.Entries))
+		for _, e := range m.Entries {
+			entries[e.Key] = e.Value
+		}
+		fp.lookups[m.Name] = entries
+	}
+
+	// Set a default certainty
+	if fp.Certainty == "" {
+		fp.Certainty = "0.85"
+	}
+
+	// The program size budget is charged as the patterns compile, which cannot wait
+	if opts.lazy && opts.budget == nil {
+		fp.PatternCompiled, fp.PatternFolded, fp.PatternsCompiled = nil, nil, nil
+		fp.matchers, fp.matchersFolded, fp.translations = nil, nil, nil
+		fp.AntiPatternsCompiled, fp.antiFolded = nil, nil
+		fp.minLength = 0
+		fp.compileOnce = &sync.Once{}
+		fp.compileOpts = opts
+		fp.compileErr = nil
+		return nil
+	}
+	fp.compileOnce = nil
+	return fp.compilePatterns(opts)
+}
+
+// ensureCompiled compiles the patterns of a lazily normalized fingerprint, returning
+// any compile error. The patterns are only compiled once.
+func (fp *Fingerprint) ensureCompiled() error {
+	if fp.compileOnce == nil {
+		return nil
+	}
+	fp.compileOnce.Do(func() {
+		fp.compileErr = fp.compilePatterns(fp.compileOpts)
+	})
+	return fp.compileErr
+}
+
+// compilePatterns compiles each pattern of the fingerprint
+func (fp *Fingerprint) compilePatterns(opts compileOptions) error {
 	// Recog uses PCRE so set the Perl compatibility flag here
 	flags := syntax.PerlX
 	flagStrings := flagsPattern.Split(fp.Flags, -1)
 
+	// Go has no extended mode, it is implemented by translating the pattern
+	extended := false
+	anyCRLF := false
 	for fi := range flagStrings {
 		switch flagStrings[fi] {
+		case "REG_EXTENDED", "EXTENDED":
+			extended = true
 		case "REG_ICASE", "IGNORECASE":
 			flags |= syntax.FoldCase
-		case "REG_DOT_NEWLINE", "REG_MULTILINE", "REG_LINE_ANY_CRLF":
+		case "REG_DOT_NEWLINE", "REG_MULTILINE":
+			// Only LF terminates lines, see FingerprintDB.NormalizeLineEndings
 			flags |= syntax.MatchNL
+		case "REG_LINE_ANY_CRLF":
+			// CR, LF, and CRLF all terminate lines, see lineBreaks
+			flags |= syntax.MatchNL
+			anyCRLF = true
+		}
+	}
+	fp.anyCRLF = anyCRLF
+
+	fold, anchored := opts.fold, opts.anchored
+	maxCaptures := opts.maxCaptures
+	if maxCaptures == 0 {
+		maxCaptures = DefaultMaxCaptureGroups
+	}
+	if fp.Anchored != "" {
+		var err error
+		if anchored, err = strconv.ParseBool(fp.Anchored); err != nil {
+			return fmt.Errorf("invalid anchored value %q for [%s]: %s", fp.Anchored, fp.Pattern, err)
+		}
+	}
+
+	// The pattern attribute is tried first, followed by any pattern elements
+	sources := fp.Patterns
+	if fp.Pattern != "" || len(fp.Patterns) == 0 {
+		sources = append([]string{fp.Pattern}, fp.Patterns...)
+	}
+
+	fp.translations = nil
+	fp.matchers = make([]*regexp.Regexp, 0, len(sources))
+	fp.matchersFolded = nil
+	fp.minLength = -1
+	for _, source := range sources {
+		re, parsed, err := fp.compile(source, flags, extended, anchored, opts.budget)
+		if err != nil {
+			return err
+		}
+		if n := minLength(parsed); fp.minLength < 0 || n < fp.minLength {
+			fp.minLength = n
+		}
+		// Limit the submatches allocated for each match of untrusted patterns
+		if re.NumSubexp() > maxCaptures {
+			return fmt.Errorf("regexp [%s] has %d capture groups, the limit is %d", source, re.NumSubexp(), maxCaptures)
 		}
+		fp.matchers = append(fp.matchers, re)
+
+		if fold {
+			// The translations were already recorded for the case-sensitive pattern
+			translations := fp.translations
+			re, _, err := fp.compile(source, flags|syntax.FoldCase, extended, anchored, opts.budget)
+			fp.translations = translations
+			if err != nil {
+				return err
+			}
+			fp.matchersFolded = append(fp.matchersFolded, re)
+		}
+	}
+
+	fp.PatternCompiled = fp.matchers[0]
+	fp.PatternsCompiled = fp.matchers[len(fp.matchers)-len(fp.Patterns):]
+	fp.PatternFolded = nil
+	if fold {
+		fp.PatternFolded = fp.matchersFolded[0]
 	}
 
-	// Workaround for recog #209 (use of \u0000 in telnet_banners.xml)
-	fp.Pattern = strings.Replace(fp.Pattern, "\\u0000", "\\x00", -1)
+	fp.AntiPatternsCompiled, fp.antiFolded = nil, nil
+	for _, source := range fp.AntiPatterns {
+		re, _, err := fp.compile(source, flags, extended, false, opts.budget)
+		if err != nil {
+			return err
+		}
+		fp.AntiPatternsCompiled = append(fp.AntiPatternsCompiled, re)
+
+		if fold {
+			translations := fp.translations
+			re, _, err := fp.compile(source, flags|syntax.FoldCase, extended, false, opts.budget)
+			fp.translations = translations
+			if err != nil {
+				return err
+			}
+			fp.antiFolded = append(fp.antiFolded, re)
+		}
+	}
+	return nil
+}
+
+// vetoed reports whether an anti-pattern of the fingerprint matches data
+func (fp *Fingerprint) vetoed(data string, folded bool) bool {
+	antiPatterns := fp.AntiPatternsCompiled
+	if folded {
+		antiPatterns = fp.antiFolded
+	}
+	for _, re := range antiPatterns {
+		if re.MatchString(data) {
+			return true
+		}
+	}
+	return false
+}
+
+// compile translates and compiles a single pattern of the fingerprint. An anchored
+// pattern only matches at the start of the input, or at the start of any line when
+// the pattern spans lines. The parsed pattern is returned for analysis. A non-nil
+// budget is charged for the program size of the pattern before it is compiled.
+func (fp *Fingerprint) compile(source string, flags syntax.Flags, extended bool, anchored bool, budget *programBudget) (*regexp.Regexp, *syntax.Regexp, error) {
+	// Translate Ruby syntax such as \uXXXX escapes (recog #209, \u0000 in telnet_banners.xml)
+	pattern, translations, err := translatePattern(source, extended, flags&syntax.FoldCase != 0)
+	if err != nil {
+		desc := ""
+		if fp.Description != nil {
+			desc = fp.Description.Text
+		}
+		return nil, nil, fmt.Errorf("unsupported regexp [%s] in %q: %s", source, desc, err)
+	}
+	fp.translations = append(fp.translations, translations...)
 
 	// Using (?m) also implies (?s), set the option
 	// Note: Ruby does not support explicit '(?s)'
-	if strings.HasPrefix(fp.Pattern, "(?m)") {
+	if strings.HasPrefix(pattern, "(?m)") {
 		flags |= syntax.MatchNL
 	}
 
 	// Parse the regular expression
-	parsed, err := syntax.Parse(fp.Pattern, flags)
+	parsed, err := syntax.Parse(pattern, flags)
 	if err != nil {
-		return fmt.Errorf("bad regexp syntax [%s]: %s", fp.Pattern, err)
+		return nil, nil, fmt.Errorf("bad regexp syntax [%s]: %s", source, err)
 	}
 
-	// Compile the parsed syntax tree
-	fp.PatternCompiled, err = regexp.Compile(parsed.String())
-	if err != nil {
-		return fmt.Errorf("bad regexp[%s]: %s", fp.Pattern, err)
+	expr := parsed.String()
+	if anchored {
+		if flags&syntax.MatchNL != 0 {
+			expr = `(?m:^)(?:` + expr + `)`
+		} else {
+			expr = `\A(?:` + expr + `)`
+		}
 	}
-	for _, ex := range fp.Examples {
-		ex.AttributeMap = make(map[string]string)
-		for _, attr := range ex.Values {
-			ex.AttributeMap[attr.Name.Local] = attr.Value
+
+	if budget != nil {
+		if err := budget.spend(expr); err != nil {
+			return nil, nil, err
 		}
 	}
 
-	// Set a default certainty
-	if fp.Certainty == "" {
-		fp.Certainty = "0.85"
+	// Compile the parsed syntax tree, the normalized expression includes any flags
+	re, err := compileCached(expr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("bad regexp[%s]: %s", source, err)
+	}
+	return re, parsed, nil
+}
+
+// MatchesEmpty reports whether the fingerprint matches empty input
+func (fp *Fingerprint) MatchesEmpty() bool {
+	return fp.findSubmatch("") != nil
+}
+
+// findSubmatch returns the submatches of the first pattern matching data, or nil
+func (fp *Fingerprint) findSubmatch(data string) []string {
+	if err := fp.ensureCompiled(); err != nil {
+		return nil
+	}
+	if fp.matchers == nil {
+		return fp.PatternCompiled.FindStringSubmatch(data)
+	}
+	// Skip input too short for any pattern to match
+	if len(data) < fp.minLength {
+		return nil
+	}
+	lines := fp.lineBreaks(data)
+	for _, re := range fp.matchers {
+		if matches := lines.submatch(re); matches != nil {
+			if fp.vetoed(lines.normalized, false) {
+				return nil
+			}
+			return matches
+		}
 	}
 	return nil
 }
 
-// Pattern to substitute Values in the param values
-var varSubPattern = regexp.MustCompile(`\{[a-zA-Z0-9._\-]+\}`)
+// lineBreaks holds input for matching patterns with the REG_LINE_ANY_CRLF flag,
+// which treat CR, LF, and CRLF as line breaks like PCRE. The regexp package only
+// recognizes LF, so the line breaks are normalized to LF for matching, and the
+// submatches are taken from the original input.
+type lineBreaks struct {
+	data       string
+	normalized string
+	// offsets maps each byte of normalized, and its end, to the original input.
+	// It is nil when the input is matched as is.
+	offsets []int
+}
+
+// lineBreaks returns the input used to match data against the patterns
+func (fp *Fingerprint) lineBreaks(data string) lineBreaks {
+	lines := lineBreaks{data: data, normalized: data}
+	if !fp.anyCRLF || !strings.Contains(data, "\r") {
+		return lines
+	}
+
+	normalized := make([]byte, 0, len(data))
+	lines.offsets = make([]int, 0, len(data)+1)
+	for i := 0; i < len(data); i++ {
+		lines.offsets = append(lines.offsets, i)
+		switch {
+		case data[i] == '\r' && i+1 < len(data) && data[i+1] == '\n':
+			normalized = append(normalized, '\n')
+			i++
+		case data[i] == '\r':
+			normalized = append(normalized, '\n')
+		default:
+			normalized = append(normalized, data[i])
+		}
+	}
+	lines.offsets = append(lines.offsets, len(data))
+	lines.normalized = string(normalized)
+	return lines
+}
+
+// submatch returns the submatches of re in the input, or nil
+func (lines lineBreaks) submatch(re *regexp.Regexp) []string {
+	if lines.offsets == nil {
+		return re.FindStringSubmatch(lines.data)
+	}
+	return lines.original(re.FindStringSubmatchIndex(lines.normalized))
+}
+
+// allSubmatches returns the submatches of every occurrence of re in the input
+func (lines lineBreaks) allSubmatches(re *regexp.Regexp) [][]string {
+	if lines.offsets == nil {
+		return re.FindAllStringSubmatch(lines.data, -1)
+	}
+	var ret [][]string
+	for _, loc := range re.FindAllStringSubmatchIndex(lines.normalized, -1) {
+		ret = append(ret, lines.original(loc))
+	}
+	return ret
+}
+
+// original returns the submatches of the original input at the normalized indexes
+func (lines lineBreaks) original(loc []int) []string {
+	if loc == nil {
+		return nil
+	}
+	ret := make([]string, len(loc)/2)
+	for i := range ret {
+		if loc[2*i] >= 0 {
+			ret[i] = lines.data[lines.offsets[loc[2*i]]:lines.offsets[loc[2*i+1]]]
+		}
+	}
+	return ret
+}
+
+// HasTag reports whether the fingerprint has the given tag
+func (fp *Fingerprint) HasTag(tag string) bool {
+	for _, t := range fp.TagList {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// hasAnyTag reports whether the fingerprint has any of the given tags
+func (fp *Fingerprint) hasAnyTag(tags []string) bool {
+	for _, tag := range tags {
+		if fp.HasTag(tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// Keys of the values set by the library rather than by params. Params must not use
+// ReservedPrefix, which Validate reports. For compatibility, the description and
+// certainty are also set under their original keys "matched" and "fp.certainty".
+const (
+	ReservedPrefix = "_recog."
+	MatchedKey     = ReservedPrefix + "matched"
+	CertaintyKey   = ReservedPrefix + "certainty"
+	// DeviceKey holds the reconciled device, only when hw.device or os.device is set
+	DeviceKey = ReservedPrefix + "device"
+
+	legacyMatchedKey   = "matched"
+	legacyCertaintyKey = "fp.certainty"
+)
+
+// Pattern to substitute Values in the param values. A template may provide a default
+// after a | delimiter, as in {service.version|unknown}, that is used when the referenced
+// value is missing or empty. Within the default a backslash escapes the next character,
+// so \}, \| and \\ stand for a literal }, | and \.
+var varSubPattern = regexp.MustCompile(`\{[a-zA-Z0-9._\-]+(?:\|(?:[^{}\\]|\\.)*)?\}`)
 
-// Match a fingerprint against a string
+// parseTemplate splits a {key|default} template into its key and unescaped default
+func parseTemplate(s string) (key string, def string, hasDef bool) {
+	s = s[1 : len(s)-1]
+	i := strings.IndexByte(s, '|')
+	if i < 0 {
+		return s, "", false
+	}
+	key, s = s[:i], s[i+1:]
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+1 < len(s) {
+			i++
+		}
+		b.WriteByte(s[i])
+	}
+	return key, b.String(), true
+}
+
+// Match a fingerprint against a string. Empty input is matched like any other,
+// see MatchesEmpty and FingerprintDB.SkipEmpty. A match is discarded when any of
+// the anti-patterns of the fingerprint also matches the string.
 func (fp *Fingerprint) Match(data string) *FingerprintMatch {
-	res := &FingerprintMatch{Matched: false}
+	if err := fp.ensureCompiled(); err != nil {
+		return &FingerprintMatch{Matched: false, Errors: []error{err}}
+	}
+	matches := fp.findSubmatch(data)
+	if len(matches) == 0 {
+		return &FingerprintMatch{Matched: false}
+	}
+	return fp.extract(matches, matchOptions{})
+}
+
+// matchFolded matches a fingerprint against a string like Match, using the
+// case-insensitive pattern variants compiled when FoldCase is set
+func (fp *Fingerprint) matchFolded(data string) *FingerprintMatch {
+	if err := fp.ensureCompiled(); err != nil {
+		return &FingerprintMatch{Matched: false, Errors: []error{err}}
+	}
+	lines := fp.lineBreaks(data)
+	for _, re := range fp.matchersFolded {
+		if matches := lines.submatch(re); matches != nil {
+			if fp.vetoed(lines.normalized, true) {
+				break
+			}
+			return fp.extract(matches, matchOptions{})
+		}
+	}
+	return &FingerprintMatch{Matched: false}
+}
 
-	matches := fp.PatternCompiled.FindStringSubmatch(data)
+// MatchDebug matches a fingerprint against a string like Match, but retains
+// temporary params (_tmp.*) in the result so intermediate values can be inspected
+func (fp *Fingerprint) MatchDebug(data string) *FingerprintMatch {
+	if err := fp.ensureCompiled(); err != nil {
+		return &FingerprintMatch{Matched: false, Errors: []error{err}}
+	}
+	matches := fp.findSubmatch(data)
 	if len(matches) == 0 {
-		return res
+		return &FingerprintMatch{Matched: false}
 	}
+	return fp.extract(matches, matchOptions{keepTemp: true})
+}
 
-	res.Matched = true
+// MatchWithContext matches a fingerprint against a string like Match, but also
+// substitutes param templates of the form {_ctx.key} with ctx["key"]. This allows
+// callers to inject context, such as the scanned address or port, into the result.
+func (fp *Fingerprint) MatchWithContext(data string, ctx map[string]string) *FingerprintMatch {
+	if err := fp.ensureCompiled(); err != nil {
+		return &FingerprintMatch{Matched: false, Errors: []error{err}}
+	}
+	matches := fp.findSubmatch(data)
+	if len(matches) == 0 {
+		return &FingerprintMatch{Matched: false}
+	}
+	return fp.extract(matches, matchOptions{context: ctx})
+}
+
+// MatchAllOccurrences matches a fingerprint against every non-overlapping
+// occurrence of its pattern in a string, such as each line of a multiline
+// banner. Params are extracted and substituted independently for each
+// occurrence, so every result only contains values captured by that occurrence.
+// Only the occurrences of the first matching pattern are returned.
+func (fp *Fingerprint) MatchAllOccurrences(data string) []*FingerprintMatch {
+	if err := fp.ensureCompiled(); err != nil {
+		return []*FingerprintMatch{{Matched: false, Errors: []error{err}}}
+	}
+	ret := []*FingerprintMatch{}
+	lines := fp.lineBreaks(data)
+	if fp.vetoed(lines.normalized, false) {
+		return ret
+	}
+	matchers := fp.matchers
+	if matchers == nil {
+		matchers = []*regexp.Regexp{fp.PatternCompiled}
+	}
+
+	// Use the occurrences of the first pattern that matches
+	for _, re := range matchers {
+		for _, matches := range lines.allSubmatches(re) {
+			ret = append(ret, fp.extract(matches, matchOptions{}))
+		}
+		if len(ret) > 0 {
+			break
+		}
+	}
+	return ret
+}
+
+// matchOptions controls how match results are extracted
+type matchOptions struct {
+	// keepTemp retains temporary params (_tmp.*) in the match values
+	keepTemp bool
+	// context supplies values for {_ctx.*} param templates
+	context map[string]string
+}
+
+// contextPrefix identifies param templates that reference caller-supplied context
+const contextPrefix = "_ctx."
+
+// extract builds a match result from the submatches of the fingerprint pattern
+func (fp *Fingerprint) extract(matches []string, opts matchOptions) *FingerprintMatch {
+	res := &FingerprintMatch{Matched: true, MatchedText: matches[0], fingerprint: fp}
 	res.Values = make(map[string]string)
 
-	// Set the certainty if available
+	// Set the certainty if available. The library values take precedence over
+	// params using the same names, see Validate.
 	if fp.Certainty != "" {
-		res.Values["fp.certainty"] = fp.Certainty
+		res.Values[CertaintyKey] = fp.Certainty
+		res.Values[legacyCertaintyKey] = fp.Certainty
 	}
-
-	if fp.Description != nil && fp.Description.Text != "" {
-		res.Values["matched"] = fp.Description.Text
+	described := fp.Description != nil && fp.Description.Text != ""
+	if described {
+		res.Values[MatchedKey] = fp.Description.Text
+		res.Values[legacyMatchedKey] = fp.Description.Text
 	}
 
 	// Extract match parameters (first pass)
+	type paramValue struct {
+		name   string
+		value  string
+		static bool
+	}
+	extracted := make([]paramValue, 0, len(fp.Params))
+	counts := make(map[string]int)
 	paramZeroKeys := make(map[string]bool)
 	for _, p := range fp.Params {
+		if p.Require != "" {
+			req, err := strconv.Atoi(p.Require)
+			if err != nil || req <= 0 {
+				res.Errors = append(res.Errors, &ErrParamIndex{Name: p.Name, Position: p.Require, Err: err})
+				continue
+			}
+			if req >= len(matches) {
+				res.Errors = append(res.Errors, &ErrCaptureMissing{Name: p.Name, Position: p.Require, Captured: len(matches)})
+				continue
+			}
+			if matches[req] == "" {
+				continue
+			}
+		}
 		if p.Position == "0" {
-			res.Values[p.Name] = p.Value
-			paramZeroKeys[p.Name] = true
+			extracted = append(extracted, paramValue{name: p.Name, value: p.Value, static: true})
+			counts[p.Name]++
 			continue
 		}
 		val, err := strconv.Atoi(p.Position)
 		if err != nil {
-			res.Errors = append(res.Errors, fmt.Errorf("param index %s is invalid: %s", p.Position, err))
+			res.Errors = append(res.Errors, &ErrParamIndex{Name: p.Name, Position: p.Position, Err: err})
 			continue
 		}
 		if val <= 0 {
-			res.Errors = append(res.Errors, fmt.Errorf("param index %s is invalid", p.Position))
+			res.Errors = append(res.Errors, &ErrParamIndex{Name: p.Name, Position: p.Position})
 			continue
 		}
 		if val >= len(matches) {
-			res.Errors = append(res.Errors, fmt.Errorf("param index %s was not captured (%d elements)", p.Position, len(matches)))
+			res.Errors = append(res.Errors, &ErrCaptureMissing{Name: p.Name, Position: p.Position, Captured: len(matches)})
 			continue
 		}
 
-		res.Values[p.Name] = matches[val]
+		extracted = append(extracted, paramValue{name: p.Name, value: fp.lookup(p, p.trim(matches[val]))})
+		counts[p.Name]++
 	}
 
-	// Substitute variable templates in a second pass
-	for k, v := range res.Values {
-
-		// Skip non-zero parameters since they come from the banner and not the fingerprint
-		if _, ok := paramZeroKeys[k]; !ok {
+	// Values holds the last value of a repeated param name
+	for _, pv := range extracted {
+		if strings.HasPrefix(pv.name, ReservedPrefix) || (pv.name == legacyMatchedKey && described) || (pv.name == legacyCertaintyKey && fp.Certainty != "") {
 			continue
 		}
+		res.Values[pv.name] = pv.value
+		paramZeroKeys[pv.name] = pv.static
+	}
 
+	// Static values are resolved on demand, so a template referencing another static
+	// value sees it fully resolved and chained references such as a -> b -> c resolve
+	// regardless of param order. Captured values come from the banner and are never
+	// interpolated. Params sharing a capture group each receive the captured value.
+	// A reference back to a value still being resolved is a cycle and is left as is.
+	// Every value of a repeated param name is resolved once into MultiValues, which
+	// also provides the resolved last value.
+	resolved := make(map[string]bool)
+	resolving := make(map[string]bool)
+	var resolve func(name string)
+	interpolate := func(name string, v string) string {
 		if !varSubPattern.MatchString(v) {
-			continue
+			return v
 		}
 		nv := varSubPattern.ReplaceAllStringFunc(v, func(s string) string {
-			rk := s[1 : len(s)-1]
+			rk, def, hasDef := parseTemplate(s)
+			if strings.HasPrefix(rk, contextPrefix) {
+				r, ok := opts.context[strings.TrimPrefix(rk, contextPrefix)]
+				if hasDef && r == "" {
+					return def
+				}
+				if !ok {
+					res.Errors = append(res.Errors, &ErrSubstitution{Name: name, Key: rk, Context: true})
+					return s
+				}
+				return r
+			}
+			if resolving[rk] {
+				res.Errors = append(res.Errors, &ErrSubstitution{Name: name, Key: rk, Cycle: true})
+				return s
+			}
+			resolve(rk)
 			r, ok := res.Values[rk]
+			if hasDef && r == "" {
+				return def
+			}
 			if !ok {
-				res.Errors = append(res.Errors, fmt.Errorf("param %s could not be substituted", rk))
+				res.Errors = append(res.Errors, &ErrSubstitution{Name: name, Key: rk})
 				return s
 			}
 			if strings.HasPrefix(v, "cpe:") && rk == "service.version" && r == "" {
@@ -172,48 +780,281 @@ func (fp *Fingerprint) Match(data string) *FingerprintMatch {
 			}
 			return r
 		})
-		res.Values[k] = strings.TrimSpace(nv)
+		return strings.TrimSpace(nv)
+	}
+	resolve = func(name string) {
+		if resolved[name] || (counts[name] < 2 && !paramZeroKeys[name]) {
+			return
+		}
+		resolving[name] = true
+		if counts[name] < 2 {
+			res.Values[name] = interpolate(name, res.Values[name])
+		} else {
+			values := make([]string, 0, counts[name])
+			for _, pv := range extracted {
+				if pv.name != name {
+					continue
+				}
+				v := pv.value
+				if pv.static {
+					v = interpolate(name, v)
+				}
+				values = append(values, v)
+			}
+			if res.MultiValues == nil {
+				res.MultiValues = make(map[string][]string)
+			}
+			res.MultiValues[name] = values
+			if paramZeroKeys[name] {
+				res.Values[name] = values[len(values)-1]
+			}
+		}
+		resolving[name] = false
+		resolved[name] = true
+	}
+
+	// Substitute variable templates in a second pass, in param order so that any
+	// errors are reported consistently
+	for _, pv := range extracted {
+		resolve(pv.name)
+	}
+
+	res.reconcileDevice()
+
+	if opts.keepTemp {
+		return res
 	}
 
 	// Remove temporary params (_tmp.00x) from results
 	for k := range res.Values {
 		if strings.HasPrefix(k, "_tmp.") {
 			delete(res.Values, k)
+			delete(res.MultiValues, k)
 		}
 	}
 
 	return res
 }
 
+// CaptureConsistency verifies that the param positions match the capture groups of the compiled patterns
+func (fp *Fingerprint) CaptureConsistency() error {
+	if err := fp.ensureCompiled(); err != nil {
+		return err
+	}
+	numSubexp := fp.PatternCompiled.NumSubexp()
+	for _, re := range fp.PatternsCompiled {
+		if re.NumSubexp() != numSubexp {
+			return fmt.Errorf("'%s' has %d capture groups, but the alternative pattern '%s' has %d", fp.Pattern, numSubexp, re.String(), re.NumSubexp())
+		}
+	}
+	captures := make(map[int]bool)
+	for _, p := range fp.Params {
+		pos, err := strconv.Atoi(p.Position)
+		if err != nil {
+			return fmt.Errorf("'%s' param %s index %s is invalid: %s", fp.Pattern, p.Name, p.Position, err)
+		}
+		if pos < 0 {
+			return fmt.Errorf("'%s' param %s index %s is invalid", fp.Pattern, p.Name, p.Position)
+		}
+		if pos > numSubexp {
+			return fmt.Errorf("'%s' param %s index %d does not exist (%d capture groups)", fp.Pattern, p.Name, pos, numSubexp)
+		}
+		if pos > 0 {
+			captures[pos] = true
+		}
+		if p.Require == "" {
+			continue
+		}
+		req, err := strconv.Atoi(p.Require)
+		if err != nil || req <= 0 || req > numSubexp {
+			return fmt.Errorf("'%s' param %s requires an invalid capture group %s", fp.Pattern, p.Name, p.Require)
+		}
+		captures[req] = true
+	}
+
+	// Groups that no param references are usually a forgotten param, or should be (?:...)
+	var unused []string
+	for i := 1; i <= numSubexp; i++ {
+		if !captures[i] {
+			unused = append(unused, strconv.Itoa(i))
+		}
+	}
+	if len(unused) > 0 {
+		return fmt.Errorf("'%s' capture group(s) %s not referenced by any param", fp.Pattern, strings.Join(unused, ", "))
+	}
+	return nil
+}
+
+// Validate checks a normalized fingerprint for common authoring mistakes
+func (fp *Fingerprint) Validate() error {
+	if err := fp.CaptureConsistency(); err != nil {
+		return err
+	}
+	maps := make(map[string]bool, len(fp.Maps))
+	for _, m := range fp.Maps {
+		if m.Name == "" || maps[m.Name] {
+			return fmt.Errorf("'%s' has a map with a missing or duplicate name %q", fp.Pattern, m.Name)
+		}
+		maps[m.Name] = true
+		keys := make(map[string]bool, len(m.Entries))
+		for _, e := range m.Entries {
+			if keys[e.Key] {
+				return fmt.Errorf("'%s' map %s has a duplicate key %q", fp.Pattern, m.Name, e.Key)
+			}
+			keys[e.Key] = true
+		}
+	}
+	for _, p := range fp.Params {
+		if _, err := strconv.ParseBool(p.Trim); p.Trim != "" && err != nil {
+			return fmt.Errorf("'%s' param %s has an invalid trim value %q", fp.Pattern, p.Name, p.Trim)
+		}
+		if p.Map == "" {
+			continue
+		}
+		if p.Position == "0" {
+			return fmt.Errorf("'%s' param %s maps a static value, maps only apply to captured values", fp.Pattern, p.Name)
+		}
+		if !maps[p.Map] {
+			return fmt.Errorf("'%s' param %s references undefined map %s", fp.Pattern, p.Name, p.Map)
+		}
+	}
+	if err := fp.checkParamNames(); err != nil {
+		return err
+	}
+	return fp.checkReferences()
+}
+
+// checkReferences reports {name} templates in param values that cannot resolve when
+// the fingerprint matches: references to params that are not defined, or that are
+// only set when a required capture group matched. Capture groups are checked to
+// exist by CaptureConsistency.
+func (fp *Fingerprint) checkReferences() error {
+	// Params set on every match, as opposed to those with a require attribute
+	always := map[string]bool{
+		MatchedKey: true, CertaintyKey: true, legacyMatchedKey: true, legacyCertaintyKey: true,
+	}
+	conditional := make(map[string]bool)
+	for _, p := range fp.Params {
+		if p.Require == "" {
+			always[p.Name] = true
+		} else {
+			conditional[p.Name] = true
+		}
+	}
+
+	for _, p := range fp.Params {
+		if p.Position != "0" {
+			continue
+		}
+		for _, ref := range varSubPattern.FindAllString(p.Value, -1) {
+			key, _, hasDef := parseTemplate(ref)
+			switch {
+			case hasDef, strings.HasPrefix(key, contextPrefix), always[key]:
+			case conditional[key]:
+				return fmt.Errorf("'%s' param %s references %s, which is only set when its required group matched", fp.Pattern, p.Name, ref)
+			default:
+				return fmt.Errorf("'%s' param %s references undefined param %s", fp.Pattern, p.Name, ref)
+			}
+		}
+	}
+	return nil
+}
+
+// checkParamNames reports params that use the reserved prefix or collide with the
+// legacy names of the library values, which take precedence when matched
+func (fp *Fingerprint) checkParamNames() error {
+	for _, p := range fp.Params {
+		switch {
+		case strings.HasPrefix(p.Name, ReservedPrefix):
+			return fmt.Errorf("'%s' param %s uses the reserved prefix %s", fp.Pattern, p.Name, ReservedPrefix)
+		case p.Name == legacyMatchedKey && fp.Description != nil && fp.Description.Text != "":
+			return fmt.Errorf("'%s' param matched collides with the description", fp.Pattern)
+		case p.Name == legacyCertaintyKey:
+			return fmt.Errorf("'%s' param fp.certainty collides with the certainty", fp.Pattern)
+		}
+	}
+	return nil
+}
+
 var spacePat = regexp.MustCompile(`\s+`)
 
-// VerifyExamples ensures that the built-in examples match correctly
-func (fp *Fingerprint) VerifyExamples(fpath string) error {
-	for _, ex := range fp.Examples {
+// exampleData returns the decoded data for an example, reading it from fpath if held in a file
+func (fp *Fingerprint) exampleData(ex *FingerprintExample, fpath string) (string, error) {
+	exampleData := ex.Text
 
-		exampleData := ex.Text
+	datafile, found := ex.AttributeMap["_filename"]
+	if found {
+		datafilepath := filepath.Join(fpath, datafile)
+		str, err := os.ReadFile(datafilepath)
+		if err != nil {
+			return "", fmt.Errorf("external example file: %s: %s (%s)", fp.PatternCompiled.String(), err, datafilepath)
+		}
+		exampleData = string(str)
+	}
 
-		datafile, found := ex.AttributeMap["_filename"]
-		if found {
-			datafilepath := filepath.Join(fpath, datafile)
-			str, err := os.ReadFile(datafilepath)
+	encodingType, found := ex.AttributeMap["_encoding"]
+	if found {
+		switch encodingType {
+		case "base64", "base64url":
+			exampleData = spacePat.ReplaceAllString(exampleData, "")
+			data, err := decodeExampleBase64(exampleData, encodingType == "base64url")
 			if err != nil {
-				return fmt.Errorf("external example file: %s: %s (%s)", fp.PatternCompiled.String(), err, datafilepath)
+				return "", fmt.Errorf("%s: %s: %s (%s)", encodingType, fp.PatternCompiled.String(), err, exampleData)
 			}
-			exampleData = string(str)
+			exampleData = string(data)
+		}
+	}
+
+	return exampleData, nil
+}
+
+// decodeExampleBase64 decodes the standard or URL-safe base64 of an example. Padding
+// is optional, and errors point out data using the other alphabet or bad padding.
+func decodeExampleBase64(data string, urlSafe bool) ([]byte, error) {
+	enc := base64.RawStdEncoding
+	if urlSafe {
+		enc = base64.RawURLEncoding
+	}
+	trimmed := strings.TrimRight(data, "=")
+	if pad := len(data) - len(trimmed); pad > 0 && (pad > 2 || len(data)%4 != 0) {
+		return nil, fmt.Errorf("bad padding, %d characters with %d padding characters", len(data), pad)
+	}
+	if len(trimmed)%4 == 1 {
+		return nil, fmt.Errorf("bad length, %d characters cannot be base64 even with padding", len(trimmed))
+	}
+
+	decoded, err := enc.DecodeString(trimmed)
+	if err == nil {
+		return decoded, nil
+	}
+	switch {
+	case !urlSafe && strings.ContainsAny(trimmed, "-_"):
+		return nil, fmt.Errorf("%s, the data looks URL-safe, use _encoding=\"base64url\"", err)
+	case urlSafe && strings.ContainsAny(trimmed, "+/"):
+		return nil, fmt.Errorf("%s, the data looks like standard base64, use _encoding=\"base64\"", err)
+	}
+	return nil, err
+}
+
+// VerifyExamples ensures that the built-in examples match correctly
+func (fp *Fingerprint) VerifyExamples(fpath string) error {
+	return fp.verifyExamples(fpath, nil)
+}
+
+// verifyExamples verifies the examples, applying transform, if set, to the example
+// data before matching it
+func (fp *Fingerprint) verifyExamples(fpath string, transform func(string) string) error {
+	if err := fp.ensureCompiled(); err != nil {
+		return err
+	}
+	for _, ex := range fp.Examples {
+
+		exampleData, err := fp.exampleData(ex, fpath)
+		if err != nil {
+			return err
 		}
-
-		encodingType, found := ex.AttributeMap["_encoding"]
-		if found {
-			switch encodingType {
-			case "base64":
-				exampleData = spacePat.ReplaceAllString(exampleData, "")
-				data, err := base64.StdEncoding.DecodeString(exampleData)
-				if err != nil {
-					return fmt.Errorf("base64: %s: %s (%s)", fp.PatternCompiled.String(), err, exampleData)
-				}
-				exampleData = string(data)
-			}
+		if transform != nil {
+			exampleData = transform(exampleData)
 		}
 
 		escapedData := strings.Replace(exampleData, "\n", "\\n", -1)
@@ -225,7 +1066,7 @@ func (fp *Fingerprint) VerifyExamples(fpath string) error {
 		}
 
 		if len(m.Errors) > 0 {
-			return fmt.Errorf("failed to match '%s' (%s) with errors: %v", fp.PatternCompiled.String(), escapedData, m.Errors)
+			return &ErrExample{Pattern: fp.PatternCompiled.String(), Data: escapedData, Errors: m.Errors}
 		}
 
 		// Verify that the extracted Values matched
@@ -247,23 +1088,168 @@ func (fp *Fingerprint) VerifyExamples(fpath string) error {
 	return nil
 }
 
-// FingerprintMatch represents a match of a fingerprint to some data
+// FingerprintMatch represents a match of a fingerprint to some data. When either
+// hw.device or os.device is extracted, Values also contains a DeviceKey value
+// holding the hw.device value, or os.device if that is not set.
+// Values also holds the description and certainty of the fingerprint under
+// MatchedKey and CertaintyKey.
 type FingerprintMatch struct {
 	Matched bool
 	Errors  []error
 	Values  map[string]string
+	// MultiValues holds every value, in param order, of param names that a fingerprint
+	// sets more than once. Values holds the last of these values.
+	MultiValues map[string][]string
+	// Input is the original data passed to a FingerprintDB match method, before preprocessing
+	Input string
+	// MatchedText is the part of the input matched by the pattern, set whether or not
+	// the fingerprint defines params
+	MatchedText string
+
+	// fingerprint is the matching fingerprint
+	fingerprint *Fingerprint
+}
+
+// Fingerprint returns the fingerprint that produced the match, or nil if nothing matched.
+// Failed results returned by MatchAllVerbose also refer to their fingerprint.
+// The fingerprint provides the pattern, flags, and description behind the values.
+func (m *FingerprintMatch) Fingerprint() *Fingerprint {
+	return m.fingerprint
+}
+
+// deviceKeys lists the device fields in order of authority
+var deviceKeys = []string{"hw.device", "os.device"}
+
+// reconcileDevice sets the DeviceKey value from the hw.device or os.device values,
+// preferring hw.device. These are expected to agree when both are present.
+// A conflicting value is reported in Errors and does not replace the device.
+func (m *FingerprintMatch) reconcileDevice() {
+	device, source := "", ""
+	for _, k := range deviceKeys {
+		v := m.Values[k]
+		if v == "" {
+			continue
+		}
+		if device == "" {
+			device, source = v, k
+			continue
+		}
+		if v != device {
+			m.Errors = append(m.Errors, fmt.Errorf("%s %q conflicts with %s %q", k, v, source, device))
+		}
+	}
+	if device != "" {
+		m.Values[DeviceKey] = device
+	}
 }
 
 // FingerprintDB represents a fingerprint database
 type FingerprintDB struct {
-	XMLName      xml.Name       `xml:"fingerprints"`
-	Matches      string         `xml:"matches,attr" json:"matches,omitempty"`
-	Protocol     string         `xml:"protocol,attr,omitempty" json:"protocol,omitempty"`
-	DatabaseType string         `xml:"database_type,attr" json:"database_type,omitempty"`
-	Preference   string         `xml:"preference,attr" json:"preference,omitempty"`
+	XMLName      xml.Name `xml:"fingerprints" json:"-"`
+	Matches      string   `xml:"matches,attr" json:"matches,omitempty"`
+	Protocol     string   `xml:"protocol,attr,omitempty" json:"protocol,omitempty"`
+	DatabaseType string   `xml:"database_type,attr" json:"database_type,omitempty"`
+	Preference   string   `xml:"preference,attr" json:"preference,omitempty"`
+	Version      string   `xml:"version,attr,omitempty" json:"version,omitempty"`
+	Updated      string   `xml:"updated,attr,omitempty" json:"updated,omitempty"`
+	// Extends names a base database, by file name or "matches" attribute, that this
+	// partial database adds its fingerprints to when loaded into a FingerprintSet.
+	// ExtendOrder is "first", the default, to try the added fingerprints before those
+	// of the base, or "last" to try them after.
+	Extends      string         `xml:"extends,attr,omitempty" json:"extends,omitempty"`
+	ExtendOrder  string         `xml:"extend_order,attr,omitempty" json:"extend_order,omitempty"`
 	Fingerprints []*Fingerprint `xml:"fingerprint,omitempty" json:"fingerprint,omitempty"`
 	Name         string         `xml:"-" json:"name,omitempty"`
-	Logger       *log.Logger    `json:"-"`
+	ExamplesPath string         `xml:"-" json:"-"`
+	Checksum     string         `xml:"-" json:"checksum,omitempty"`
+	// FoldCase compiles case-insensitive pattern variants for MatchFirstFold during Normalize
+	FoldCase bool `xml:"-" json:"-"`
+	// LazyCompile defers compiling each fingerprint until it is first used, which
+	// speeds up loading when few fingerprints are used. Invalid patterns are then
+	// reported by the first match or Validate call instead of by Normalize. Databases
+	// loaded by LoadUntrustedFingerprintDB with a MaxProgramSize are always compiled by
+	// Normalize, so the limit is enforced when the database is loaded.
+	LazyCompile bool `xml:"-" json:"-"`
+	// RequireExamples makes VerifyExamples fail for fingerprints without examples
+	RequireExamples bool `xml:"-" json:"-"`
+	// PreferenceValue is the parsed Preference, defaulting to DefaultPreference
+	PreferenceValue float64 `xml:"-" json:"-"`
+	// Preprocessor, if set, transforms input before MatchFirst, MatchFirstFold and MatchAll evaluate it
+	Preprocessor func(string) string `xml:"-" json:"-"`
+	// Anchored requires fingerprint patterns to match at the start of the input, or at
+	// the start of a line for patterns that span lines ((?m) or the REG_MULTILINE
+	// family of flags), instead of anywhere in it. This avoids false positives from
+	// unanchored patterns matching deep inside large inputs such as HTTP bodies, but
+	// stops fingerprints that rely on a substring match from matching at all.
+	// Fingerprints may override it with their anchored attribute. It takes effect
+	// when the database is normalized.
+	Anchored bool `xml:"-" json:"-"`
+	// MaxCaptureGroups limits the capture groups of each fingerprint pattern, guarding
+	// against untrusted databases with pathological patterns. Normalize fails for
+	// patterns over the limit. Zero uses DefaultMaxCaptureGroups.
+	MaxCaptureGroups int `xml:"-" json:"-"`
+	// NormalizeLineEndings replaces CRLF line endings with LF in input before the
+	// Preprocessor and matching, and in examples verified by VerifyExamples. Patterns
+	// treat only LF as a line terminator, except those with the REG_LINE_ANY_CRLF
+	// flag, so banners captured with CRLF may otherwise fail fingerprints
+	// written for LF.
+	NormalizeLineEndings bool `xml:"-" json:"-"`
+	// SkipEmpty makes MatchFirst, MatchFirstFold and MatchAll return no matches for
+	// empty or whitespace-only input, which permissive patterns such as ^$ or .* match
+	SkipEmpty bool        `xml:"-" json:"-"`
+	Logger    *log.Logger `json:"-"`
+	// RecoverPanics makes MatchFirst and MatchAll recover from a panic while matching
+	// a fingerprint, recording it as an error and continuing with the remaining
+	// fingerprints. It is off by default to avoid the overhead.
+	RecoverPanics bool `xml:"-" json:"-"`
+	// UnresolvedTemplates controls how matches with param templates that could not be
+	// resolved, such as cpe:/a:{service.vendor}:server, are returned. By default the
+	// values keep the templates and the match records an ErrSubstitution.
+	UnresolvedTemplates TemplatePolicy `xml:"-" json:"-"`
+
+	// byDescription indexes the fingerprints by description text, built by Normalize
+	byDescription map[string]*Fingerprint
+	// mega is the literal index built by BuildMegaMatcher
+	mega *megaMatcher
+	// profile records the time spent matching each fingerprint, see EnableMatchProfile
+	profile *matchProfile
+	// budget limits the program size of untrusted databases, see LoadUntrustedFingerprintDB
+	budget *programBudget
+}
+
+// preprocess normalizes line endings and applies the Preprocessor to data, if set
+func (fdb *FingerprintDB) preprocess(data string) string {
+	if fdb.NormalizeLineEndings {
+		data = NormalizeCRLF(data)
+	}
+	if fdb.Preprocessor == nil {
+		return data
+	}
+	processed := fdb.Preprocessor(data)
+	if processed != data {
+		fdb.DebugLogf("FP-PREPROCESS %#v to %#v", data, processed)
+	}
+	return processed
+}
+
+// skipInput reports whether SkipEmpty is set and data is empty or whitespace-only
+func (fdb *FingerprintDB) skipInput(data string) bool {
+	if !fdb.SkipEmpty || strings.TrimSpace(data) != "" {
+		return false
+	}
+	fdb.DebugLogf("FP-SKIP %#v", data)
+	return true
+}
+
+// CollapseWhitespace is a Preprocessor that replaces runs of whitespace with a
+// single space and trims leading and trailing whitespace
+func CollapseWhitespace(data string) string {
+	return strings.Join(strings.Fields(data), " ")
+}
+
+// NormalizeCRLF replaces CRLF line endings with LF
+func NormalizeCRLF(data string) string {
+	return strings.Replace(data, "\r\n", "\n", -1)
 }
 
 // DebugLogf writes an error to the debug log, if enabled
@@ -276,23 +1262,169 @@ func (fdb *FingerprintDB) DebugLogf(format string, args ...interface{}) {
 	fdb.Logger.Printf("[recog] %s "+strings.TrimSpace(format), fargs...)
 }
 
-// Normalize calls the Normalize function on each loaded Fingerprint
+// DefaultPreference is used for databases without a valid preference attribute
+const DefaultPreference = 0.10
+
+// Normalize parses the database preference and calls the Normalize function on each loaded Fingerprint
 func (fdb *FingerprintDB) Normalize() error {
+	fdb.normalizePreference()
+	fdb.mega = nil
+	if fdb.budget != nil {
+		fdb.budget.used = 0
+	}
+
+	for _, fp := range fdb.Fingerprints {
+		if err := fdb.normalizeFingerprint(fp); err != nil {
+			return err
+		}
+	}
+	fdb.index()
+	return nil
+}
+
+// normalizeFingerprint normalizes a fingerprint with the options of the database
+func (fdb *FingerprintDB) normalizeFingerprint(fp *Fingerprint) error {
+	err := fp.normalize(fdb.compileOptions())
+	if err != nil {
+		fdb.DebugLogf("failed to normalize %s: %s", fdb.Name, err)
+		return err
+	}
+	for _, note := range fp.translations {
+		fdb.DebugLogf("%s in '%s'", note, fp.Pattern)
+	}
+	if err := fp.checkParamNames(); err != nil {
+		fdb.DebugLogf("%s", err)
+	}
+	return nil
+}
+
+// index interns the strings of the normalized fingerprints and indexes them by description
+func (fdb *FingerprintDB) index() {
+	fdb.internStrings(make(stringInterner))
+
+	// Index the fingerprints by description, keeping the first of any duplicates
+	fdb.byDescription = make(map[string]*Fingerprint, len(fdb.Fingerprints))
+	for _, fp := range fdb.Fingerprints {
+		if fp.Description == nil {
+			continue
+		}
+		if _, ok := fdb.byDescription[fp.Description.Text]; !ok {
+			fdb.byDescription[fp.Description.Text] = fp
+		}
+	}
+}
+
+// compileOptions returns the options used to compile the fingerprints of the database
+func (fdb *FingerprintDB) compileOptions() compileOptions {
+	return compileOptions{
+		fold:        fdb.FoldCase,
+		lazy:        fdb.LazyCompile,
+		anchored:    fdb.Anchored,
+		maxCaptures: fdb.MaxCaptureGroups,
+		budget:      fdb.budget,
+	}
+}
+
+// FindByDescription returns the fingerprint with the given description text
+func (fdb *FingerprintDB) FindByDescription(desc string) (*Fingerprint, bool) {
+	if fdb.byDescription != nil {
+		fp, ok := fdb.byDescription[desc]
+		return fp, ok
+	}
+
+	// Databases that were not normalized as a whole have no index
+	for _, fp := range fdb.Fingerprints {
+		if fp.Description != nil && fp.Description.Text == desc {
+			return fp, true
+		}
+	}
+	return nil, false
+}
+
+// stringInterner deduplicates identical strings so they share one allocation
+type stringInterner map[string]string
+
+// intern returns the shared copy of str
+func (si stringInterner) intern(str string) string {
+	if shared, ok := si[str]; ok {
+		return shared
+	}
+	si[str] = str
+	return str
+}
+
+// internStrings replaces the certainty, params, and example attributes of each
+// fingerprint with shared copies, reducing the memory used by repeated strings
+func (fdb *FingerprintDB) internStrings(si stringInterner) {
+	for _, fp := range fdb.Fingerprints {
+		fp.Certainty = si.intern(fp.Certainty)
+		for _, p := range fp.Params {
+			p.Position = si.intern(p.Position)
+			p.Name = si.intern(p.Name)
+			p.Value = si.intern(p.Value)
+		}
+		for _, ex := range fp.Examples {
+			for i := range ex.Values {
+				ex.Values[i].Name.Local = si.intern(ex.Values[i].Name.Local)
+				ex.Values[i].Value = si.intern(ex.Values[i].Value)
+			}
+			for k, v := range ex.AttributeMap {
+				delete(ex.AttributeMap, k)
+				ex.AttributeMap[si.intern(k)] = si.intern(v)
+			}
+		}
+	}
+}
+
+// normalizePreference parses the database preference, falling back to DefaultPreference
+func (fdb *FingerprintDB) normalizePreference() {
+	fdb.PreferenceValue = DefaultPreference
+	if fdb.Preference == "" {
+		fdb.DebugLogf("missing preference, using %.2f", DefaultPreference)
+	} else if pref, err := strconv.ParseFloat(fdb.Preference, 64); err != nil {
+		fdb.DebugLogf("invalid preference %q, using %.2f: %s", fdb.Preference, DefaultPreference, err)
+	} else {
+		fdb.PreferenceValue = pref
+	}
+}
+
+// Validate calls the Validate function on each loaded Fingerprint
+func (fdb *FingerprintDB) Validate() error {
 	for _, fp := range fdb.Fingerprints {
-		err := fp.Normalize()
+		err := fp.Validate()
 		if err != nil {
-			fdb.DebugLogf("failed to normalize %s: %s", fdb.Name, err)
+			fdb.DebugLogf("failed to validate %s: %s", fdb.Name, err)
 			return err
 		}
+		for _, warning := range fp.Warnings() {
+			fdb.DebugLogf("%s: %s", fdb.Name, warning)
+		}
 	}
 	return nil
 }
 
 // VerifyExamples calls the VerifyExamples function on each loaded Fingerprint
-// fpath is the path to search for example data held in files
+// fpath is the path to search for example data held in files, an empty fpath
+// uses the ExamplesPath recorded when the database was loaded from disk, or for
+// fingerprints added by a partial database, that of the partial database.
+// Fingerprints without examples fail verification when RequireExamples is set.
+// CRLF line endings in examples are normalized when NormalizeLineEndings is set.
 func (fdb *FingerprintDB) VerifyExamples(fpath string) error {
 	for _, fp := range fdb.Fingerprints {
-		err := fp.VerifyExamples(fpath)
+		if fdb.RequireExamples && len(fp.Examples) == 0 {
+			err := fmt.Errorf("'%s' has no examples", fp.Pattern)
+			fdb.DebugLogf("failed to verify examples for %s: %s", fdb.Name, err)
+			return err
+		}
+		var transform func(string) string
+		if fdb.NormalizeLineEndings {
+			transform = NormalizeCRLF
+		}
+		dir := fpath
+		if dir == "" {
+			dir = fdb.examplesPath(fp)
+		}
+		err := fp.verifyExamples(dir, transform)
 		if err != nil {
 			fdb.DebugLogf("failed to verify examples for %s: %s", fdb.Name, err)
 			return err
@@ -301,12 +1433,98 @@ func (fdb *FingerprintDB) VerifyExamples(fpath string) error {
 	return nil
 }
 
+// examplesPath returns the directory holding the external examples of a fingerprint
+func (fdb *FingerprintDB) examplesPath(fp *Fingerprint) string {
+	if fp.examplesPath != "" {
+		return fp.examplesPath
+	}
+	return fdb.ExamplesPath
+}
+
+// match matches a single fingerprint, converting a panic into a failed match with
+// an error when RecoverPanics is set
+func (fdb *FingerprintDB) match(f *Fingerprint, data string) *FingerprintMatch {
+	return fdb.guard(f, func() *FingerprintMatch { return f.Match(data) })
+}
+
+// matchFolded matches a single fingerprint ignoring case, like match
+func (fdb *FingerprintDB) matchFolded(f *Fingerprint, data string) *FingerprintMatch {
+	return fdb.guard(f, func() *FingerprintMatch { return f.matchFolded(data) })
+}
+
+// guard runs a match of a fingerprint, profiling it when enabled, recovering from a
+// panic when RecoverPanics is set, and applying the UnresolvedTemplates policy
+func (fdb *FingerprintDB) guard(f *Fingerprint, fn func() *FingerprintMatch) (m *FingerprintMatch) {
+	if fdb.profile != nil {
+		defer fdb.profile.record(f, time.Now())
+	}
+	if !fdb.RecoverPanics {
+		return fdb.checkTemplates(fn())
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			fdb.DebugLogf("FP-PANIC %#v: %v", f.Pattern, r)
+			m = &FingerprintMatch{Matched: false, Errors: []error{fmt.Errorf("panic matching [%s]: %v", f.Pattern, r)}}
+		}
+	}()
+	return fdb.checkTemplates(fn())
+}
+
+// TemplatePolicy controls how a FingerprintDB handles matches with param templates
+// that could not be resolved, see FingerprintDB.UnresolvedTemplates
+type TemplatePolicy int
+
+const (
+	// KeepUnresolved returns values with their unresolved {name} templates intact
+	KeepUnresolved TemplatePolicy = iota
+	// DropUnresolved removes the values holding unresolved templates from the match
+	DropUnresolved
+	// RejectUnresolved treats the match as failed, keeping the substitution errors
+	RejectUnresolved
+)
+
+// checkTemplates applies the UnresolvedTemplates policy to a match
+func (fdb *FingerprintDB) checkTemplates(m *FingerprintMatch) *FingerprintMatch {
+	if !m.Matched || fdb.UnresolvedTemplates == KeepUnresolved {
+		return m
+	}
+	for _, err := range m.Errors {
+		var subErr *ErrSubstitution
+		if !errors.As(err, &subErr) {
+			continue
+		}
+		if fdb.UnresolvedTemplates == RejectUnresolved {
+			fdb.DebugLogf("FP-REJECT %#v: %s", m.fingerprint.Pattern, err)
+			return &FingerprintMatch{Matched: false, Errors: m.Errors}
+		}
+		delete(m.Values, subErr.Name)
+		delete(m.MultiValues, subErr.Name)
+	}
+	return m
+}
+
 // MatchFirst finds the first match for a given string
 func (fdb *FingerprintDB) MatchFirst(data string) *FingerprintMatch {
-	nomatch := &FingerprintMatch{Matched: false}
-	for _, f := range fdb.Fingerprints {
-		m := f.Match(data)
+	return fdb.matchFirst(data, nil, fdb.match)
+}
+
+// matchFirst finds the first match for a given string with match, only trying the
+// fingerprints accepted by try, if set. try is called with the index of each
+// fingerprint and the preprocessed input.
+func (fdb *FingerprintDB) matchFirst(data string, try func(i int, data string) bool, match func(f *Fingerprint, data string) *FingerprintMatch) *FingerprintMatch {
+	input := data
+	data = fdb.preprocess(data)
+	nomatch := &FingerprintMatch{Matched: false, Input: input}
+	if fdb.skipInput(data) {
+		return nomatch
+	}
+	for i, f := range fdb.Fingerprints {
+		if try != nil && !try(i, data) {
+			continue
+		}
+		m := match(f, data)
 		if m.Matched {
+			m.Input = input
 			desc := ""
 			if f.Description != nil {
 				desc = f.Description.Text
@@ -314,17 +1532,102 @@ func (fdb *FingerprintDB) MatchFirst(data string) *FingerprintMatch {
 			fdb.DebugLogf("FP-MATCH %#v to %#v (%s)", data, f.Pattern, desc)
 			return m
 		}
+		// Report patterns that failed to compile lazily or panicked
+		nomatch.Errors = append(nomatch.Errors, m.Errors...)
 	}
 	fdb.DebugLogf("FP-FAIL %#v", data)
 	return nomatch
 }
 
+// MatchFirstWithTags finds the first match for a given string like MatchFirst, only
+// considering fingerprints with at least one of the include tags, if any are given,
+// and none of the exclude tags
+func (fdb *FingerprintDB) MatchFirstWithTags(data string, include []string, exclude []string) *FingerprintMatch {
+	return fdb.matchFirst(data, func(i int, _ string) bool {
+		f := fdb.Fingerprints[i]
+		return (len(include) == 0 || f.hasAnyTag(include)) && !f.hasAnyTag(exclude)
+	}, fdb.match)
+}
+
+// MatchFirstBatch calls MatchFirst for each input using up to workers goroutines.
+// The results are aligned with inputs. A non-positive workers value uses a single worker.
+func (fdb *FingerprintDB) MatchFirstBatch(inputs []string, workers int) []*FingerprintMatch {
+	ret := make([]*FingerprintMatch, len(inputs))
+	if workers < 1 {
+		workers = 1
+	}
+	if workers > len(inputs) {
+		workers = len(inputs)
+	}
+
+	indexes := make(chan int)
+	wg := sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for idx := range indexes {
+				ret[idx] = fdb.MatchFirst(inputs[idx])
+			}
+		}()
+	}
+	for idx := range inputs {
+		indexes <- idx
+	}
+	close(indexes)
+	wg.Wait()
+	return ret
+}
+
+// MatchFirstFold finds the first match for a given string, ignoring case for every
+// fingerprint regardless of its flags. This requires FoldCase to be set before the
+// database is normalized, which doubles the number of compiled patterns held in
+// memory; use EnableFoldCase to recompile a loaded database.
+func (fdb *FingerprintDB) MatchFirstFold(data string) *FingerprintMatch {
+	if !fdb.FoldCase {
+		return &FingerprintMatch{Matched: false, Input: data, Errors: []error{fmt.Errorf("database %s does not have case folding enabled", fdb.Name)}}
+	}
+	return fdb.matchFirst(data, nil, fdb.matchFolded)
+}
+
+// EnableFoldCase sets FoldCase and normalizes the database again to compile the
+// case-insensitive pattern variants used by MatchFirstFold
+func (fdb *FingerprintDB) EnableFoldCase() error {
+	fdb.FoldCase = true
+	return fdb.Normalize()
+}
+
 // MatchAll finds all matches for a given string
 func (fdb *FingerprintDB) MatchAll(data string) []*FingerprintMatch {
+	return fdb.matchAll(data, false)
+}
+
+// MatchAllVerbose is MatchAll for debugging fingerprints that almost work. Besides the
+// matches, which may carry errors from extracting their params, it returns a result with
+// Matched unset for every fingerprint that failed with errors, such as a pattern that
+// matched but was rejected by the UnresolvedTemplates policy, or one that failed to
+// compile. Each result refers to its fingerprint, see FingerprintMatch.Fingerprint.
+func (fdb *FingerprintDB) MatchAllVerbose(data string) []*FingerprintMatch {
+	return fdb.matchAll(data, true)
+}
+
+// matchAll finds all matches for a given string, optionally including failed results with errors
+func (fdb *FingerprintDB) matchAll(data string, verbose bool) []*FingerprintMatch {
+	input := data
+	data = fdb.preprocess(data)
 	ret := []*FingerprintMatch{}
+	if fdb.skipInput(data) {
+		return ret
+	}
 	for _, f := range fdb.Fingerprints {
-		m := f.Match(data)
+		m := fdb.match(f, data)
+		if verbose && !m.Matched && len(m.Errors) > 0 {
+			m.Input = input
+			m.fingerprint = f
+			ret = append(ret, m)
+		}
 		if m.Matched {
+			m.Input = input
 			desc := ""
 			if f.Description != nil {
 				desc = f.Description.Text
@@ -332,6 +1635,9 @@ func (fdb *FingerprintDB) MatchAll(data string) []*FingerprintMatch {
 			fdb.DebugLogf("FP-MATCH %#v to %#v (%s)", data, f.Pattern, desc)
 			ret = append(ret, m)
 		}
+		for _, err := range m.Errors {
+			fdb.DebugLogf("FP-ERROR %#v: %s", f.Pattern, err)
+		}
 	}
 	if len(ret) == 0 {
 		fdb.DebugLogf("FP-FAIL %#v", data)
@@ -339,30 +1645,327 @@ func (fdb *FingerprintDB) MatchAll(data string) []*FingerprintMatch {
 	return ret
 }
 
+// TopMatches finds the n best matches for a given string, ranked by descending
+// certainty. Matches with equal certainty retain their order in the database.
+// A non-positive n returns all matches.
+func (fdb *FingerprintDB) TopMatches(data string, n int) []*FingerprintMatch {
+	type rankedMatch struct {
+		certainty float64
+		match     *FingerprintMatch
+	}
+
+	ranked := []rankedMatch{}
+	for _, m := range fdb.MatchAll(data) {
+		certainty, err := strconv.ParseFloat(m.Values[CertaintyKey], 64)
+		if err != nil {
+			fdb.DebugLogf("invalid certainty %q: %s", m.Values[CertaintyKey], err)
+		}
+		ranked = append(ranked, rankedMatch{certainty: certainty, match: m})
+	}
+
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return ranked[i].certainty > ranked[j].certainty
+	})
+
+	if n > 0 && len(ranked) > n {
+		ranked = ranked[:n]
+	}
+
+	ret := make([]*FingerprintMatch, 0, len(ranked))
+	for _, r := range ranked {
+		ret = append(ret, r.match)
+	}
+	return ret
+}
+
+// ParamCoverage identifies a param of a fingerprint
+type ParamCoverage struct {
+	Fingerprint *Fingerprint
+	Param       string
+}
+
+// CoverageReport lists the fingerprints of a database without examples, and the
+// captured params that no example of their fingerprint asserts
+type CoverageReport struct {
+	Untested   []*Fingerprint
+	Unasserted []ParamCoverage
+}
+
+// ExampleCoverage reports the fingerprints and params that VerifyExamples cannot
+// check. Static params (position 0) and temporary params (_tmp.*) are not reported
+// since matching an example already exercises them.
+func (fdb *FingerprintDB) ExampleCoverage() CoverageReport {
+	report := CoverageReport{Untested: []*Fingerprint{}, Unasserted: []ParamCoverage{}}
+	for _, fp := range fdb.Fingerprints {
+		if len(fp.Examples) == 0 {
+			report.Untested = append(report.Untested, fp)
+			continue
+		}
+		for _, p := range fp.Params {
+			if p.Position == "0" || strings.HasPrefix(p.Name, "_tmp.") {
+				continue
+			}
+			asserted := false
+			for _, ex := range fp.Examples {
+				if _, ok := ex.AttributeMap[p.Name]; ok {
+					asserted = true
+					break
+				}
+			}
+			if !asserted {
+				report.Unasserted = append(report.Unasserted, ParamCoverage{Fingerprint: fp, Param: p.Name})
+			}
+		}
+	}
+	return report
+}
+
+// Reorder stably sorts the fingerprints of the database using less. This changes
+// which fingerprint MatchFirst returns when several match, since it returns the
+// first match in order, and must not be called while the database is in use.
+func (fdb *FingerprintDB) Reorder(less func(a *Fingerprint, b *Fingerprint) bool) {
+	sort.SliceStable(fdb.Fingerprints, func(i, j int) bool {
+		return less(fdb.Fingerprints[i], fdb.Fingerprints[j])
+	})
+}
+
+// SortByCertainty reorders the fingerprints by descending certainty, keeping the
+// database order of fingerprints with equal certainty. See Reorder.
+func (fdb *FingerprintDB) SortByCertainty() {
+	certainty := func(fp *Fingerprint) float64 {
+		v, err := strconv.ParseFloat(fp.Certainty, 64)
+		if err != nil {
+			fdb.DebugLogf("invalid certainty %q: %s", fp.Certainty, err)
+		}
+		return v
+	}
+	fdb.Reorder(func(a *Fingerprint, b *Fingerprint) bool {
+		return certainty(a) > certainty(b)
+	})
+}
+
+// ShadowReport describes an example of a fingerprint that is also matched by an
+// earlier fingerprint in the same database, which prevents MatchFirst from ever
+// returning the later fingerprint for that data
+type ShadowReport struct {
+	Fingerprint *Fingerprint
+	Example     *FingerprintExample
+	ShadowedBy  *Fingerprint
+}
+
+// FindShadows reports each example that is matched by an earlier fingerprint than
+// the one it belongs to. Examples that cannot be loaded are skipped, VerifyExamples
+// reports those.
+func (fdb *FingerprintDB) FindShadows() []ShadowReport {
+	ret := []ShadowReport{}
+	for i, fp := range fdb.Fingerprints {
+		for _, ex := range fp.Examples {
+			data, err := fp.exampleData(ex, fdb.examplesPath(fp))
+			if err != nil {
+				continue
+			}
+			for _, previous := range fdb.Fingerprints[:i] {
+				if previous.findSubmatch(data) != nil {
+					ret = append(ret, ShadowReport{Fingerprint: fp, Example: ex, ShadowedBy: previous})
+				}
+			}
+		}
+	}
+	return ret
+}
+
+// Conflict describes an example matched by both a candidate fingerprint and an
+// existing fingerprint of a database
+type Conflict struct {
+	Existing *Fingerprint
+	Example  *FingerprintExample
+	// CandidateExample is set when the example belongs to the candidate, which the
+	// existing fingerprint shadows if the candidate is added after it. Otherwise the
+	// example belongs to the existing fingerprint, which the candidate shadows if it
+	// is added before it.
+	CandidateExample bool
+}
+
+// CheckNewFingerprint normalizes a candidate fingerprint and reports each example
+// of the candidate matched by an existing fingerprint, and each example of an
+// existing fingerprint matched by the candidate. The database is not modified.
+func (fdb *FingerprintDB) CheckNewFingerprint(fp *Fingerprint) ([]Conflict, error) {
+	if err := fp.normalize(compileOptions{anchored: fdb.Anchored, maxCaptures: fdb.MaxCaptureGroups}); err != nil {
+		return nil, err
+	}
+
+	ret := []Conflict{}
+	for _, ex := range fp.Examples {
+		data, err := fp.exampleData(ex, fdb.ExamplesPath)
+		if err != nil {
+			return nil, err
+		}
+		for _, existing := range fdb.Fingerprints {
+			if existing.findSubmatch(data) != nil {
+				ret = append(ret, Conflict{Existing: existing, Example: ex, CandidateExample: true})
+			}
+		}
+	}
+
+	for _, existing := range fdb.Fingerprints {
+		for _, ex := range existing.Examples {
+			data, err := existing.exampleData(ex, fdb.examplesPath(existing))
+			if err != nil {
+				continue
+			}
+			if fp.findSubmatch(data) != nil {
+				ret = append(ret, Conflict{Existing: existing, Example: ex})
+			}
+		}
+	}
+	return ret, nil
+}
+
 // LoadFingerprintDBFromFile parses a Recog XML file from disk and returns a FingerprintDB
 func LoadFingerprintDBFromFile(fpath string) (FingerprintDB, error) {
 	fdb := FingerprintDB{}
 
-	xmlData, err := ioutil.ReadFile(fpath)
+	f, err := os.Open(fpath)
 	if err != nil {
 		fdb.DebugLogf("failed to load fdb from file %s: %s", fpath, err)
 		return fdb, err
 	}
+	defer f.Close()
 
 	fdb.DebugLogf("loaded from file %s", fpath)
-	return LoadFingerprintDB(filepath.Base(fpath), xmlData)
+	fdb, err = LoadFingerprintDBFromReader(filepath.Base(fpath), f)
+	if err != nil {
+		return fdb, err
+	}
+
+	// External examples live in a directory named after the database file
+	fdb.ExamplesPath = strings.TrimSuffix(fpath, filepath.Ext(fpath))
+	return fdb, nil
 }
 
 // LoadFingerprintDB parses a Recog XML file from a byte array and returns a FingerprintDB
 func LoadFingerprintDB(name string, xmlData []byte) (FingerprintDB, error) {
-	fdb := FingerprintDB{}
+	return loadFingerprintDB(name, xmlData, false)
+}
+
+// LoadFingerprintDBFromReader parses a Recog XML file as it is read from r and returns a
+// FingerprintDB. The name identifies the source in errors and is stored like that of LoadFingerprintDB.
+// Fingerprints are decoded and normalized one at a time, so the XML is never held in memory
+// as a whole. The result is the same as that of LoadFingerprintDB.
+func LoadFingerprintDBFromReader(name string, r io.Reader) (FingerprintDB, error) {
+	fdb := FingerprintDB{Name: name}
+	if _, err := fdb.decodeStream(r, nil); err != nil {
+		return fdb, fmt.Errorf("failed to load %s: %s", name, err)
+	}
+	return fdb, nil
+}
+
+// decodeStream decodes a Recog XML file token by token as it is read from r,
+// normalizing each fingerprint as soon as it has been decoded. The options already
+// set on fdb, such as LazyCompile, apply. If filter rejects the database after the
+// attributes of the root element are decoded, no fingerprints are decoded and false
+// is returned. The checksum covers the full contents, including any trailing data
+// after the root element.
+func (fdb *FingerprintDB) decodeStream(r io.Reader, filter func(*FingerprintDB) bool) (bool, error) {
+	sum := sha256.New()
+	tee := io.TeeReader(r, sum)
+	d := xml.NewDecoder(tee)
+
+	root, err := nextStartElement(d)
+	if err != nil {
+		return false, err
+	}
+	// Decode the root attributes with the struct tags of FingerprintDB, using an empty copy of the root
+	if err := xml.NewTokenDecoder(&tokenList{root, root.End()}).Decode(fdb); err != nil {
+		return false, err
+	}
+	if filter != nil && !filter(fdb) {
+		return false, nil
+	}
+	fdb.normalizePreference()
+	fdb.mega = nil
+	if fdb.budget != nil {
+		fdb.budget.used = 0
+	}
+
+	if err := fdb.decodeFingerprints(d); err != nil {
+		return false, err
+	}
+	if _, err := io.Copy(ioutil.Discard, tee); err != nil {
+		return false, err
+	}
+	fdb.Checksum = hex.EncodeToString(sum.Sum(nil))
+	return true, nil
+}
+
+// decodeFingerprints decodes and normalizes the fingerprints of the root element
+func (fdb *FingerprintDB) decodeFingerprints(d *xml.Decoder) error {
+	for {
+		t, err := d.Token()
+		if err != nil {
+			return err
+		}
+		switch se := t.(type) {
+		case xml.StartElement:
+			if se.Name.Local != "fingerprint" {
+				if err := d.Skip(); err != nil {
+					return err
+				}
+				continue
+			}
+			fp := &Fingerprint{}
+			if err := d.DecodeElement(fp, &se); err != nil {
+				return err
+			}
+			if err := fdb.normalizeFingerprint(fp); err != nil {
+				return err
+			}
+			fdb.Fingerprints = append(fdb.Fingerprints, fp)
+		case xml.EndElement:
+			fdb.index()
+			return nil
+		}
+	}
+}
+
+// tokenList is an xml.TokenReader returning a fixed list of tokens
+type tokenList []xml.Token
+
+// Token returns the next token of the list, or io.EOF at the end
+func (tl *tokenList) Token() (xml.Token, error) {
+	if len(*tl) == 0 {
+		return nil, io.EOF
+	}
+	t := (*tl)[0]
+	*tl = (*tl)[1:]
+	return t, nil
+}
+
+// nextStartElement returns the next start element of the decoder
+func nextStartElement(d *xml.Decoder) (xml.StartElement, error) {
+	for {
+		t, err := d.Token()
+		if err != nil {
+			return xml.StartElement{}, err
+		}
+		if se, ok := t.(xml.StartElement); ok {
+			return se, nil
+		}
+	}
+}
+
+// loadFingerprintDB parses a Recog XML file, optionally deferring pattern compilation
+func loadFingerprintDB(name string, xmlData []byte, lazy bool) (FingerprintDB, error) {
+	fdb := FingerprintDB{LazyCompile: lazy}
 	err := xml.Unmarshal(xmlData, &fdb)
 	if err != nil {
 		return fdb, err
 	}
 
-	// Store the source name
+	// Store the source name and a checksum of the contents
 	fdb.Name = name
+	sum := sha256.Sum256(xmlData)
+	fdb.Checksum = hex.EncodeToString(sum[:])
 
 	// Normalize the fingerprints
 	err = fdb.Normalize()
@@ -372,3 +1975,28 @@ func LoadFingerprintDB(name string, xmlData []byte) (FingerprintDB, error) {
 
 	return fdb, nil
 }
+
+// extend adds the fingerprints of a partial database, see FingerprintDB.Extends. The
+// checksum of the database is updated to cover the partial database.
+func (fdb *FingerprintDB) extend(partial *FingerprintDB) error {
+	switch partial.ExtendOrder {
+	case "", "first":
+		fdb.Fingerprints = append(append([]*Fingerprint{}, partial.Fingerprints...), fdb.Fingerprints...)
+	case "last":
+		fdb.Fingerprints = append(fdb.Fingerprints, partial.Fingerprints...)
+	default:
+		return fmt.Errorf("failed to load %s: invalid extend_order %q", partial.Name, partial.ExtendOrder)
+	}
+	for _, fp := range partial.Fingerprints {
+		if fp.examplesPath == "" {
+			fp.examplesPath = partial.ExamplesPath
+		}
+	}
+	fdb.DebugLogf("extended %s with %d fingerprints from %s", fdb.Name, len(partial.Fingerprints), partial.Name)
+
+	sum := sha256.Sum256([]byte(fdb.Checksum + partial.Checksum))
+	fdb.Checksum = hex.EncodeToString(sum[:])
+	fdb.mega = nil
+	fdb.index()
+	return nil
+}
@@ -1,6 +1,13 @@
 package recog
 
 import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"io/ioutil"
+	"math/rand"
+	"reflect"
 	"regexp"
 	"strconv"
 	"strings"
@@ -150,3 +157,1768 @@ func (s *set) contains(v interface{}) bool {
 func (s *set) len() int {
 	return len(*s)
 }
+
+func TestTopMatches(t *testing.T) {
+	xmlData := `<fingerprints matches="test">
+  <fingerprint pattern="^Acme" certainty="0.5">
+    <description>Acme low</description>
+    <param pos="0" name="service.product" value="Low"/>
+  </fingerprint>
+  <fingerprint pattern="^Acme Server">
+    <description>Acme default</description>
+    <param pos="0" name="service.product" value="Default"/>
+  </fingerprint>
+  <fingerprint pattern="^Acme Server v1" certainty="1.0">
+    <description>Acme high</description>
+    <param pos="0" name="service.product" value="High"/>
+  </fingerprint>
+  <fingerprint pattern="^Acme Server v" certainty="0.5">
+    <description>Acme low tie</description>
+    <param pos="0" name="service.product" value="LowTie"/>
+  </fingerprint>
+</fingerprints>`
+	fdb, err := LoadFingerprintDB("test.xml", []byte(xmlData))
+	if err != nil {
+		t.Fatalf("LoadFingerprintDB() failed: %s", err)
+	}
+
+	ms := fdb.TopMatches("Acme Server v1.0", 3)
+	want := []string{"High", "Default", "Low"}
+	if len(ms) != len(want) {
+		t.Fatalf("TopMatches() returned %d matches, expected %d", len(ms), len(want))
+	}
+	for i, m := range ms {
+		if m.Values["service.product"] != want[i] {
+			t.Errorf("TopMatches()[%d] = %q, expected %q", i, m.Values["service.product"], want[i])
+		}
+	}
+
+	if ms := fdb.TopMatches("Acme Server v1.0", 0); len(ms) != 4 || ms[3].Values["service.product"] != "LowTie" {
+		t.Errorf("TopMatches() with n=0 should return all matches in ranked order")
+	}
+
+	if ms := fdb.TopMatches("Unknown", 3); len(ms) != 0 {
+		t.Errorf("TopMatches() returned %d matches for unmatched data", len(ms))
+	}
+}
+
+func TestMatchAllOccurrences(t *testing.T) {
+	xmlData := `<fingerprints matches="test">
+  <fingerprint pattern="(?m)^Set-Cookie: ([^=]+)=">
+    <description>Cookie names</description>
+    <param pos="1" name="cookie"/>
+    <param pos="0" name="service.product" value="{cookie} cookie"/>
+  </fingerprint>
+</fingerprints>`
+	fdb, err := LoadFingerprintDB("test.xml", []byte(xmlData))
+	if err != nil {
+		t.Fatalf("LoadFingerprintDB() failed: %s", err)
+	}
+
+	data := "HTTP/1.1 200 OK\nSet-Cookie: SESSIONID=abc\nSet-Cookie: lang=en\n"
+	ms := fdb.Fingerprints[0].MatchAllOccurrences(data)
+	want := []string{"SESSIONID", "lang"}
+	if len(ms) != len(want) {
+		t.Fatalf("MatchAllOccurrences() returned %d matches, expected %d", len(ms), len(want))
+	}
+	for i, m := range ms {
+		if !m.Matched || m.Values["cookie"] != want[i] {
+			t.Errorf("MatchAllOccurrences()[%d] cookie = %q, expected %q", i, m.Values["cookie"], want[i])
+		}
+		if m.Values["service.product"] != want[i]+" cookie" {
+			t.Errorf("MatchAllOccurrences()[%d] service.product = %q, expected substitution per occurrence", i, m.Values["service.product"])
+		}
+	}
+
+	if ms := fdb.Fingerprints[0].MatchAllOccurrences("HTTP/1.1 200 OK"); len(ms) != 0 {
+		t.Errorf("MatchAllOccurrences() returned %d matches for unmatched data", len(ms))
+	}
+}
+
+func TestMatchDebug(t *testing.T) {
+	xmlData := `<fingerprints matches="test">
+  <fingerprint pattern="^Acme Server v(\d+)\.(\d+)">
+    <description>Acme server</description>
+    <param pos="1" name="_tmp.major"/>
+    <param pos="2" name="_tmp.minor"/>
+    <param pos="0" name="service.version" value="{_tmp.major}.{_tmp.minor}"/>
+  </fingerprint>
+</fingerprints>`
+	fdb, err := LoadFingerprintDB("test.xml", []byte(xmlData))
+	if err != nil {
+		t.Fatalf("LoadFingerprintDB() failed: %s", err)
+	}
+	fp := fdb.Fingerprints[0]
+
+	m := fp.Match("Acme Server v2.4")
+	if _, ok := m.Values["_tmp.major"]; ok {
+		t.Errorf("Match() should remove temporary params: %v", m.Values)
+	}
+
+	m = fp.MatchDebug("Acme Server v2.4")
+	if m.Values["_tmp.major"] != "2" || m.Values["_tmp.minor"] != "4" {
+		t.Errorf("MatchDebug() should retain temporary params: %v", m.Values)
+	}
+	if m.Values["service.version"] != "2.4" {
+		t.Errorf("MatchDebug() service.version = %q, expected 2.4", m.Values["service.version"])
+	}
+}
+
+func TestMatchWithContext(t *testing.T) {
+	xmlData := `<fingerprints matches="test">
+  <fingerprint pattern="^Acme Server">
+    <description>Acme server</description>
+    <param pos="0" name="service.product" value="Acme"/>
+    <param pos="0" name="service.endpoint" value="{_ctx.host}:{_ctx.port}"/>
+  </fingerprint>
+</fingerprints>`
+	fdb, err := LoadFingerprintDB("test.xml", []byte(xmlData))
+	if err != nil {
+		t.Fatalf("LoadFingerprintDB() failed: %s", err)
+	}
+	fp := fdb.Fingerprints[0]
+
+	m := fp.MatchWithContext("Acme Server", map[string]string{"host": "192.0.2.1", "port": "8080"})
+	if len(m.Errors) > 0 {
+		t.Errorf("MatchWithContext() returned errors: %v", m.Errors)
+	}
+	if m.Values["service.endpoint"] != "192.0.2.1:8080" {
+		t.Errorf("MatchWithContext() service.endpoint = %q, expected 192.0.2.1:8080", m.Values["service.endpoint"])
+	}
+
+	m = fp.MatchWithContext("Acme Server", map[string]string{"host": "192.0.2.1"})
+	if len(m.Errors) != 1 || !strings.Contains(m.Errors[0].Error(), "_ctx.port") {
+		t.Errorf("MatchWithContext() should report the missing context value: %v", m.Errors)
+	}
+}
+
+func TestFindShadows(t *testing.T) {
+	xmlData := `<fingerprints matches="test">
+  <fingerprint pattern="^Acme Server">
+    <description>Acme generic</description>
+    <example>Acme Server</example>
+    <param pos="0" name="service.product" value="Acme"/>
+  </fingerprint>
+  <fingerprint pattern="^Acme Server v(\d+)">
+    <description>Acme versioned</description>
+    <example service.version="2">Acme Server v2</example>
+    <param pos="0" name="service.product" value="Acme"/>
+    <param pos="1" name="service.version"/>
+  </fingerprint>
+  <fingerprint pattern="^Other Server">
+    <description>Other</description>
+    <example>Other Server</example>
+    <param pos="0" name="service.product" value="Other"/>
+  </fingerprint>
+</fingerprints>`
+	fdb, err := LoadFingerprintDB("test.xml", []byte(xmlData))
+	if err != nil {
+		t.Fatalf("LoadFingerprintDB() failed: %s", err)
+	}
+
+	shadows := fdb.FindShadows()
+	if len(shadows) != 1 {
+		t.Fatalf("FindShadows() returned %d reports, expected 1: %#v", len(shadows), shadows)
+	}
+	if shadows[0].Fingerprint != fdb.Fingerprints[1] || shadows[0].ShadowedBy != fdb.Fingerprints[0] {
+		t.Errorf("FindShadows() reported the wrong fingerprints: %#v", shadows[0])
+	}
+	if shadows[0].Example.Text != "Acme Server v2" {
+		t.Errorf("FindShadows() reported the wrong example: %q", shadows[0].Example.Text)
+	}
+}
+
+func TestCaptureConsistency(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+		params  []*FingerprintParam
+		valid   bool
+	}{
+		{"consistent", `^Acme v(\d+)\.(\d+)`, []*FingerprintParam{{Position: "1", Name: "a"}, {Position: "2", Name: "b"}, {Position: "0", Name: "c", Value: "x"}}, true},
+		{"missing group", `^Acme v(\d+)`, []*FingerprintParam{{Position: "2", Name: "a"}}, false},
+		{"unused group", `^Acme v(\d+)\.(\d+)`, []*FingerprintParam{{Position: "1", Name: "a"}}, false},
+		{"invalid index", `^Acme`, []*FingerprintParam{{Position: "x", Name: "a"}}, false},
+	}
+	for _, tc := range tests {
+		fp := &Fingerprint{Pattern: tc.pattern, Params: tc.params}
+		if err := fp.Normalize(); err != nil {
+			t.Fatalf("%s: Normalize() failed: %s", tc.name, err)
+		}
+		err := fp.Validate()
+		if tc.valid && err != nil {
+			t.Errorf("%s: Validate() failed: %s", tc.name, err)
+		}
+		if !tc.valid && err == nil {
+			t.Errorf("%s: Validate() should have failed", tc.name)
+		}
+	}
+}
+
+func TestMatchFirstFold(t *testing.T) {
+	xmlData := `<fingerprints matches="test">
+  <fingerprint pattern="^Acme Server v(\d+)$">
+    <description>Acme server</description>
+    <param pos="0" name="service.product" value="Acme"/>
+    <param pos="1" name="service.version"/>
+  </fingerprint>
+</fingerprints>`
+	fdb, err := LoadFingerprintDB("test.xml", []byte(xmlData))
+	if err != nil {
+		t.Fatalf("LoadFingerprintDB() failed: %s", err)
+	}
+
+	data := "ACME server V2"
+	if m := fdb.MatchFirst(data); m.Matched {
+		t.Errorf("MatchFirst() should be case-sensitive: %#v", m)
+	}
+	if m := fdb.MatchFirstFold(data); m.Matched || len(m.Errors) == 0 {
+		t.Errorf("MatchFirstFold() should fail when folding is not enabled: %#v", m)
+	}
+
+	if err := fdb.EnableFoldCase(); err != nil {
+		t.Fatalf("EnableFoldCase() failed: %s", err)
+	}
+	m := fdb.MatchFirstFold(data)
+	if !m.Matched || m.Values["service.version"] != "2" {
+		t.Errorf("MatchFirstFold() failed to match %q: %#v", data, m)
+	}
+	if m := fdb.MatchFirst(data); m.Matched {
+		t.Errorf("MatchFirst() should remain case-sensitive: %#v", m)
+	}
+
+	// MatchFirstFold is profiled and recovers from panics like MatchFirst
+	fdb.EnableMatchProfile()
+	fdb.MatchFirstFold(data)
+	if profile := fdb.MatchProfile(); len(profile) != 1 || profile[0].Calls != 1 {
+		t.Errorf("MatchFirstFold() was not profiled: %+v", profile)
+	}
+	fdb.RecoverPanics = true
+	fdb.Fingerprints = append([]*Fingerprint{{Pattern: "^Acme", matchersFolded: []*regexp.Regexp{nil}}}, fdb.Fingerprints...)
+	m = fdb.MatchFirstFold(data)
+	if !m.Matched || m.Values["service.version"] != "2" {
+		t.Errorf("MatchFirstFold() should continue past a panic: %#v", m)
+	}
+}
+
+func TestReconcileDevice(t *testing.T) {
+	xmlData := `<fingerprints matches="test">
+  <fingerprint pattern="^Printer">
+    <description>Printer</description>
+    <param pos="0" name="os.device" value="Printer"/>
+    <param pos="0" name="hw.device" value="Printer"/>
+  </fingerprint>
+  <fingerprint pattern="^Router">
+    <description>Router</description>
+    <param pos="0" name="os.device" value="Router"/>
+  </fingerprint>
+  <fingerprint pattern="^Conflict">
+    <description>Conflict</description>
+    <param pos="0" name="os.device" value="General"/>
+    <param pos="0" name="hw.device" value="Router"/>
+  </fingerprint>
+  <fingerprint pattern="^Service">
+    <description>No device</description>
+    <param pos="0" name="service.product" value="Acme"/>
+  </fingerprint>
+</fingerprints>`
+	fdb, err := LoadFingerprintDB("test.xml", []byte(xmlData))
+	if err != nil {
+		t.Fatalf("LoadFingerprintDB() failed: %s", err)
+	}
+
+	tests := []struct {
+		data   string
+		device string
+		errors int
+	}{
+		{"Printer", "Printer", 0},
+		{"Router", "Router", 0},
+		{"Conflict", "Router", 1},
+		{"Service", "", 0},
+	}
+	for _, tc := range tests {
+		m := fdb.MatchFirst(tc.data)
+		if m.Values[DeviceKey] != tc.device || len(m.Errors) != tc.errors {
+			t.Errorf("%s: device = %q with errors %v, expected %q with %d errors", tc.data, m.Values[DeviceKey], m.Errors, tc.device, tc.errors)
+		}
+	}
+	if _, ok := fdb.MatchFirst("Service").Values[DeviceKey]; ok {
+		t.Errorf("device should not be set when no device fields are extracted")
+	}
+
+	// A param named device is the fingerprint's own and is not replaced
+	fp := &Fingerprint{Pattern: "^Switch", Params: []*FingerprintParam{
+		{Position: "0", Name: "device", Value: "Custom"},
+		{Position: "0", Name: "hw.device", Value: "Switch"},
+	}}
+	if err := fp.Normalize(); err != nil {
+		t.Fatalf("Normalize() failed: %s", err)
+	}
+	m := fp.Match("Switch")
+	if m.Values["device"] != "Custom" || m.Values[DeviceKey] != "Switch" {
+		t.Errorf("device values = %q and %q", m.Values["device"], m.Values[DeviceKey])
+	}
+	if ex := m.ToExample("Switch"); ex.AttributeMap["device"] != "Custom" || ex.AttributeMap[DeviceKey] != "" {
+		t.Errorf("ToExample() attributes = %v", ex.AttributeMap)
+	}
+
+	// The device is not set on every match, so it cannot be referenced as a param
+	fp = &Fingerprint{Pattern: "^Switch", Params: []*FingerprintParam{
+		{Position: "0", Name: "hw.device", Value: "Switch"},
+		{Position: "0", Name: "service.product", Value: "{device}"},
+	}}
+	if err := fp.Normalize(); err != nil {
+		t.Fatalf("Normalize() failed: %s", err)
+	}
+	if err := fp.Validate(); err == nil {
+		t.Errorf("Validate() should report the reference to the undefined device param")
+	}
+}
+
+func TestMultiplePatterns(t *testing.T) {
+	xmlData := `<fingerprints matches="test">
+  <fingerprint>
+    <description>Acme server</description>
+    <pattern>^Acme Server v(\d+)$</pattern>
+    <pattern>^Acme/(\d+)$</pattern>
+    <param pos="0" name="service.product" value="Acme"/>
+    <param pos="1" name="service.version"/>
+  </fingerprint>
+  <fingerprint pattern="^Widget (\d+)$">
+    <description>Widget server</description>
+    <pattern>^widget-(\d+)$</pattern>
+    <param pos="0" name="service.product" value="Widget"/>
+    <param pos="1" name="service.version"/>
+  </fingerprint>
+</fingerprints>`
+	fdb, err := LoadFingerprintDB("test.xml", []byte(xmlData))
+	if err != nil {
+		t.Fatalf("LoadFingerprintDB() failed: %s", err)
+	}
+	if n := len(fdb.Fingerprints[0].PatternsCompiled); n != 2 {
+		t.Errorf("expected 2 compiled patterns, got %d", n)
+	}
+
+	tests := []struct {
+		data    string
+		product string
+		version string
+	}{
+		{"Acme Server v2", "Acme", "2"},
+		{"Acme/3", "Acme", "3"},
+		{"Widget 4", "Widget", "4"},
+		{"widget-5", "Widget", "5"},
+		{"Other", "", ""},
+	}
+	for _, tc := range tests {
+		m := fdb.MatchFirst(tc.data)
+		if m.Values["service.product"] != tc.product || m.Values["service.version"] != tc.version {
+			t.Errorf("MatchFirst(%q) returned %#v", tc.data, m.Values)
+		}
+	}
+
+	fp := &Fingerprint{Patterns: []string{`^a(\d)`, `^b`}, Params: []*FingerprintParam{{Position: "1", Name: "a"}}}
+	if err := fp.Normalize(); err != nil {
+		t.Fatalf("Normalize() failed: %s", err)
+	}
+	if err := fp.CaptureConsistency(); err == nil {
+		t.Errorf("CaptureConsistency() should fail for patterns with different capture groups")
+	}
+}
+
+func TestPreprocessor(t *testing.T) {
+	xmlData := `<fingerprints matches="test">
+  <fingerprint pattern="^Acme Server v(\d+)$">
+    <description>Acme server</description>
+    <param pos="0" name="service.product" value="Acme"/>
+    <param pos="1" name="service.version"/>
+  </fingerprint>
+</fingerprints>`
+	fdb, err := LoadFingerprintDB("test.xml", []byte(xmlData))
+	if err != nil {
+		t.Fatalf("LoadFingerprintDB() failed: %s", err)
+	}
+
+	data := "  Acme \t Server\r\n v2 "
+	if m := fdb.MatchFirst(data); m.Matched {
+		t.Errorf("MatchFirst() should not match without a preprocessor: %#v", m)
+	}
+
+	fdb.Preprocessor = CollapseWhitespace
+	m := fdb.MatchFirst(data)
+	if !m.Matched || m.Values["service.version"] != "2" {
+		t.Errorf("MatchFirst() failed to match %q: %#v", data, m)
+	}
+	if m.Input != data {
+		t.Errorf("MatchFirst() should report the original input, got %q", m.Input)
+	}
+	if m := fdb.MatchFirst("Other  server"); m.Matched || m.Input != "Other  server" {
+		t.Errorf("MatchFirst() should report the original input when not matched: %#v", m)
+	}
+	if ms := fdb.MatchAll(data); len(ms) != 1 || ms[0].Input != data {
+		t.Errorf("MatchAll() failed to match %q: %#v", data, ms)
+	}
+}
+
+func TestMatchFirstBatch(t *testing.T) {
+	fdb := loadTestDatabases(t).Databases["ssh_banners.xml"]
+
+	inputs := []string{}
+	for i := 0; i < 100; i++ {
+		inputs = append(inputs, "OpenSSH_7."+strconv.Itoa(i%10), "unknown banner "+strconv.Itoa(i))
+	}
+	for _, workers := range []int{0, 1, 4, 500} {
+		ms := fdb.MatchFirstBatch(inputs, workers)
+		if len(ms) != len(inputs) {
+			t.Fatalf("MatchFirstBatch(%d) returned %d results for %d inputs", workers, len(ms), len(inputs))
+		}
+		for i, m := range ms {
+			expected := fdb.MatchFirst(inputs[i])
+			if m.Input != inputs[i] || m.Matched != expected.Matched || m.Values["service.version"] != expected.Values["service.version"] {
+				t.Errorf("MatchFirstBatch(%d) result %d does not match %q: %#v", workers, i, inputs[i], m)
+			}
+		}
+	}
+}
+
+func BenchmarkMatchFirstBatch(b *testing.B) {
+	fset, err := LoadFingerprints()
+	if err != nil {
+		b.Fatalf("LoadFingerprints() failed: %s", err)
+	}
+	fdb := fset.Databases["ssh_banners.xml"]
+
+	inputs := make([]string, 1000)
+	for i := range inputs {
+		inputs[i] = "OpenSSH_7." + strconv.Itoa(i%10)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		fdb.MatchFirstBatch(inputs, 8)
+	}
+}
+
+func TestSkipEmpty(t *testing.T) {
+	xmlData := `<fingerprints matches="test">
+  <fingerprint pattern="^Acme">
+    <description>Acme server</description>
+    <param pos="0" name="service.product" value="Acme"/>
+  </fingerprint>
+  <fingerprint pattern="^\s*$">
+    <description>Blank</description>
+    <param pos="0" name="service.product" value="Blank"/>
+  </fingerprint>
+</fingerprints>`
+	fdb, err := LoadFingerprintDB("test.xml", []byte(xmlData))
+	if err != nil {
+		t.Fatalf("LoadFingerprintDB() failed: %s", err)
+	}
+	if fdb.Fingerprints[0].MatchesEmpty() || !fdb.Fingerprints[1].MatchesEmpty() {
+		t.Errorf("MatchesEmpty() reported the wrong fingerprints")
+	}
+
+	for _, data := range []string{"", " ", "\r\n\t"} {
+		if m := fdb.MatchFirst(data); !m.Matched {
+			t.Errorf("MatchFirst(%q) should match without SkipEmpty", data)
+		}
+	}
+
+	fdb.SkipEmpty = true
+	for _, data := range []string{"", " ", "\r\n\t"} {
+		if m := fdb.MatchFirst(data); m.Matched {
+			t.Errorf("MatchFirst(%q) should not match with SkipEmpty: %#v", data, m)
+		}
+		if ms := fdb.MatchAll(data); len(ms) != 0 {
+			t.Errorf("MatchAll(%q) should not match with SkipEmpty: %#v", data, ms)
+		}
+	}
+	if m := fdb.MatchFirst(" Acme"); m.Matched {
+		t.Errorf("MatchFirst() should not strip input with SkipEmpty: %#v", m)
+	}
+	if m := fdb.MatchFirst("Acme"); !m.Matched {
+		t.Errorf("MatchFirst() failed to match with SkipEmpty")
+	}
+}
+
+func TestMatchFingerprint(t *testing.T) {
+	fdb := loadTestDatabases(t).Databases["ssh_banners.xml"]
+
+	m := fdb.MatchFirst("OpenSSH_7.4")
+	if !m.Matched {
+		t.Fatalf("MatchFirst() failed to match")
+	}
+	fp := m.Fingerprint()
+	if fp == nil {
+		t.Fatalf("Fingerprint() should return the matching fingerprint")
+	}
+	if fp.Description == nil || fp.Description.Text != m.Values["matched"] {
+		t.Errorf("Fingerprint() returned the wrong fingerprint: %#v", fp)
+	}
+	if !fp.PatternCompiled.MatchString("OpenSSH_7.4") {
+		t.Errorf("Fingerprint() pattern %q does not match the input", fp.Pattern)
+	}
+
+	if m := fdb.MatchFirst("unknown"); m.Fingerprint() != nil {
+		t.Errorf("Fingerprint() should be nil without a match")
+	}
+}
+
+func TestMultiValues(t *testing.T) {
+	xmlData := `<fingerprints matches="test">
+  <fingerprint pattern="^Acme Appliance v(\d+) \(kernel (\S+)\)$">
+    <description>Acme appliance</description>
+    <param pos="0" name="service.product" value="Appliance"/>
+    <param pos="1" name="service.version"/>
+    <param pos="2" name="_tmp.kernel"/>
+    <param pos="0" name="service.cpe23" value="cpe:/a:acme:appliance:{service.version}"/>
+    <param pos="0" name="service.cpe23" value="cpe:/o:linux:linux_kernel:{_tmp.kernel}"/>
+  </fingerprint>
+</fingerprints>`
+	fdb, err := LoadFingerprintDB("test.xml", []byte(xmlData))
+	if err != nil {
+		t.Fatalf("LoadFingerprintDB() failed: %s", err)
+	}
+
+	m := fdb.MatchFirst("Acme Appliance v3 (kernel 5.10)")
+	if !m.Matched || len(m.Errors) > 0 {
+		t.Fatalf("MatchFirst() failed: %#v", m)
+	}
+	expected := []string{"cpe:/a:acme:appliance:3", "cpe:/o:linux:linux_kernel:5.10"}
+	if !reflect.DeepEqual(m.MultiValues["service.cpe23"], expected) {
+		t.Errorf("MultiValues = %#v, expected %#v", m.MultiValues["service.cpe23"], expected)
+	}
+	if m.Values["service.cpe23"] != expected[1] {
+		t.Errorf("Values should hold the last value, got %q", m.Values["service.cpe23"])
+	}
+	if _, ok := m.MultiValues["service.version"]; ok {
+		t.Errorf("MultiValues should only hold repeated params: %#v", m.MultiValues)
+	}
+	if a := m.ToAsset(); !reflect.DeepEqual(a.CPE23, expected) {
+		t.Errorf("ToAsset() CPE23 = %#v, expected %#v", a.CPE23, expected)
+	}
+
+	// A later param overrides an earlier one of the same name, as before MultiValues
+	fp := &Fingerprint{Pattern: `^Acme (\w+)$`, Params: []*FingerprintParam{
+		{Position: "0", Name: "service.product", Value: "Generic"},
+		{Position: "1", Name: "service.product"},
+	}}
+	if err := fp.Normalize(); err != nil {
+		t.Fatalf("Normalize() failed: %s", err)
+	}
+	if m := fp.Match("Acme Server"); m.Values["service.product"] != "Server" {
+		t.Errorf("Values should hold the last value, got %q", m.Values["service.product"])
+	}
+
+	// An unresolved template in a repeated param is reported once
+	fp = &Fingerprint{Pattern: `^Acme (\w+)$`, Params: []*FingerprintParam{
+		{Position: "1", Name: "service.version"},
+		{Position: "0", Name: "service.cpe23", Value: "cpe:/a:acme:server:{service.version}"},
+		{Position: "0", Name: "service.cpe23", Value: "cpe:/a:acme:{service.edition}"},
+	}}
+	if err := fp.Normalize(); err != nil {
+		t.Fatalf("Normalize() failed: %s", err)
+	}
+	m = fp.Match("Acme 2")
+	if len(m.Errors) != 1 || m.Values["service.cpe23"] != "cpe:/a:acme:{service.edition}" {
+		t.Errorf("Match() = %q with errors %v, expected one unresolved template error", m.Values["service.cpe23"], m.Errors)
+	}
+	expected = []string{"cpe:/a:acme:server:2", "cpe:/a:acme:{service.edition}"}
+	if !reflect.DeepEqual(m.MultiValues["service.cpe23"], expected) {
+		t.Errorf("MultiValues = %#v, expected %#v", m.MultiValues["service.cpe23"], expected)
+	}
+}
+
+func TestLazyCompile(t *testing.T) {
+	xmlData := `<fingerprints matches="test">
+  <fingerprint pattern="^Acme Server v(\d+)$">
+    <description>Acme server</description>
+    <param pos="0" name="service.product" value="Acme"/>
+    <param pos="1" name="service.version"/>
+  </fingerprint>
+  <fingerprint pattern="^Broken (\d+">
+    <description>Broken</description>
+    <param pos="1" name="service.version"/>
+  </fingerprint>
+</fingerprints>`
+	if _, err := LoadFingerprintDB("test.xml", []byte(xmlData)); err == nil {
+		t.Fatalf("LoadFingerprintDB() should fail for an invalid pattern")
+	}
+
+	fdb, err := loadFingerprintDB("test.xml", []byte(xmlData), true)
+	if err != nil {
+		t.Fatalf("loadFingerprintDB() should defer pattern errors: %s", err)
+	}
+	if fdb.Fingerprints[0].PatternCompiled != nil {
+		t.Errorf("the pattern should not be compiled before use")
+	}
+
+	m := fdb.MatchFirst("Acme Server v2")
+	if !m.Matched || m.Values["service.version"] != "2" {
+		t.Errorf("MatchFirst() failed to match: %#v", m)
+	}
+	if fdb.Fingerprints[0].PatternCompiled == nil || fdb.Fingerprints[1].PatternCompiled != nil {
+		t.Errorf("only the used fingerprint should be compiled")
+	}
+
+	m = fdb.MatchFirst("Other")
+	if m.Matched || len(m.Errors) != 1 {
+		t.Errorf("MatchFirst() should report the compile error: %#v", m)
+	}
+	if err := fdb.Validate(); err == nil {
+		t.Errorf("Validate() should report the compile error")
+	}
+}
+
+func TestFindByDescription(t *testing.T) {
+	fdb := loadTestDatabases(t).Databases["ssh_banners.xml"]
+
+	desc := "OpenSSH with just a version, no comment by vendor"
+	fp, ok := fdb.FindByDescription(desc)
+	if !ok || fp.Description.Text != desc {
+		t.Fatalf("FindByDescription(%q) failed: %#v", desc, fp)
+	}
+	if m := fp.Match("OpenSSH_7.4"); !m.Matched {
+		t.Errorf("FindByDescription() returned the wrong fingerprint: %s", fp.Pattern)
+	}
+	if _, ok := fdb.FindByDescription("no such fingerprint"); ok {
+		t.Errorf("FindByDescription() found an unknown description")
+	}
+
+	// Databases assembled without Normalize are scanned
+	manual := &FingerprintDB{Fingerprints: []*Fingerprint{fp}}
+	if found, ok := manual.FindByDescription(desc); !ok || found != fp {
+		t.Errorf("FindByDescription() failed without an index")
+	}
+}
+
+func TestCheckNewFingerprint(t *testing.T) {
+	xmlData := `<fingerprints matches="test">
+  <fingerprint pattern="^Acme Server v(\d+)$">
+    <description>Acme server</description>
+    <example service.version="2">Acme Server v2</example>
+    <param pos="0" name="service.product" value="Acme"/>
+    <param pos="1" name="service.version"/>
+  </fingerprint>
+  <fingerprint pattern="^Widget/(\d+)$">
+    <description>Widget server</description>
+    <example service.version="3">Widget/3</example>
+    <param pos="0" name="service.product" value="Widget"/>
+    <param pos="1" name="service.version"/>
+  </fingerprint>
+</fingerprints>`
+	fdb, err := LoadFingerprintDB("test.xml", []byte(xmlData))
+	if err != nil {
+		t.Fatalf("LoadFingerprintDB() failed: %s", err)
+	}
+
+	// The generic candidate matches the Acme example, and its own example is matched by Acme
+	candidate := &Fingerprint{
+		Pattern:     `^Acme`,
+		Description: &FingerprintDescription{Text: "Any Acme"},
+		Examples:    []*FingerprintExample{{Text: "Acme Server v9"}},
+		Params:      []*FingerprintParam{{Position: "0", Name: "service.vendor", Value: "Acme"}},
+	}
+	conflicts, err := fdb.CheckNewFingerprint(candidate)
+	if err != nil {
+		t.Fatalf("CheckNewFingerprint() failed: %s", err)
+	}
+	if len(conflicts) != 2 {
+		t.Fatalf("CheckNewFingerprint() returned %d conflicts, expected 2: %#v", len(conflicts), conflicts)
+	}
+	for _, c := range conflicts {
+		if c.Existing != fdb.Fingerprints[0] {
+			t.Errorf("unexpected conflict with %s", c.Existing.Pattern)
+		}
+		if c.CandidateExample && c.Example.Text != "Acme Server v9" {
+			t.Errorf("unexpected candidate example %q", c.Example.Text)
+		}
+		if !c.CandidateExample && c.Example.Text != "Acme Server v2" {
+			t.Errorf("unexpected existing example %q", c.Example.Text)
+		}
+	}
+
+	distinct := &Fingerprint{Pattern: `^Gadget`, Examples: []*FingerprintExample{{Text: "Gadget"}}}
+	if conflicts, err := fdb.CheckNewFingerprint(distinct); err != nil || len(conflicts) != 0 {
+		t.Errorf("CheckNewFingerprint() reported conflicts for a distinct pattern: %#v %v", conflicts, err)
+	}
+	if _, err := fdb.CheckNewFingerprint(&Fingerprint{Pattern: `^(`}); err == nil {
+		t.Errorf("CheckNewFingerprint() should fail for an invalid pattern")
+	}
+	if len(fdb.Fingerprints) != 2 {
+		t.Errorf("CheckNewFingerprint() modified the database")
+	}
+}
+
+func TestReorder(t *testing.T) {
+	xmlData := `<fingerprints matches="test">
+  <fingerprint pattern="^Acme">
+    <description>Generic Acme</description>
+    <param pos="0" name="service.product" value="Generic"/>
+  </fingerprint>
+  <fingerprint pattern="^Acme Server" certainty="0.95">
+    <description>Acme server</description>
+    <param pos="0" name="service.product" value="Server"/>
+  </fingerprint>
+  <fingerprint pattern="^Acme Server v1" certainty="0.9">
+    <description>Acme server v1</description>
+    <param pos="0" name="service.product" value="Server v1"/>
+  </fingerprint>
+</fingerprints>`
+	fdb, err := LoadFingerprintDB("test.xml", []byte(xmlData))
+	if err != nil {
+		t.Fatalf("LoadFingerprintDB() failed: %s", err)
+	}
+
+	data := "Acme Server v1"
+	if m := fdb.MatchFirst(data); m.Values["service.product"] != "Generic" {
+		t.Fatalf("MatchFirst() should use the database order: %#v", m.Values)
+	}
+
+	fdb.SortByCertainty()
+	if m := fdb.MatchFirst(data); m.Values["service.product"] != "Server" {
+		t.Errorf("MatchFirst() should pick the most certain rule after SortByCertainty(): %#v", m.Values)
+	}
+
+	fdb.Reorder(func(a *Fingerprint, b *Fingerprint) bool {
+		return len(a.Pattern) > len(b.Pattern)
+	})
+	if m := fdb.MatchFirst(data); m.Values["service.product"] != "Server v1" {
+		t.Errorf("MatchFirst() should pick the boosted rule after Reorder(): %#v", m.Values)
+	}
+}
+
+func TestAntiPatterns(t *testing.T) {
+	xmlData := `<fingerprints matches="test">
+  <fingerprint pattern="^Acme Server v(\d+)">
+    <description>Acme server</description>
+    <anti-pattern>\(Emulated\)</anti-pattern>
+    <anti-pattern>honeypot</anti-pattern>
+    <param pos="0" name="service.product" value="Acme"/>
+    <param pos="1" name="service.version"/>
+  </fingerprint>
+  <fingerprint pattern="^Acme Server">
+    <description>Generic</description>
+    <param pos="0" name="service.product" value="Generic"/>
+  </fingerprint>
+</fingerprints>`
+	fdb, err := LoadFingerprintDB("test.xml", []byte(xmlData))
+	if err != nil {
+		t.Fatalf("LoadFingerprintDB() failed: %s", err)
+	}
+	if n := len(fdb.Fingerprints[0].AntiPatternsCompiled); n != 2 {
+		t.Fatalf("expected 2 compiled anti-patterns, got %d", n)
+	}
+
+	tests := []struct {
+		data    string
+		product string
+	}{
+		{"Acme Server v2", "Acme"},
+		{"Acme Server v2 (Emulated)", "Generic"},
+		{"Acme Server v2 honeypot", "Generic"},
+	}
+	for _, tc := range tests {
+		if m := fdb.MatchFirst(tc.data); m.Values["service.product"] != tc.product {
+			t.Errorf("MatchFirst(%q) returned %#v, expected %s", tc.data, m.Values, tc.product)
+		}
+	}
+	if m := fdb.Fingerprints[0].Match("Acme Server v2 (Emulated)"); m.Matched {
+		t.Errorf("Match() should be vetoed by the anti-pattern: %#v", m)
+	}
+	if ms := fdb.Fingerprints[0].MatchAllOccurrences("Acme Server v2 honeypot"); len(ms) != 0 {
+		t.Errorf("MatchAllOccurrences() should be vetoed by the anti-pattern: %#v", ms)
+	}
+
+	if err := fdb.EnableFoldCase(); err != nil {
+		t.Fatalf("EnableFoldCase() failed: %s", err)
+	}
+	if m := fdb.MatchFirstFold("ACME SERVER V2 HONEYPOT"); m.Values["service.product"] != "Generic" {
+		t.Errorf("MatchFirstFold() should apply folded anti-patterns: %#v", m.Values)
+	}
+}
+
+func TestExampleCoverage(t *testing.T) {
+	xmlData := `<fingerprints matches="test">
+  <fingerprint pattern="^Acme Server v(\d+) \((\w+)\)$">
+    <description>Acme server</description>
+    <example service.version="2">Acme Server v2 (pro)</example>
+    <param pos="0" name="service.product" value="Acme"/>
+    <param pos="1" name="service.version"/>
+    <param pos="2" name="service.edition"/>
+  </fingerprint>
+  <fingerprint pattern="^Widget/(\d+)$">
+    <description>Widget server</description>
+    <param pos="1" name="service.version"/>
+  </fingerprint>
+</fingerprints>`
+	fdb, err := LoadFingerprintDB("test.xml", []byte(xmlData))
+	if err != nil {
+		t.Fatalf("LoadFingerprintDB() failed: %s", err)
+	}
+
+	report := fdb.ExampleCoverage()
+	if len(report.Untested) != 1 || report.Untested[0] != fdb.Fingerprints[1] {
+		t.Errorf("ExampleCoverage() returned unexpected untested fingerprints: %#v", report.Untested)
+	}
+	if len(report.Unasserted) != 1 || report.Unasserted[0].Param != "service.edition" || report.Unasserted[0].Fingerprint != fdb.Fingerprints[0] {
+		t.Errorf("ExampleCoverage() returned unexpected unasserted params: %#v", report.Unasserted)
+	}
+}
+
+func TestRequireExamples(t *testing.T) {
+	xmlData := `<fingerprints matches="test">
+  <fingerprint pattern="^Acme Server v(\d+)$">
+    <description>Acme server</description>
+    <example service.version="2">Acme Server v2</example>
+    <param pos="1" name="service.version"/>
+  </fingerprint>
+  <fingerprint pattern="^Widget">
+    <description>Widget server</description>
+    <param pos="0" name="service.product" value="Widget"/>
+  </fingerprint>
+</fingerprints>`
+	fdb, err := LoadFingerprintDB("test.xml", []byte(xmlData))
+	if err != nil {
+		t.Fatalf("LoadFingerprintDB() failed: %s", err)
+	}
+	if err := fdb.VerifyExamples("."); err != nil {
+		t.Errorf("VerifyExamples() should allow missing examples by default: %s", err)
+	}
+
+	fdb.RequireExamples = true
+	err = fdb.VerifyExamples(".")
+	if err == nil || !strings.Contains(err.Error(), "^Widget") {
+		t.Errorf("VerifyExamples() should fail for a fingerprint without examples: %v", err)
+	}
+}
+
+func TestAnchored(t *testing.T) {
+	xmlData := `<fingerprints matches="test">
+  <fingerprint pattern="Acme Server v(\d+)">
+    <description>Acme server</description>
+    <param pos="1" name="service.version"/>
+  </fingerprint>
+  <fingerprint pattern="(?m)Widget/(\S+)">
+    <description>Widget server</description>
+    <param pos="1" name="service.version"/>
+  </fingerprint>
+  <fingerprint pattern="Gadget" anchored="false">
+    <description>Gadget server</description>
+  </fingerprint>
+</fingerprints>`
+
+	tests := []struct {
+		data       string
+		unanchored string
+		anchored   string
+	}{
+		{"Acme Server v2", "Acme server", "Acme server"},
+		{"<p>Powered by Acme Server v2</p>", "Acme server", ""},
+		{"Widget/1.0", "Widget server", "Widget server"},
+		{"HTTP/1.1 200 OK\r\nWidget/1.0", "Widget server", "Widget server"},
+		{"HTTP/1.1 200 OK\r\nServer: Widget/1.0", "Widget server", ""},
+		{"<p>Gadget</p>", "Gadget server", "Gadget server"},
+	}
+
+	for _, anchored := range []bool{false, true} {
+		fdb, err := LoadFingerprintDB("test.xml", []byte(xmlData))
+		if err != nil {
+			t.Fatalf("LoadFingerprintDB() failed: %s", err)
+		}
+		fdb.Anchored = anchored
+		if err := fdb.Normalize(); err != nil {
+			t.Fatalf("Normalize() failed: %s", err)
+		}
+		for _, tc := range tests {
+			expected := tc.unanchored
+			if anchored {
+				expected = tc.anchored
+			}
+			m := fdb.MatchFirst(tc.data)
+			desc := ""
+			if m.Matched {
+				desc = m.Fingerprint().Description.Text
+			}
+			if desc != expected {
+				t.Errorf("anchored=%v: %q matched %q, expected %q", anchored, tc.data, desc, expected)
+			}
+		}
+	}
+
+	fp := &Fingerprint{Pattern: "Acme", Anchored: "maybe"}
+	if err := fp.Normalize(); err == nil {
+		t.Errorf("Normalize() should fail for an invalid anchored value")
+	}
+}
+
+func TestMaxCaptureGroups(t *testing.T) {
+	fp := &Fingerprint{Pattern: "^" + strings.Repeat(`(\w)`, DefaultMaxCaptureGroups+1)}
+	err := fp.Normalize()
+	if err == nil || !strings.Contains(err.Error(), "capture groups") {
+		t.Errorf("Normalize() should fail for a pattern over the capture group limit: %v", err)
+	}
+
+	xmlData := `<fingerprints matches="test">
+  <fingerprint pattern="^Acme (\S+) (\S+) (\S+)$">
+    <description>Acme server</description>
+  </fingerprint>
+</fingerprints>`
+	fdb, err := LoadFingerprintDB("test.xml", []byte(xmlData))
+	if err != nil {
+		t.Fatalf("LoadFingerprintDB() failed: %s", err)
+	}
+	fdb.MaxCaptureGroups = 2
+	if err := fdb.Normalize(); err == nil {
+		t.Errorf("Normalize() should fail for a pattern over MaxCaptureGroups")
+	}
+	fdb.MaxCaptureGroups = 3
+	if err := fdb.Normalize(); err != nil {
+		t.Errorf("Normalize() failed for a pattern at MaxCaptureGroups: %s", err)
+	}
+}
+
+func TestRecoverPanics(t *testing.T) {
+	xmlData := `<fingerprints matches="test">
+  <fingerprint pattern="^Acme Server v(\d+)$">
+    <description>Acme server</description>
+    <param pos="1" name="service.version"/>
+  </fingerprint>
+</fingerprints>`
+	fdb, err := LoadFingerprintDB("test.xml", []byte(xmlData))
+	if err != nil {
+		t.Fatalf("LoadFingerprintDB() failed: %s", err)
+	}
+	// A fingerprint that was never normalized has no compiled pattern and panics
+	fdb.Fingerprints = append([]*Fingerprint{{Pattern: "^Acme"}}, fdb.Fingerprints...)
+	fdb.RecoverPanics = true
+
+	m := fdb.MatchFirst("Acme Server v2")
+	if !m.Matched || m.Values["service.version"] != "2" {
+		t.Errorf("MatchFirst() should continue past a panic: %#v", m)
+	}
+	m = fdb.MatchFirst("Widget")
+	if m.Matched || len(m.Errors) != 1 || !strings.Contains(m.Errors[0].Error(), "panic matching [^Acme]") {
+		t.Errorf("MatchFirst() should record the panic as an error: %#v", m.Errors)
+	}
+	if matches := fdb.MatchAll("Acme Server v2"); len(matches) != 1 {
+		t.Errorf("MatchAll() should continue past a panic: %#v", matches)
+	}
+
+	fdb.RecoverPanics = false
+	defer func() {
+		if recover() == nil {
+			t.Errorf("MatchFirst() should not recover without RecoverPanics")
+		}
+	}()
+	fdb.MatchFirst("Acme Server v2")
+}
+
+func TestMatchFirstWithTags(t *testing.T) {
+	xmlData := `<fingerprints matches="test">
+  <fingerprint pattern="^Acme" tags="deprecated, vendor:acme">
+    <description>Acme legacy</description>
+  </fingerprint>
+  <fingerprint pattern="^Acme Server" tags="vendor:acme experimental">
+    <description>Acme server</description>
+  </fingerprint>
+  <fingerprint pattern="^Acme Server v2">
+    <description>Acme server v2</description>
+  </fingerprint>
+</fingerprints>`
+	fdb, err := LoadFingerprintDB("test.xml", []byte(xmlData))
+	if err != nil {
+		t.Fatalf("LoadFingerprintDB() failed: %s", err)
+	}
+	if !fdb.Fingerprints[0].HasTag("vendor:acme") || !fdb.Fingerprints[1].HasTag("experimental") {
+		t.Errorf("tags were not parsed: %#v %#v", fdb.Fingerprints[0].TagList, fdb.Fingerprints[1].TagList)
+	}
+
+	tests := []struct {
+		include  []string
+		exclude  []string
+		expected string
+	}{
+		{nil, nil, "Acme legacy"},
+		{nil, []string{"deprecated"}, "Acme server"},
+		{nil, []string{"vendor:acme"}, "Acme server v2"},
+		{[]string{"experimental"}, nil, "Acme server"},
+		{[]string{"vendor:acme"}, []string{"deprecated"}, "Acme server"},
+		{[]string{"vendor:cisco"}, nil, ""},
+	}
+	for _, tc := range tests {
+		m := fdb.MatchFirstWithTags("Acme Server v2", tc.include, tc.exclude)
+		desc := ""
+		if m.Matched {
+			desc = m.Fingerprint().Description.Text
+		}
+		if desc != tc.expected {
+			t.Errorf("MatchFirstWithTags(%v, %v) matched %q, expected %q", tc.include, tc.exclude, desc, tc.expected)
+		}
+	}
+}
+
+func TestNormalizeLineEndings(t *testing.T) {
+	xmlData := `<fingerprints matches="test">
+  <fingerprint pattern="^Server: Acme/(\d+)$">
+    <description>Acme server</description>
+    <example _encoding="base64" service.version="2">SFRUUC8xLjAgMjAwIE9LDQpTZXJ2ZXI6IEFjbWUvMg0K</example>
+    <param pos="1" name="service.version"/>
+  </fingerprint>
+</fingerprints>`
+	fdb, err := LoadFingerprintDB("test.xml", []byte(xmlData))
+	if err != nil {
+		t.Fatalf("LoadFingerprintDB() failed: %s", err)
+	}
+
+	banner := "HTTP/1.0 200 OK\r\nServer: Acme/2\r\nDate: today\r\n"
+	if m := fdb.MatchFirst(banner); m.Matched {
+		t.Errorf("MatchFirst() should not match a CRLF banner without NormalizeLineEndings: %#v", m)
+	}
+	if err := fdb.VerifyExamples("."); err == nil {
+		t.Errorf("VerifyExamples() should fail for a CRLF example without NormalizeLineEndings")
+	}
+
+	fdb.NormalizeLineEndings = true
+	m := fdb.MatchFirst(banner)
+	if !m.Matched || m.Values["service.version"] != "2" || m.Input != banner {
+		t.Errorf("MatchFirst() failed to match a CRLF banner: %#v", m)
+	}
+	if err := fdb.VerifyExamples("."); err != nil {
+		t.Errorf("VerifyExamples() failed for a CRLF example: %s", err)
+	}
+}
+
+func TestLineAnyCRLF(t *testing.T) {
+	tests := []struct {
+		flags   string
+		data    string
+		version string
+		banner  string
+	}{
+		{"REG_LINE_ANY_CRLF", "HTTP/1.0 200 OK\nServer: Acme/2\nDate: today", "2", "HTTP/1.0 200 OK\nServer: Acme/2"},
+		{"REG_LINE_ANY_CRLF", "HTTP/1.0 200 OK\r\nServer: Acme/2\r\nDate: today", "2", "HTTP/1.0 200 OK\r\nServer: Acme/2"},
+		{"REG_LINE_ANY_CRLF", "HTTP/1.0 200 OK\rServer: Acme/2\rDate: today", "2", "HTTP/1.0 200 OK\rServer: Acme/2"},
+		{"REG_MULTILINE", "HTTP/1.0 200 OK\nServer: Acme/2\nDate: today", "2", "HTTP/1.0 200 OK\nServer: Acme/2"},
+		{"REG_MULTILINE", "HTTP/1.0 200 OK\r\nServer: Acme/2\r\nDate: today", "", ""},
+		{"REG_MULTILINE", "HTTP/1.0 200 OK\rServer: Acme/2\rDate: today", "", ""},
+	}
+	for _, tc := range tests {
+		fp := &Fingerprint{
+			Pattern: `^(HTTP/1.0 .+^Server: Acme/(\d+))$`,
+			Flags:   tc.flags,
+			Params: []*FingerprintParam{
+				{Position: "1", Name: "service.banner"},
+				{Position: "2", Name: "service.version"},
+			},
+		}
+		if err := fp.Normalize(); err != nil {
+			t.Fatalf("Normalize() failed: %s", err)
+		}
+		m := fp.Match(tc.data)
+		if m.Values["service.version"] != tc.version || m.Values["service.banner"] != tc.banner {
+			t.Errorf("%s: %q matched %#v, expected version %q and banner %q", tc.flags, tc.data, m.Values, tc.version, tc.banner)
+		}
+	}
+}
+
+func TestMatchedParamCollision(t *testing.T) {
+	xmlData := `<fingerprints matches="test">
+  <fingerprint pattern="^Acme (\S+)$">
+    <description>Acme server</description>
+    <param pos="1" name="matched"/>
+  </fingerprint>
+  <fingerprint pattern="^Widget (\S+)$">
+    <param pos="1" name="matched"/>
+  </fingerprint>
+</fingerprints>`
+	fdb, err := LoadFingerprintDB("test.xml", []byte(xmlData))
+	if err != nil {
+		t.Fatalf("LoadFingerprintDB() failed: %s", err)
+	}
+
+	// The description takes precedence over the param
+	if m := fdb.MatchFirst("Acme v2"); !m.Matched || m.Values["matched"] != "Acme server" {
+		t.Errorf("MatchFirst() should keep the description in matched: %#v", m.Values)
+	}
+	if m := fdb.MatchFirst("Widget v2"); !m.Matched || m.Values["matched"] != "v2" {
+		t.Errorf("MatchFirst() should use the param without a description: %#v", m.Values)
+	}
+
+	err = fdb.Fingerprints[0].Validate()
+	if err == nil || !strings.Contains(err.Error(), "collides with the description") {
+		t.Errorf("Validate() should report the collision: %v", err)
+	}
+	if err := fdb.Fingerprints[1].Validate(); err != nil {
+		t.Errorf("Validate() failed without a description: %s", err)
+	}
+}
+
+func TestReservedKeys(t *testing.T) {
+	xmlData := `<fingerprints matches="test">
+  <fingerprint pattern="^Acme (\S+)$" certainty="0.9">
+    <description>Acme server</description>
+    <param pos="1" name="service.version"/>
+  </fingerprint>
+  <fingerprint pattern="^Widget (\S+)$">
+    <description>Widget server</description>
+    <param pos="1" name="_recog.matched"/>
+  </fingerprint>
+  <fingerprint pattern="^Gadget (\S+)$">
+    <description>Gadget server</description>
+    <param pos="1" name="fp.certainty"/>
+  </fingerprint>
+</fingerprints>`
+	fdb, err := LoadFingerprintDB("test.xml", []byte(xmlData))
+	if err != nil {
+		t.Fatalf("LoadFingerprintDB() failed: %s", err)
+	}
+
+	m := fdb.MatchFirst("Acme v2")
+	if m.Values[MatchedKey] != "Acme server" || m.Values[CertaintyKey] != "0.9" {
+		t.Errorf("MatchFirst() is missing the reserved keys: %#v", m.Values)
+	}
+	if m.Values["matched"] != "Acme server" || m.Values["fp.certainty"] != "0.9" {
+		t.Errorf("MatchFirst() is missing the legacy keys: %#v", m.Values)
+	}
+
+	// Library values take precedence over params with the same names
+	if m := fdb.MatchFirst("Widget v2"); m.Values[MatchedKey] != "Widget server" {
+		t.Errorf("a param overwrote %s: %#v", MatchedKey, m.Values)
+	}
+	if m := fdb.MatchFirst("Gadget v2"); m.Values["fp.certainty"] != "0.85" {
+		t.Errorf("a param overwrote fp.certainty: %#v", m.Values)
+	}
+
+	if err := fdb.Fingerprints[0].Validate(); err != nil {
+		t.Errorf("Validate() failed: %s", err)
+	}
+	err = fdb.Fingerprints[1].Validate()
+	if err == nil || !strings.Contains(err.Error(), "reserved prefix _recog.") {
+		t.Errorf("Validate() should report the reserved prefix: %v", err)
+	}
+	err = fdb.Fingerprints[2].Validate()
+	if err == nil || !strings.Contains(err.Error(), "collides with the certainty") {
+		t.Errorf("Validate() should report the certainty collision: %v", err)
+	}
+}
+
+func TestParamRequire(t *testing.T) {
+	xmlData := `<fingerprints matches="test">
+  <fingerprint pattern="^Acme Server(?: v(\d+))?( beta)?$">
+    <description>Acme server</description>
+    <param pos="1" name="service.version" require="1"/>
+    <param pos="0" name="service.edition" value="Beta" require="2"/>
+    <param pos="2" name="_tmp.beta"/>
+  </fingerprint>
+</fingerprints>`
+	fdb, err := LoadFingerprintDB("test.xml", []byte(xmlData))
+	if err != nil {
+		t.Fatalf("LoadFingerprintDB() failed: %s", err)
+	}
+	if err := fdb.Validate(); err != nil {
+		t.Errorf("Validate() failed: %s", err)
+	}
+
+	m := fdb.MatchFirst("Acme Server v2 beta")
+	if m.Values["service.version"] != "2" || m.Values["service.edition"] != "Beta" || len(m.Errors) > 0 {
+		t.Errorf("MatchFirst() with the optional groups = %#v %v", m.Values, m.Errors)
+	}
+	m = fdb.MatchFirst("Acme Server")
+	if _, ok := m.Values["service.version"]; ok || !m.Matched {
+		t.Errorf("MatchFirst() should not set service.version without a version: %#v", m.Values)
+	}
+	if _, ok := m.Values["service.edition"]; ok {
+		t.Errorf("MatchFirst() should not set service.edition without beta: %#v", m.Values)
+	}
+
+	fp := &Fingerprint{
+		Pattern: `^Acme (\d+)$`,
+		Params:  []*FingerprintParam{{Position: "1", Name: "service.version", Require: "2"}},
+	}
+	if err := fp.Normalize(); err != nil {
+		t.Fatalf("Normalize() failed: %s", err)
+	}
+	if err := fp.Validate(); err == nil {
+		t.Errorf("Validate() should fail for a missing required group")
+	}
+	var captureErr *ErrCaptureMissing
+	if m := fp.Match("Acme 1"); len(m.Errors) != 1 || !errors.As(m.Errors[0], &captureErr) {
+		t.Errorf("Match() should report the missing required group: %v", m.Errors)
+	}
+}
+
+func TestParamTrim(t *testing.T) {
+	xmlData := `<fingerprints matches="test">
+  <fingerprint pattern="^Acme Server/([^(]+)\(([^)]+)\)">
+    <description>Acme server</description>
+    <param pos="1" name="service.version" trim="true" trim_chars=";"/>
+    <param pos="2" name="os.product" trim_chars=" "/>
+    <param pos="0" name="service.cpe23" value="cpe:/a:acme:server:{service.version}"/>
+  </fingerprint>
+</fingerprints>`
+	fdb, err := LoadFingerprintDB("test.xml", []byte(xmlData))
+	if err != nil {
+		t.Fatalf("LoadFingerprintDB() failed: %s", err)
+	}
+	if err := fdb.Validate(); err != nil {
+		t.Errorf("Validate() failed: %s", err)
+	}
+
+	m := fdb.MatchFirst("Acme Server/2.4.1; \t( Linux )")
+	expected := map[string]string{
+		"service.version": "2.4.1",
+		"os.product":      "Linux",
+		"service.cpe23":   "cpe:/a:acme:server:2.4.1",
+	}
+	for k, v := range expected {
+		if m.Values[k] != v {
+			t.Errorf("MatchFirst() %s = %q, expected %q", k, m.Values[k], v)
+		}
+	}
+
+	fdb.Fingerprints[0].Params[0].Trim = "yes"
+	if err := fdb.Validate(); err == nil {
+		t.Errorf("Validate() should fail for an invalid trim value")
+	}
+}
+
+func TestValidateReferences(t *testing.T) {
+	tests := []struct {
+		name   string
+		params []*FingerprintParam
+		err    string
+	}{
+		{"resolved", []*FingerprintParam{
+			{Position: "1", Name: "service.version"},
+			{Position: "0", Name: "service.cpe23", Value: "cpe:/a:acme:server:{service.version}"},
+			{Position: "0", Name: "host.name", Value: "{_ctx.host} {matched}"},
+		}, ""},
+		{"dangling", []*FingerprintParam{
+			{Position: "1", Name: "service.version"},
+			{Position: "0", Name: "service.cpe23", Value: "cpe:/a:{service.vendor}:server:{service.version}"},
+		}, "references undefined param {service.vendor}"},
+		{"conditional", []*FingerprintParam{
+			{Position: "1", Name: "service.version", Require: "1"},
+			{Position: "0", Name: "service.cpe23", Value: "cpe:/a:acme:server:{service.version}"},
+		}, "only set when its required group matched"},
+	}
+	for _, tc := range tests {
+		fp := &Fingerprint{Pattern: `^Acme Server(?: v(\d+))?`, Params: tc.params}
+		if err := fp.Normalize(); err != nil {
+			t.Fatalf("%s: Normalize() failed: %s", tc.name, err)
+		}
+		err := fp.Validate()
+		if tc.err == "" && err != nil {
+			t.Errorf("%s: Validate() failed: %s", tc.name, err)
+		}
+		if tc.err != "" && (err == nil || !strings.Contains(err.Error(), tc.err)) {
+			t.Errorf("%s: Validate() returned %v, expected %q", tc.name, err, tc.err)
+		}
+	}
+}
+
+func TestUnresolvedTemplates(t *testing.T) {
+	xmlData := `<fingerprints matches="test">
+  <fingerprint pattern="^Acme Server v(\d+)$">
+    <description>Acme server</description>
+    <param pos="1" name="service.version"/>
+    <param pos="0" name="service.cpe23" value="cpe:/a:{service.vendor}:server:{service.version}"/>
+  </fingerprint>
+  <fingerprint pattern="^Acme">
+    <description>Acme generic</description>
+  </fingerprint>
+</fingerprints>`
+	fdb, err := LoadFingerprintDB("test.xml", []byte(xmlData))
+	if err != nil {
+		t.Fatalf("LoadFingerprintDB() failed: %s", err)
+	}
+
+	m := fdb.MatchFirst("Acme Server v2")
+	if !m.Matched || m.Values["service.cpe23"] != "cpe:/a:{service.vendor}:server:2" || len(m.Errors) != 1 {
+		t.Errorf("MatchFirst() should keep unresolved templates by default: %#v", m)
+	}
+
+	fdb.UnresolvedTemplates = DropUnresolved
+	m = fdb.MatchFirst("Acme Server v2")
+	if _, ok := m.Values["service.cpe23"]; ok || !m.Matched || m.Values["service.version"] != "2" {
+		t.Errorf("MatchFirst() should drop the unresolved value: %#v", m.Values)
+	}
+
+	fdb.UnresolvedTemplates = RejectUnresolved
+	m = fdb.MatchFirst("Acme Server v2")
+	if !m.Matched || m.Fingerprint().Description.Text != "Acme generic" {
+		t.Errorf("MatchFirst() should skip the fingerprint with unresolved templates: %#v", m)
+	}
+	if matches := fdb.MatchAll("Acme Server v2"); len(matches) != 1 {
+		t.Errorf("MatchAll() should not return the rejected match: %#v", matches)
+	}
+	m = fdb.MatchFirst("Other")
+	if m.Matched {
+		t.Errorf("MatchFirst() matched unexpected data: %#v", m)
+	}
+}
+
+func TestChainedTemplates(t *testing.T) {
+	fp := &Fingerprint{
+		Pattern: `^Acme (\S+) v(\d+)$`,
+		Params: []*FingerprintParam{
+			{Position: "0", Name: "service.cpe23", Value: "cpe:/a:acme:{service.product}:{service.version}"},
+			{Position: "0", Name: "service.product", Value: "{service.family}_server"},
+			{Position: "0", Name: "service.family", Value: "{_tmp.family}"},
+			{Position: "1", Name: "_tmp.family"},
+			{Position: "2", Name: "service.version"},
+			{Position: "2", Name: "service.edition"},
+		},
+	}
+	if err := fp.Normalize(); err != nil {
+		t.Fatalf("Normalize() failed: %s", err)
+	}
+
+	expected := map[string]string{
+		"service.cpe23":   "cpe:/a:acme:widget_server:2",
+		"service.product": "widget_server",
+		"service.family":  "widget",
+		"service.version": "2",
+		"service.edition": "2",
+	}
+	for i := 0; i < 20; i++ {
+		m := fp.Match("Acme widget v2")
+		if len(m.Errors) > 0 {
+			t.Fatalf("Match() returned errors: %v", m.Errors)
+		}
+		for k, v := range expected {
+			if m.Values[k] != v {
+				t.Fatalf("Match() %s = %q, expected %q", k, m.Values[k], v)
+			}
+		}
+	}
+}
+
+func TestSubstitutionDeterministic(t *testing.T) {
+	params := []*FingerprintParam{
+		{Position: "0", Name: "service.cpe23", Value: "cpe:/a:{service.vendor}:{service.product}:{service.version}"},
+		{Position: "0", Name: "service.product", Value: "{service.family}"},
+		{Position: "0", Name: "service.family", Value: "{_tmp.family}_{service.vendor}"},
+		{Position: "0", Name: "service.vendor", Value: "acme"},
+		{Position: "1", Name: "_tmp.family"},
+		{Position: "2", Name: "service.version"},
+	}
+	expected := map[string]string{
+		"matched":         "Acme",
+		"service.cpe23":   "cpe:/a:acme:widget_acme:2",
+		"service.product": "widget_acme",
+		"service.family":  "widget_acme",
+		"service.vendor":  "acme",
+		"service.version": "2",
+	}
+
+	rnd := rand.New(rand.NewSource(1))
+	for i := 0; i < 50; i++ {
+		shuffled := append([]*FingerprintParam(nil), params...)
+		rnd.Shuffle(len(shuffled), func(a, b int) { shuffled[a], shuffled[b] = shuffled[b], shuffled[a] })
+		fp := &Fingerprint{Pattern: `^Acme (\S+) v(\d+)$`, Description: &FingerprintDescription{Text: "Acme"}, Params: shuffled}
+		if err := fp.Normalize(); err != nil {
+			t.Fatalf("Normalize() failed: %s", err)
+		}
+		m := fp.Match("Acme widget v2")
+		if len(m.Errors) > 0 {
+			t.Fatalf("Match() returned errors: %v", m.Errors)
+		}
+		for k, v := range expected {
+			if m.Values[k] != v {
+				t.Fatalf("Match() %s = %q, expected %q", k, m.Values[k], v)
+			}
+		}
+	}
+
+	// Cyclic references are reported the same way on every match
+	fp := &Fingerprint{
+		Pattern: `^Acme$`,
+		Params: []*FingerprintParam{
+			{Position: "0", Name: "os.vendor", Value: "{os.product}"},
+			{Position: "0", Name: "os.product", Value: "{os.vendor}"},
+		},
+	}
+	if err := fp.Normalize(); err != nil {
+		t.Fatalf("Normalize() failed: %s", err)
+	}
+	for i := 0; i < 20; i++ {
+		m := fp.Match("Acme")
+		if len(m.Errors) != 1 {
+			t.Fatalf("Match() returned %d errors, expected 1: %v", len(m.Errors), m.Errors)
+		}
+		var subErr *ErrSubstitution
+		if !errors.As(m.Errors[0], &subErr) || !subErr.Cycle || subErr.Name != "os.product" || subErr.Key != "os.vendor" {
+			t.Fatalf("Match() returned unexpected error: %v", m.Errors[0])
+		}
+		if m.Values["os.vendor"] != "{os.vendor}" || m.Values["os.product"] != "{os.vendor}" {
+			t.Fatalf("Match() returned unexpected values: %v", m.Values)
+		}
+	}
+}
+
+func TestTemplateDefaults(t *testing.T) {
+	fp := &Fingerprint{
+		Pattern: `^Acme (\S+)(?: v(\d+))?$`,
+		Params: []*FingerprintParam{
+			{Position: "1", Name: "service.product"},
+			{Position: "2", Name: "service.version"},
+			{Position: "0", Name: "service.cpe23", Value: "cpe:/a:acme:{service.product}:{service.version|unknown}"},
+			{Position: "0", Name: "service.edition", Value: `{service.family|a\|b\}\\c}`},
+		},
+	}
+	if err := fp.Normalize(); err != nil {
+		t.Fatalf("Normalize() failed: %s", err)
+	}
+	if err := fp.Validate(); err != nil {
+		t.Fatalf("Validate() failed: %s", err)
+	}
+
+	tests := []struct {
+		data string
+		cpe  string
+	}{
+		{"Acme widget v2", "cpe:/a:acme:widget:2"},
+		{"Acme widget", "cpe:/a:acme:widget:unknown"},
+	}
+	for _, tc := range tests {
+		m := fp.Match(tc.data)
+		if len(m.Errors) > 0 {
+			t.Fatalf("Match(%q) returned errors: %v", tc.data, m.Errors)
+		}
+		if m.Values["service.cpe23"] != tc.cpe {
+			t.Errorf("Match(%q) service.cpe23 = %q, expected %q", tc.data, m.Values["service.cpe23"], tc.cpe)
+		}
+		if m.Values["service.edition"] != `a|b}\c` {
+			t.Errorf("Match(%q) service.edition = %q, expected %q", tc.data, m.Values["service.edition"], `a|b}\c`)
+		}
+	}
+}
+
+func TestLoadFingerprintDBFromReader(t *testing.T) {
+	xmlData := `<fingerprints matches="test">
+  <fingerprint pattern="^Acme Server$">
+    <description>Acme</description>
+    <param pos="0" name="service.product" value="Server"/>
+  </fingerprint>
+</fingerprints>
+`
+	fdb, err := LoadFingerprintDBFromReader("test.xml", strings.NewReader(xmlData))
+	if err != nil {
+		t.Fatalf("LoadFingerprintDBFromReader() failed: %s", err)
+	}
+	if fdb.Name != "test.xml" || fdb.Matches != "test" || len(fdb.Fingerprints) != 1 {
+		t.Fatalf("LoadFingerprintDBFromReader() loaded %q matching %q with %d fingerprints", fdb.Name, fdb.Matches, len(fdb.Fingerprints))
+	}
+	if m := fdb.MatchFirst("Acme Server"); !m.Matched || m.Values["service.product"] != "Server" {
+		t.Errorf("MatchFirst() failed: %v", m.Values)
+	}
+
+	bfdb, err := LoadFingerprintDB("test.xml", []byte(xmlData))
+	if err != nil {
+		t.Fatalf("LoadFingerprintDB() failed: %s", err)
+	}
+	if fdb.Checksum != bfdb.Checksum {
+		t.Errorf("LoadFingerprintDBFromReader() checksum %s, expected %s", fdb.Checksum, bfdb.Checksum)
+	}
+
+	_, err = LoadFingerprintDBFromReader("broken.xml", strings.NewReader(`<fingerprints><fingerprint pattern="(">`))
+	if err == nil || !strings.Contains(err.Error(), "broken.xml") {
+		t.Errorf("LoadFingerprintDBFromReader() error %v does not name the source", err)
+	}
+}
+
+func TestLoadFingerprintDBStreamed(t *testing.T) {
+	fset := loadTestDatabases(t)
+	for name, xmlData := range testDatabases {
+		bulk, err := LoadFingerprintDB(name, []byte(xmlData))
+		if err != nil {
+			t.Fatalf("LoadFingerprintDB(%s) failed: %s", name, err)
+		}
+		streamed, err := LoadFingerprintDBFromReader(name, strings.NewReader(xmlData))
+		if err != nil {
+			t.Fatalf("LoadFingerprintDBFromReader(%s) failed: %s", name, err)
+		}
+
+		bj, _ := json.Marshal(bulk)
+		sj, _ := json.Marshal(streamed)
+		if !bytes.Equal(bj, sj) || bulk.XMLName != streamed.XMLName || bulk.PreferenceValue != streamed.PreferenceValue {
+			t.Errorf("LoadFingerprintDBFromReader(%s) differs from LoadFingerprintDB", name)
+		}
+		// FingerprintSet loaders stream each file of the directory
+		if fj, _ := json.Marshal(fset.Databases[name]); !bytes.Equal(bj, fj) {
+			t.Errorf("LoadFingerprintsDir() loaded %s differently from LoadFingerprintDB", name)
+		}
+	}
+
+	// Decode the embedded databases both ways, deferring compilation to keep this fast
+	root, err := RecogXML.Open("/")
+	if err != nil {
+		t.Fatalf("failed to open root: %s", err)
+	}
+	defer root.Close()
+	files, err := root.Readdir(-1)
+	if err != nil {
+		t.Fatalf("failed to read root: %s", err)
+	}
+	for _, f := range files {
+		if !strings.HasSuffix(f.Name(), ".xml") {
+			continue
+		}
+		xmlData := readRecogXML(t, f.Name())
+		bulk, err := loadFingerprintDB(f.Name(), xmlData, true)
+		if err != nil {
+			t.Fatalf("loadFingerprintDB(%s) failed: %s", f.Name(), err)
+		}
+		streamed := FingerprintDB{Name: f.Name(), LazyCompile: true}
+		if _, err := streamed.decodeStream(bytes.NewReader(xmlData), nil); err != nil {
+			t.Fatalf("decodeStream(%s) failed: %s", f.Name(), err)
+		}
+
+		bj, _ := json.Marshal(bulk)
+		sj, _ := json.Marshal(streamed)
+		if !bytes.Equal(bj, sj) || bulk.XMLName != streamed.XMLName || bulk.PreferenceValue != streamed.PreferenceValue {
+			t.Errorf("decodeStream(%s) differs from LoadFingerprintDB", f.Name())
+		}
+	}
+
+	_, err = LoadFingerprintDBFromReader("other.xml", strings.NewReader(`<other matches="test"></other>`))
+	if err == nil || !strings.Contains(err.Error(), "<fingerprints>") {
+		t.Errorf("LoadFingerprintDBFromReader() should reject an unexpected root element: %v", err)
+	}
+}
+
+func readRecogXML(tb testing.TB, name string) []byte {
+	fd, err := RecogXML.Open(name)
+	if err != nil {
+		tb.Fatalf("failed to open %s: %s", name, err)
+	}
+	defer fd.Close()
+	xmlData, err := ioutil.ReadAll(fd)
+	if err != nil {
+		tb.Fatalf("failed to read %s: %s", name, err)
+	}
+	return xmlData
+}
+
+func benchmarkLoadFingerprintDB(b *testing.B, streamed bool) {
+	xmlData := readRecogXML(b, "snmp_sysdescr.xml")
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		var err error
+		if streamed {
+			_, err = LoadFingerprintDBFromReader("snmp_sysdescr.xml", bytes.NewReader(xmlData))
+		} else {
+			_, err = LoadFingerprintDB("snmp_sysdescr.xml", xmlData)
+		}
+		if err != nil {
+			b.Fatalf("failed to load: %s", err)
+		}
+	}
+}
+
+func BenchmarkLoadFingerprintDBBulk(b *testing.B) {
+	benchmarkLoadFingerprintDB(b, false)
+}
+
+func BenchmarkLoadFingerprintDBStreamed(b *testing.B) {
+	benchmarkLoadFingerprintDB(b, true)
+}
+
+func TestUnusedCaptureGroups(t *testing.T) {
+	fp := &Fingerprint{
+		Pattern: `^Acme (\S+) (\d+) \((\w+)\)(?: (\w+))?$`,
+		Params: []*FingerprintParam{
+			{Position: "1", Name: "service.product"},
+			{Position: "0", Name: "service.edition", Value: "Pro", Require: "4"},
+		},
+	}
+	if err := fp.Normalize(); err != nil {
+		t.Fatalf("Normalize() failed: %s", err)
+	}
+	err := fp.CaptureConsistency()
+	if err == nil || !strings.Contains(err.Error(), "capture group(s) 2, 3 not referenced") {
+		t.Errorf("CaptureConsistency() returned %v, expected groups 2 and 3 to be reported", err)
+	}
+
+	fp.Params = append(fp.Params, &FingerprintParam{Position: "2", Name: "service.version"}, &FingerprintParam{Position: "3", Name: "service.family"})
+	if err := fp.CaptureConsistency(); err != nil {
+		t.Errorf("CaptureConsistency() failed: %s", err)
+	}
+}
+
+func TestMatchAllVerbose(t *testing.T) {
+	xmlData := `<fingerprints matches="test">
+  <fingerprint pattern="^Acme Server v(\d+)">
+    <description>Acme out of range</description>
+    <param pos="1" name="service.version"/>
+    <param pos="2" name="service.edition"/>
+  </fingerprint>
+  <fingerprint pattern="^Acme Server">
+    <description>Acme unresolved</description>
+    <param pos="0" name="service.cpe23" value="cpe:/a:acme:server:{service.version}"/>
+  </fingerprint>
+  <fingerprint pattern="^Acme">
+    <description>Acme</description>
+  </fingerprint>
+  <fingerprint pattern="^Other">
+    <description>Other</description>
+  </fingerprint>
+</fingerprints>`
+	fdb, err := LoadFingerprintDB("test.xml", []byte(xmlData))
+	if err != nil {
+		t.Fatalf("LoadFingerprintDB() failed: %s", err)
+	}
+	fdb.UnresolvedTemplates = RejectUnresolved
+
+	if ms := fdb.MatchAll("Acme Server v2"); len(ms) != 2 {
+		t.Fatalf("MatchAll() returned %d results, expected 2", len(ms))
+	}
+
+	ms := fdb.MatchAllVerbose("Acme Server v2")
+	if len(ms) != 3 {
+		t.Fatalf("MatchAllVerbose() returned %d results, expected 3", len(ms))
+	}
+	expected := []struct {
+		desc    string
+		matched bool
+		errors  bool
+	}{
+		{"Acme out of range", true, true},
+		{"Acme unresolved", false, true},
+		{"Acme", true, false},
+	}
+	for i, tc := range expected {
+		m := ms[i]
+		if m.Fingerprint() == nil || m.Fingerprint().Description.Text != tc.desc {
+			t.Errorf("MatchAllVerbose()[%d] refers to the wrong fingerprint", i)
+			continue
+		}
+		if m.Matched != tc.matched || (len(m.Errors) > 0) != tc.errors {
+			t.Errorf("MatchAllVerbose()[%d] (%s) matched = %v with errors %v", i, tc.desc, m.Matched, m.Errors)
+		}
+		if m.Input != "Acme Server v2" {
+			t.Errorf("MatchAllVerbose()[%d] input = %q", i, m.Input)
+		}
+	}
+	var missing *ErrCaptureMissing
+	if !errors.As(ms[0].Errors[0], &missing) {
+		t.Errorf("MatchAllVerbose()[0] error = %v, expected a missing capture group", ms[0].Errors[0])
+	}
+}
+
+func TestParamMap(t *testing.T) {
+	xmlData := `<fingerprints matches="test">
+  <fingerprint pattern="^Acme model=(\d+) rev=(\w+)$">
+    <description>Acme device</description>
+    <map name="models" default="Unknown Model">
+      <entry key="01" value="Widget"/>
+      <entry key="02" value="Gadget"/>
+    </map>
+    <map name="revisions">
+      <entry key="a" value="Rev A"/>
+    </map>
+    <param pos="1" name="hw.product" map="models"/>
+    <param pos="2" name="hw.version" map="revisions"/>
+    <param pos="0" name="hw.cpe23" value="cpe:/h:acme:{hw.product}"/>
+  </fingerprint>
+</fingerprints>`
+	fdb, err := LoadFingerprintDB("test.xml", []byte(xmlData))
+	if err != nil {
+		t.Fatalf("LoadFingerprintDB() failed: %s", err)
+	}
+	if err := fdb.Fingerprints[0].Validate(); err != nil {
+		t.Fatalf("Validate() failed: %s", err)
+	}
+
+	tests := []struct {
+		data    string
+		product string
+		version string
+	}{
+		{"Acme model=02 rev=a", "Gadget", "Rev A"},
+		{"Acme model=99 rev=b", "Unknown Model", "b"},
+	}
+	for _, tc := range tests {
+		m := fdb.MatchFirst(tc.data)
+		if m.Values["hw.product"] != tc.product || m.Values["hw.version"] != tc.version {
+			t.Errorf("MatchFirst(%q) returned %v", tc.data, m.Values)
+		}
+		if m.Values["hw.cpe23"] != "cpe:/h:acme:"+tc.product {
+			t.Errorf("MatchFirst(%q) hw.cpe23 = %q", tc.data, m.Values["hw.cpe23"])
+		}
+	}
+
+	fp := fdb.Fingerprints[0]
+	fp.Params[1].Map = "missing"
+	if err := fp.Validate(); err == nil || !strings.Contains(err.Error(), "undefined map") {
+		t.Errorf("Validate() returned %v for an undefined map", err)
+	}
+}
+
+func TestMatchedText(t *testing.T) {
+	xmlData := `<fingerprints matches="test">
+  <fingerprint pattern="Acme Server v\d+">
+    <description>Acme without params</description>
+  </fingerprint>
+</fingerprints>`
+	fdb, err := LoadFingerprintDB("test.xml", []byte(xmlData))
+	if err != nil {
+		t.Fatalf("LoadFingerprintDB() failed: %s", err)
+	}
+	m := fdb.MatchFirst("HTTP/1.0 200 OK Acme Server v2 (Linux)")
+	if !m.Matched || m.MatchedText != "Acme Server v2" {
+		t.Errorf("MatchFirst() matched text = %q, expected %q", m.MatchedText, "Acme Server v2")
+	}
+	if m := fdb.MatchFirst("Other"); m.MatchedText != "" {
+		t.Errorf("MatchFirst() set the matched text %q without a match", m.MatchedText)
+	}
+}
+
+func TestExampleBase64(t *testing.T) {
+	// The banner encodes to both + and / in standard base64 and - and _ in URL-safe base64
+	banner := "Acme \xfb\xff\xfe v2"
+	tests := []struct {
+		name     string
+		encoding string
+		data     string
+		err      string
+	}{
+		{"standard", "base64", "QWNtZSD7//4gdjI=", ""},
+		{"standard with whitespace", "base64", "QWNtZSD7\n  //4gdjI=", ""},
+		{"unpadded", "base64", "QWNtZSD7//4gdjI", ""},
+		{"URL-safe", "base64url", "QWNtZSD7__4gdjI=", ""},
+		{"URL-safe unpadded", "base64url", "QWNtZSD7__4gdjI", ""},
+		{"URL-safe as standard", "base64", "QWNtZSD7__4gdjI=", `use _encoding="base64url"`},
+		{"standard as URL-safe", "base64url", "QWNtZSD7//4gdjI=", `use _encoding="base64"`},
+		{"bad padding", "base64", "QWNtZSD7//4gdjI==", "bad padding"},
+		{"bad length", "base64", "QWNtZ", "bad length"},
+	}
+	for _, tc := range tests {
+		fp := &Fingerprint{
+			Pattern: `^Acme .+ v(\d+)$`,
+			Examples: []*FingerprintExample{{
+				Text:   tc.data,
+				Values: []xml.Attr{{Name: xml.Name{Local: "_encoding"}, Value: tc.encoding}, {Name: xml.Name{Local: "service.version"}, Value: "2"}},
+			}},
+			Params: []*FingerprintParam{{Position: "1", Name: "service.version"}},
+		}
+		if err := fp.Normalize(); err != nil {
+			t.Fatalf("Normalize() failed: %s", err)
+		}
+		data, err := fp.exampleData(fp.Examples[0], "")
+		if tc.err == "" {
+			if err != nil || data != banner {
+				t.Errorf("%s: exampleData() = %q, %v", tc.name, data, err)
+			}
+			if err := fp.VerifyExamples(""); err != nil {
+				t.Errorf("%s: VerifyExamples() failed: %s", tc.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tc.err) {
+			t.Errorf("%s: exampleData() returned %v, expected an error containing %q", tc.name, err, tc.err)
+		}
+	}
+}
@@ -7,4 +7,5 @@ require (
 	github.com/shurcooL/vfsgen v0.0.0-20200824052919-0d455de96546
 	github.com/sirupsen/logrus v1.9.3
 	golang.org/x/tools v0.1.0 // indirect
+	gopkg.in/yaml.v3 v3.0.1
 )
@@ -0,0 +1,42 @@
+package recog
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io"
+	"io/ioutil"
+)
+
+// MaxGunzippedSize limits how much of a gzip-compressed input MatchMaybeGzipped decompresses
+var MaxGunzippedSize int64 = 1 << 20
+
+// gzipMagic is the header of gzip-compressed data
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// MatchMaybeGzipped matches data against the database, decompressing it first when it
+// starts with the gzip magic bytes. Truncated or corrupt gzip data, as well as data that
+// decompresses to more than MaxGunzippedSize, is matched as is.
+func MatchMaybeGzipped(fdb *FingerprintDB, data []byte) *FingerprintMatch {
+	if raw, ok := gunzip(data); ok {
+		data = raw
+	}
+	return fdb.MatchFirst(string(data))
+}
+
+// gunzip decompresses gzip data, reporting false when data is not valid gzip
+func gunzip(data []byte) ([]byte, bool) {
+	if !bytes.HasPrefix(data, gzipMagic) {
+		return nil, false
+	}
+	gz, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, false
+	}
+	defer gz.Close()
+
+	raw, err := ioutil.ReadAll(io.LimitReader(gz, MaxGunzippedSize+1))
+	if err != nil || int64(len(raw)) > MaxGunzippedSize {
+		return nil, false
+	}
+	return raw, true
+}
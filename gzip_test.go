@@ -0,0 +1,51 @@
+package recog
+
+import (
+	"bytes"
+	"compress/gzip"
+	"testing"
+)
+
+func TestMatchMaybeGzipped(t *testing.T) {
+	xmlData := `<fingerprints matches="test">
+  <fingerprint pattern="^Acme Server v(\d+)$">
+    <description>Acme</description>
+    <param pos="1" name="service.version"/>
+  </fingerprint>
+</fingerprints>`
+	fdb, err := LoadFingerprintDB("test.xml", []byte(xmlData))
+	if err != nil {
+		t.Fatalf("LoadFingerprintDB() failed: %s", err)
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write([]byte("Acme Server v2")); err != nil {
+		t.Fatalf("failed to compress: %s", err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatalf("failed to compress: %s", err)
+	}
+	compressed := buf.Bytes()
+
+	tests := []struct {
+		name    string
+		data    []byte
+		matched bool
+	}{
+		{"plain", []byte("Acme Server v2"), true},
+		{"gzipped", compressed, true},
+		{"truncated", compressed[:len(compressed)-4], false},
+		{"magic only", []byte{0x1f, 0x8b}, false},
+	}
+	for _, tc := range tests {
+		m := MatchMaybeGzipped(&fdb, tc.data)
+		if m.Matched != tc.matched {
+			t.Errorf("MatchMaybeGzipped(%s) matched = %v, expected %v", tc.name, m.Matched, tc.matched)
+			continue
+		}
+		if tc.matched && m.Values["service.version"] != "2" {
+			t.Errorf("MatchMaybeGzipped(%s) returned %v", tc.name, m.Values)
+		}
+	}
+}
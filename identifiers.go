@@ -0,0 +1,139 @@
+package recog
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// IdentifierSet holds the canonical values of identifier classes, such as the "vendor"
+// and "service_product" reference files maintained by recog_standardize, along with
+// any aliases of those values
+type IdentifierSet struct {
+	// canonical maps each class to the canonical values by their identifierKey
+	canonical map[string]map[string]string
+}
+
+// NewIdentifierSet returns an IdentifierSet holding the canonical values of each class
+func NewIdentifierSet(classes map[string][]string) *IdentifierSet {
+	s := &IdentifierSet{canonical: make(map[string]map[string]string, len(classes))}
+	for class, values := range classes {
+		for _, v := range values {
+			s.add(class, v, v)
+		}
+	}
+	return s
+}
+
+// AddAlias maps an alternate name of an identifier, such as "Apache Software Foundation",
+// to its canonical value, such as "Apache"
+func (s *IdentifierSet) AddAlias(class string, alias string, canonical string) {
+	s.add(class, alias, canonical)
+}
+
+// add maps a value to its canonical form, keeping the first mapping of a value
+func (s *IdentifierSet) add(class string, value string, canonical string) {
+	key := identifierKey(value)
+	if key == "" {
+		return
+	}
+	if s.canonical[class] == nil {
+		s.canonical[class] = make(map[string]string)
+	}
+	if _, ok := s.canonical[class][key]; !ok {
+		s.canonical[class][key] = canonical
+	}
+}
+
+// Canonicalize returns the canonical form of a value of the identifier class. Values
+// are compared without regard to case or repeated whitespace, so "APACHE" and
+// "apache software  foundation" match "Apache" and its alias. It reports false when
+// the value is unknown.
+func (s *IdentifierSet) Canonicalize(class string, value string) (string, bool) {
+	canonical, ok := s.canonical[class][identifierKey(value)]
+	return canonical, ok
+}
+
+// identifierKey folds the case and whitespace of an identifier value
+func identifierKey(value string) string {
+	return strings.ToLower(strings.Join(strings.Fields(value), " "))
+}
+
+// ReadIdentifiers parses an identifier reference file, which lists one canonical value
+// per line. Blank lines are skipped.
+func ReadIdentifiers(r io.Reader) ([]string, error) {
+	var values []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if v := strings.TrimSpace(scanner.Text()); v != "" {
+			values = append(values, v)
+		}
+	}
+	return values, scanner.Err()
+}
+
+// LoadIdentifiers reads the identifier reference files of a directory such as
+// $RECOG_HOME/identifiers. Each <class>.txt file becomes a class holding its sorted,
+// unique values. The result can be passed to NewIdentifierSet.
+func LoadIdentifiers(dir string) (map[string][]string, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return nil, fmt.Errorf("failed to read identifiers: %s", err)
+	}
+	paths, err := filepath.Glob(filepath.Join(dir, "*.txt"))
+	if err != nil {
+		return nil, err
+	}
+
+	classes := make(map[string][]string, len(paths))
+	for _, path := range paths {
+		f, err := os.Open(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read identifiers: %s", err)
+		}
+		values, err := ReadIdentifiers(f)
+		f.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read identifiers %s: %s", path, err)
+		}
+
+		sort.Strings(values)
+		unique := values[:0]
+		for i, v := range values {
+			if i == 0 || v != values[i-1] {
+				unique = append(unique, v)
+			}
+		}
+		classes[strings.TrimSuffix(filepath.Base(path), ".txt")] = unique
+	}
+	return classes, nil
+}
+
+var (
+	identifiersMu sync.RWMutex
+	identifiers   *IdentifierSet
+)
+
+// SetIdentifiers sets the reference data used by CanonicalizeIdentifier
+func SetIdentifiers(s *IdentifierSet) {
+	identifiersMu.Lock()
+	identifiers = s
+	identifiersMu.Unlock()
+}
+
+// CanonicalizeIdentifier returns the canonical form of a value of the identifier class,
+// using the reference data set by SetIdentifiers. It reports false when the value is
+// unknown or no reference data was set.
+func CanonicalizeIdentifier(class string, value string) (string, bool) {
+	identifiersMu.RLock()
+	s := identifiers
+	identifiersMu.RUnlock()
+	if s == nil {
+		return "", false
+	}
+	return s.Canonicalize(class, value)
+}
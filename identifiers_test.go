@@ -0,0 +1,84 @@
+package recog
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestCanonicalizeIdentifier(t *testing.T) {
+	if _, ok := CanonicalizeIdentifier("vendor", "Apache"); ok {
+		t.Errorf("CanonicalizeIdentifier() succeeded without reference data")
+	}
+
+	vendors, err := ReadIdentifiers(strings.NewReader("Apache\nCisco\n\nMicrosoft\n"))
+	if err != nil {
+		t.Fatalf("ReadIdentifiers() failed: %s", err)
+	}
+	if !reflect.DeepEqual(vendors, []string{"Apache", "Cisco", "Microsoft"}) {
+		t.Fatalf("ReadIdentifiers() returned %q", vendors)
+	}
+
+	ids := NewIdentifierSet(map[string][]string{"vendor": vendors, "service_product": {"HTTPD"}})
+	ids.AddAlias("vendor", "Apache Software Foundation", "Apache")
+	SetIdentifiers(ids)
+	defer SetIdentifiers(nil)
+
+	tests := []struct {
+		class     string
+		value     string
+		canonical string
+		ok        bool
+	}{
+		{"vendor", "Apache", "Apache", true},
+		{"vendor", "apache software  foundation", "Apache", true},
+		{"vendor", "CISCO", "Cisco", true},
+		{"vendor", "Juniper", "", false},
+		{"service_product", "httpd", "HTTPD", true},
+		{"os_product", "Apache", "", false},
+	}
+	for _, tc := range tests {
+		canonical, ok := CanonicalizeIdentifier(tc.class, tc.value)
+		if canonical != tc.canonical || ok != tc.ok {
+			t.Errorf("CanonicalizeIdentifier(%q, %q) = %q, %v, expected %q, %v", tc.class, tc.value, canonical, ok, tc.canonical, tc.ok)
+		}
+	}
+}
+
+func TestLoadIdentifiers(t *testing.T) {
+	dir, err := ioutil.TempDir("", "identifiers")
+	if err != nil {
+		t.Fatalf("failed to create temp dir: %s", err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"vendor.txt":     "Microsoft\nApache\nCisco\nApache\n",
+		"os_product.txt": "Windows\n\nIOS\n",
+		"README.md":      "not an identifier file\n",
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatalf("failed to write %s: %s", name, err)
+		}
+	}
+
+	classes, err := LoadIdentifiers(dir)
+	if err != nil {
+		t.Fatalf("LoadIdentifiers() failed: %s", err)
+	}
+	expected := map[string][]string{
+		"vendor":     {"Apache", "Cisco", "Microsoft"},
+		"os_product": {"IOS", "Windows"},
+	}
+	if !reflect.DeepEqual(classes, expected) {
+		t.Errorf("LoadIdentifiers() = %q, expected %q", classes, expected)
+	}
+
+	if _, err := LoadIdentifiers(filepath.Join(dir, "missing")); err == nil {
+		t.Errorf("LoadIdentifiers() should fail for a missing directory")
+	}
+}
@@ -0,0 +1,82 @@
+package recog
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"sort"
+)
+
+// fingerprintExampleJSON is the JSON representation of a FingerprintExample
+type fingerprintExampleJSON struct {
+	Text  string            `json:"text,omitempty"`
+	Attrs map[string]string `json:"attrs,omitempty"`
+}
+
+// MarshalJSON encodes an example with its attributes as a JSON object
+func (ex *FingerprintExample) MarshalJSON() ([]byte, error) {
+	res := fingerprintExampleJSON{Text: ex.Text}
+	if len(ex.Values) > 0 {
+		res.Attrs = make(map[string]string, len(ex.Values))
+		for _, attr := range ex.Values {
+			res.Attrs[attr.Name.Local] = attr.Value
+		}
+	}
+	return json.Marshal(res)
+}
+
+// UnmarshalJSON decodes an example, storing the attributes in name order
+func (ex *FingerprintExample) UnmarshalJSON(data []byte) error {
+	var res fingerprintExampleJSON
+	if err := json.Unmarshal(data, &res); err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(res.Attrs))
+	for name := range res.Attrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	ex.Text = res.Text
+	ex.Values = nil
+	ex.AttributeMap = make(map[string]string, len(names))
+	for _, name := range names {
+		ex.Values = append(ex.Values, xml.Attr{Name: xml.Name{Local: name}, Value: res.Attrs[name]})
+		ex.AttributeMap[name] = res.Attrs[name]
+	}
+	return nil
+}
+
+// fingerprintJSON avoids recursion when decoding a Fingerprint
+type fingerprintJSON Fingerprint
+
+// UnmarshalJSON decodes a fingerprint and normalizes it, compiling the pattern
+func (fp *Fingerprint) UnmarshalJSON(data []byte) error {
+	if err := json.Unmarshal(data, (*fingerprintJSON)(fp)); err != nil {
+		return err
+	}
+	return fp.Normalize()
+}
+
+// fingerprintDBJSON decodes a FingerprintDB without recursing into its UnmarshalJSON.
+// The shadowing Fingerprints field defers normalizing the fingerprints to the database.
+type fingerprintDBJSON struct {
+	*fingerprintDBAlias
+	Fingerprints []*fingerprintJSON `json:"fingerprint,omitempty"`
+}
+
+type fingerprintDBAlias FingerprintDB
+
+// UnmarshalJSON decodes a database and normalizes it like the XML loader, applying
+// options such as LazyCompile and Anchored set on fdb beforehand
+func (fdb *FingerprintDB) UnmarshalJSON(data []byte) error {
+	res := fingerprintDBJSON{fingerprintDBAlias: (*fingerprintDBAlias)(fdb)}
+	if err := json.Unmarshal(data, &res); err != nil {
+		return err
+	}
+	fdb.Fingerprints = make([]*Fingerprint, 0, len(res.Fingerprints))
+	for _, fp := range res.Fingerprints {
+		fdb.Fingerprints = append(fdb.Fingerprints, (*Fingerprint)(fp))
+	}
+	return fdb.Normalize()
+}
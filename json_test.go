@@ -0,0 +1,143 @@
+package recog
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestJSONRoundTrip(t *testing.T) {
+	xmlData := `<fingerprints matches="test" protocol="test" database_type="service" preference="0.50">
+  <fingerprint pattern="^Acme Server v([\d.]+)" flags="REG_ICASE" certainty="0.9">
+    <description>Acme server</description>
+    <example service.version="1.2">Acme Server v1.2</example>
+    <example _encoding="base64" service.version="2.0">QWNtZSBTZXJ2ZXIgdjIuMA==</example>
+    <param pos="0" name="service.product" value="Acme"/>
+    <param pos="1" name="service.version"/>
+  </fingerprint>
+</fingerprints>`
+	fdb, err := LoadFingerprintDB("test.xml", []byte(xmlData))
+	if err != nil {
+		t.Fatalf("LoadFingerprintDB() failed: %s", err)
+	}
+
+	jsonData, err := json.Marshal(&fdb)
+	if err != nil {
+		t.Fatalf("MarshalJSON() failed: %s", err)
+	}
+	for _, field := range []string{`"matches":"test"`, `"preference":"0.50"`, `"flags":"REG_ICASE"`, `"attrs":{"service.version":"1.2"}`} {
+		if !strings.Contains(string(jsonData), field) {
+			t.Errorf("MarshalJSON() output is missing %s: %s", field, jsonData)
+		}
+	}
+
+	var decoded FingerprintDB
+	if err := json.Unmarshal(jsonData, &decoded); err != nil {
+		t.Fatalf("UnmarshalJSON() failed: %s", err)
+	}
+	if decoded.Matches != fdb.Matches || decoded.PreferenceValue != fdb.PreferenceValue || len(decoded.Fingerprints) != 1 {
+		t.Fatalf("UnmarshalJSON() returned a different database: %#v", decoded)
+	}
+	fp := decoded.Fingerprints[0]
+	if fp.PatternCompiled == nil {
+		t.Fatalf("UnmarshalJSON() did not compile the pattern")
+	}
+	if !reflect.DeepEqual(fp.Examples[1].AttributeMap, fdb.Fingerprints[0].Examples[1].AttributeMap) {
+		t.Errorf("UnmarshalJSON() example attributes differ: %v", fp.Examples[1].AttributeMap)
+	}
+
+	again, err := json.Marshal(&decoded)
+	if err != nil {
+		t.Fatalf("MarshalJSON() failed: %s", err)
+	}
+	if string(again) != string(jsonData) {
+		t.Errorf("JSON encoding is not stable:\n%s\n%s", jsonData, again)
+	}
+}
+
+func TestJSONLoadMatches(t *testing.T) {
+	fset := loadTestDatabases(t)
+	data := "Apache/2.4.6 (Red Hat Enterprise Linux)"
+	expected := fset.MatchFirst("http_servers.xml", data)
+	if !expected.Matched {
+		t.Fatalf("failed to match %q", data)
+	}
+
+	jsonData, err := json.Marshal(fset.Databases["http_servers.xml"])
+	if err != nil {
+		t.Fatalf("MarshalJSON() failed: %s", err)
+	}
+
+	var fdb FingerprintDB
+	if err := json.Unmarshal(jsonData, &fdb); err != nil {
+		t.Fatalf("UnmarshalJSON() failed: %s", err)
+	}
+	m := fdb.MatchFirst(data)
+	if m.Matched != expected.Matched || !reflect.DeepEqual(m.Values, expected.Values) {
+		t.Errorf("JSON loaded database returned a different match: %#v != %#v", m, expected)
+	}
+	if err := fdb.VerifyExamples("."); err != nil {
+		t.Errorf("VerifyExamples() failed for JSON loaded database: %s", err)
+	}
+
+	var fp Fingerprint
+	fpData, err := json.Marshal(fdb.Fingerprints[0])
+	if err != nil {
+		t.Fatalf("MarshalJSON() failed: %s", err)
+	}
+	if err := json.Unmarshal(fpData, &fp); err != nil {
+		t.Fatalf("UnmarshalJSON() failed: %s", err)
+	}
+	if fp.PatternCompiled == nil || fp.PatternCompiled.String() != fdb.Fingerprints[0].PatternCompiled.String() {
+		t.Errorf("UnmarshalJSON() did not compile the fingerprint pattern")
+	}
+}
+
+func TestJSONLoadMatchesXML(t *testing.T) {
+	xmlData := `<fingerprints matches="test" protocol="test" preference="0.75">
+  <fingerprint pattern="Acme Server v([\d.]+)">
+    <description>Acme server</description>
+    <example service.version="1.2">Acme Server v1.2</example>
+    <param pos="0" name="service.product" value="Acme"/>
+    <param pos="1" name="service.version"/>
+  </fingerprint>
+  <fingerprint pattern="^Other">
+    <description>Other server</description>
+    <example>Other</example>
+  </fingerprint>
+</fingerprints>`
+	xdb, err := loadFingerprintDB("test.xml", []byte(xmlData), true)
+	if err != nil {
+		t.Fatalf("loadFingerprintDB() failed: %s", err)
+	}
+	jsonData, err := json.Marshal(&xdb)
+	if err != nil {
+		t.Fatalf("MarshalJSON() failed: %s", err)
+	}
+	jdb := FingerprintDB{LazyCompile: true}
+	if err := json.Unmarshal(jsonData, &jdb); err != nil {
+		t.Fatalf("UnmarshalJSON() failed: %s", err)
+	}
+
+	if jdb.PreferenceValue != xdb.PreferenceValue {
+		t.Errorf("JSON preference = %f, XML preference = %f", jdb.PreferenceValue, xdb.PreferenceValue)
+	}
+	if jdb.Fingerprints[0].PatternCompiled != nil || jdb.Fingerprints[0].compileOnce == nil {
+		t.Errorf("UnmarshalJSON() ignored LazyCompile")
+	}
+	if fp, ok := jdb.FindByDescription("Other server"); !ok || fp != jdb.Fingerprints[1] {
+		t.Errorf("UnmarshalJSON() did not index the fingerprints")
+	}
+	for _, data := range []string{"Acme Server v1.2", "xx Acme Server v3", "Other", "None"} {
+		jm, xm := jdb.MatchAll(data), xdb.MatchAll(data)
+		if len(jm) != len(xm) {
+			t.Fatalf("MatchAll(%q) returned %d JSON and %d XML matches", data, len(jm), len(xm))
+		}
+		for i := range jm {
+			if jm[i].Matched != xm[i].Matched || !reflect.DeepEqual(jm[i].Values, xm[i].Values) {
+				t.Errorf("MatchAll(%q) differs: %#v != %#v", data, jm[i], xm[i])
+			}
+		}
+	}
+}
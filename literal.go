@@ -0,0 +1,184 @@
+package recog
+
+import (
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// QuoteMeta returns a pattern matching the literal text s, such as a banner fragment,
+// for use in a fingerprint. Unlike regexp.QuoteMeta, whitespace and # are escaped as
+// well so the pattern also matches the literal in extended mode (REG_EXTENDED).
+func QuoteMeta(s string) string {
+	quoted := regexp.QuoteMeta(s)
+
+	var b strings.Builder
+	for i := 0; i < len(quoted); i++ {
+		switch c := quoted[i]; c {
+		case ' ', '\t', '\n', '\r', '\f', '\v':
+			fmt.Fprintf(&b, `\x%02x`, c)
+		case '#':
+			b.WriteString(`\#`)
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// patternLiteral is a run of literal text required by a pattern
+type patternLiteral struct {
+	text string
+	fold bool
+}
+
+// literals returns the runs of literal text in the patterns of the fingerprint
+func (fp *Fingerprint) literals() []patternLiteral {
+	if err := fp.ensureCompiled(); err != nil {
+		return nil
+	}
+	matchers := fp.matchers
+	if matchers == nil {
+		matchers = []*regexp.Regexp{fp.PatternCompiled}
+	}
+
+	ret := []patternLiteral{}
+	for _, re := range matchers {
+		// The expression was generated from a parsed pattern and holds all of its flags
+		parsed, err := syntax.Parse(re.String(), syntax.Perl)
+		if err != nil {
+			continue
+		}
+		ret = appendLiterals(ret, parsed.Simplify())
+	}
+	return ret
+}
+
+// appendLiterals appends the literal runs of a parsed pattern, joining adjacent
+// literals of a concatenation
+func appendLiterals(lits []patternLiteral, re *syntax.Regexp) []patternLiteral {
+	switch re.Op {
+	case syntax.OpLiteral:
+		return append(lits, patternLiteral{text: string(re.Rune), fold: re.Flags&syntax.FoldCase != 0})
+	case syntax.OpConcat:
+		var run *patternLiteral
+		for _, sub := range re.Sub {
+			if sub.Op != syntax.OpLiteral {
+				run = nil
+				lits = appendLiterals(lits, sub)
+				continue
+			}
+			fold := sub.Flags&syntax.FoldCase != 0
+			if run != nil && run.fold == fold {
+				run.text += string(sub.Rune)
+				continue
+			}
+			lits = append(lits, patternLiteral{text: string(sub.Rune), fold: fold})
+			run = &lits[len(lits)-1]
+		}
+		return lits
+	}
+	for _, sub := range re.Sub {
+		lits = appendLiterals(lits, sub)
+	}
+	return lits
+}
+
+// MatchLiteral returns the fingerprints with a pattern containing substr as literal
+// text, ignoring case for case-insensitive parts of the patterns. This finds the
+// fingerprints written for a banner fragment without needing a full banner.
+func (fdb *FingerprintDB) MatchLiteral(substr string) []*Fingerprint {
+	ret := []*Fingerprint{}
+	for _, fp := range fdb.Fingerprints {
+		for _, lit := range fp.literals() {
+			if strings.Contains(lit.text, substr) || (lit.fold && strings.Contains(strings.ToLower(lit.text), strings.ToLower(substr))) {
+				ret = append(ret, fp)
+				break
+			}
+		}
+	}
+	return ret
+}
+
+// MinInputLength returns the minimum length in bytes of input that the patterns of
+// the fingerprint can match. Shorter input is skipped without running the patterns.
+func (fp *Fingerprint) MinInputLength() int {
+	if err := fp.ensureCompiled(); err != nil {
+		return 0
+	}
+	return fp.minLength
+}
+
+// minLength returns the minimum length in bytes of input matched by a parsed pattern
+func minLength(re *syntax.Regexp) int {
+	switch re.Op {
+	case syntax.OpLiteral:
+		n := 0
+		for _, r := range re.Rune {
+			n += minRuneLength(r, re.Flags&syntax.FoldCase != 0)
+		}
+		return n
+	case syntax.OpCharClass:
+		if len(re.Rune) == 0 {
+			return 0
+		}
+		n := utf8.UTFMax
+		for i := 0; i < len(re.Rune); i += 2 {
+			// A class containing U+FFFD matches any single invalid byte
+			if re.Rune[i] <= utf8.RuneError && utf8.RuneError <= re.Rune[i+1] {
+				return 1
+			}
+			if l := minRuneLength(re.Rune[i], false); l < n {
+				n = l
+			}
+		}
+		return n
+	case syntax.OpAnyChar, syntax.OpAnyCharNotNL:
+		return 1
+	case syntax.OpCapture, syntax.OpPlus:
+		return minLength(re.Sub[0])
+	case syntax.OpRepeat:
+		return re.Min * minLength(re.Sub[0])
+	case syntax.OpConcat:
+		n := 0
+		for _, sub := range re.Sub {
+			n += minLength(sub)
+		}
+		return n
+	case syntax.OpAlternate:
+		n := -1
+		for _, sub := range re.Sub {
+			if l := minLength(sub); n < 0 || l < n {
+				n = l
+			}
+		}
+		if n < 0 {
+			return 0
+		}
+		return n
+	}
+	// Empty-width assertions, optional repeats, and empty or impossible matches
+	return 0
+}
+
+// minRuneLength returns the length in bytes of r, or of the shortest rune that
+// r folds to when fold is set
+func minRuneLength(r rune, fold bool) int {
+	n := utf8.RuneLen(r)
+	if n < 0 || r == utf8.RuneError {
+		// Invalid runes, and U+FFFD which matches an invalid byte, match a single byte
+		return 1
+	}
+	if !fold {
+		return n
+	}
+	for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+		if l := utf8.RuneLen(f); l < n {
+			n = l
+		}
+	}
+	return n
+}
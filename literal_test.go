@@ -0,0 +1,120 @@
+package recog
+
+import (
+	"regexp"
+	"testing"
+)
+
+func TestQuoteMeta(t *testing.T) {
+	literal := "Acme # Server (v1.2) [beta]\r\n"
+	for _, flags := range []string{"", "REG_EXTENDED"} {
+		fp := &Fingerprint{Pattern: "^" + QuoteMeta(literal) + "$", Flags: flags}
+		if err := fp.Normalize(); err != nil {
+			t.Fatalf("Normalize() failed for %s: %s", flags, err)
+		}
+		if m := fp.Match(literal); !m.Matched {
+			t.Errorf("pattern %q with flags %q does not match the literal", fp.Pattern, flags)
+		}
+		if m := fp.Match("Acme  Server (v1.2) [beta]\r\n"); m.Matched {
+			t.Errorf("pattern %q with flags %q matches other text", fp.Pattern, flags)
+		}
+	}
+	if _, err := regexp.Compile(QuoteMeta(literal)); err != nil {
+		t.Errorf("QuoteMeta() is not a valid regexp: %s", err)
+	}
+}
+
+func TestMatchLiteral(t *testing.T) {
+	xmlData := `<fingerprints matches="test">
+  <fingerprint pattern="^Acme Server v(\d+)$">
+    <description>Acme server</description>
+  </fingerprint>
+  <fingerprint pattern="^(?:Widget|Acme) Gateway" flags="REG_ICASE">
+    <description>Acme gateway</description>
+  </fingerprint>
+  <fingerprint pattern="^Acme.*Server">
+    <description>Acme other</description>
+  </fingerprint>
+</fingerprints>`
+	fdb, err := LoadFingerprintDB("test.xml", []byte(xmlData))
+	if err != nil {
+		t.Fatalf("LoadFingerprintDB() failed: %s", err)
+	}
+
+	tests := []struct {
+		literal  string
+		expected []string
+	}{
+		{"Acme Server", []string{"Acme server"}},
+		{"gateway", []string{"Acme gateway"}},
+		{"acme server", []string{}},
+		{"Server", []string{"Acme server", "Acme other"}},
+		{"Server v2", []string{}},
+	}
+	for _, tc := range tests {
+		fps := fdb.MatchLiteral(tc.literal)
+		descs := []string{}
+		for _, fp := range fps {
+			descs = append(descs, fp.Description.Text)
+		}
+		if len(descs) != len(tc.expected) {
+			t.Errorf("MatchLiteral(%q) = %v, expected %v", tc.literal, descs, tc.expected)
+			continue
+		}
+		for i := range descs {
+			if descs[i] != tc.expected[i] {
+				t.Errorf("MatchLiteral(%q) = %v, expected %v", tc.literal, descs, tc.expected)
+				break
+			}
+		}
+	}
+}
+
+func TestMinInputLength(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		flags    string
+		patterns []string
+		expected int
+	}{
+		{pattern: "^Acme$", expected: 4},
+		{pattern: `^Acme Server v(\d+)\.(\d+)`, expected: 16},
+		{pattern: `^Acme(?: Server)? v\d{2,4}`, expected: 8},
+		{pattern: `^(?:Acme|Widget|Go)/(.*)$`, expected: 3},
+		{pattern: "^café", expected: 5},
+		{pattern: `.*`, expected: 0},
+		{pattern: "^Acme Server", patterns: []string{"^Acme"}, expected: 4},
+		{pattern: "^k", flags: "REG_ICASE", expected: 1},
+		{pattern: `^\x{FFFD}`, expected: 1},
+		{pattern: `^[\x{FFFD}-\x{10FFFF}]$`, expected: 1},
+	}
+	for _, tc := range tests {
+		fp := &Fingerprint{Pattern: tc.pattern, Flags: tc.flags, Patterns: tc.patterns}
+		if err := fp.Normalize(); err != nil {
+			t.Fatalf("Normalize() failed for %s: %s", tc.pattern, err)
+		}
+		if got := fp.MinInputLength(); got != tc.expected {
+			t.Errorf("MinInputLength() of %s = %d, expected %d", tc.pattern, got, tc.expected)
+		}
+	}
+
+	// Input shorter than the minimum is skipped, longer input is matched
+	fp := &Fingerprint{Pattern: `Acme v\d`}
+	if err := fp.Normalize(); err != nil {
+		t.Fatalf("Normalize() failed: %s", err)
+	}
+	if fp.Match("Acme").Matched || !fp.Match("Acme v1").Matched {
+		t.Errorf("Match() does not respect the minimum input length")
+	}
+
+	// The regexp package matches an invalid UTF-8 byte as U+FFFD
+	for _, pattern := range []string{`^[\x{FFFD}-\x{10FFFF}]$`, `^\x{FFFD}$`} {
+		fp := &Fingerprint{Pattern: pattern}
+		if err := fp.Normalize(); err != nil {
+			t.Fatalf("Normalize() failed: %s", err)
+		}
+		if !fp.PatternCompiled.MatchString("\xff") || !fp.Match("\xff").Matched {
+			t.Errorf("Match() of %s should match an invalid UTF-8 byte", pattern)
+		}
+	}
+}
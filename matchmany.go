@@ -0,0 +1,131 @@
+package recog
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// MatchMany matches each input, such as the banners of one service on several ports,
+// with MatchFirst and merges the matches into one result. The values of the match with
+// the highest certainty win; ties go to the earlier input. A different value of the same
+// key from a less certain match is reported in Errors and not used. CPE values (*.cpe23)
+// do not conflict, every distinct CPE is kept in MultiValues, most certain first.
+// The certainty of the result aggregates the matches as independent evidence, 1-∏(1-c)
+// over the certainty c of each distinct matching input, so two matches of certainty 0.5
+// give 0.75. Input, MatchedText, and Fingerprint refer to the most certain match. The
+// result is unmatched when no input matches.
+func (fdb *FingerprintDB) MatchMany(inputs []string) *FingerprintMatch {
+	type certainMatch struct {
+		certainty float64
+		match     *FingerprintMatch
+	}
+
+	ranked := []certainMatch{}
+	nomatch := &FingerprintMatch{Matched: false}
+	seen := make(map[string]bool)
+	for _, input := range inputs {
+		if seen[input] {
+			continue
+		}
+		seen[input] = true
+		m := fdb.MatchFirst(input)
+		if !m.Matched {
+			nomatch.Errors = append(nomatch.Errors, m.Errors...)
+			continue
+		}
+		certainty, err := strconv.ParseFloat(m.Values[CertaintyKey], 64)
+		if err != nil {
+			fdb.DebugLogf("invalid certainty %q: %s", m.Values[CertaintyKey], err)
+		}
+		ranked = append(ranked, certainMatch{certainty: certainty, match: m})
+	}
+	if len(ranked) == 0 {
+		return nomatch
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return ranked[i].certainty > ranked[j].certainty
+	})
+
+	best := ranked[0].match
+	res := &FingerprintMatch{Matched: true, Values: make(map[string]string), Input: best.Input, MatchedText: best.MatchedText, fingerprint: best.fingerprint}
+	uncertainty := 1.0
+	for _, rm := range ranked {
+		m := rm.match
+		uncertainty *= 1 - math.Max(0, math.Min(1, rm.certainty))
+		res.Errors = append(res.Errors, m.Errors...)
+
+		keys := make([]string, 0, len(m.Values))
+		for k := range m.Values {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			v := m.Values[k]
+			if strings.HasSuffix(k, ".cpe23") {
+				values, ok := m.MultiValues[k]
+				if !ok {
+					values = []string{v}
+				}
+				res.addCPEs(k, values)
+				continue
+			}
+			current, ok := res.Values[k]
+			if !ok {
+				res.Values[k] = v
+				if values, ok := m.MultiValues[k]; ok {
+					if res.MultiValues == nil {
+						res.MultiValues = make(map[string][]string)
+					}
+					res.MultiValues[k] = values
+				}
+				continue
+			}
+			// The library values always differ between fingerprints
+			if v != current && k != MatchedKey && k != legacyMatchedKey && k != CertaintyKey && k != legacyCertaintyKey {
+				res.Errors = append(res.Errors, fmt.Errorf("%s %q from %q conflicts with %q", k, v, m.Input, current))
+			}
+		}
+	}
+
+	certainty := strconv.FormatFloat(math.Round((1-uncertainty)*1e6)/1e6, 'f', -1, 64)
+	res.Values[CertaintyKey] = certainty
+	if _, ok := res.Values[legacyCertaintyKey]; ok {
+		res.Values[legacyCertaintyKey] = certainty
+	}
+	return res
+}
+
+// addCPEs adds the distinct CPE values of a key, keeping Values set to the first value
+func (m *FingerprintMatch) addCPEs(k string, values []string) {
+	existing, ok := m.MultiValues[k]
+	if !ok {
+		if v, ok := m.Values[k]; ok {
+			existing = []string{v}
+		}
+	}
+	for _, v := range values {
+		dup := false
+		for _, e := range existing {
+			if e == v {
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			existing = append(existing, v)
+		}
+	}
+	if len(existing) == 0 {
+		return
+	}
+	m.Values[k] = existing[0]
+	if len(existing) > 1 {
+		if m.MultiValues == nil {
+			m.MultiValues = make(map[string][]string)
+		}
+		m.MultiValues[k] = existing
+	}
+}
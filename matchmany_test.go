@@ -0,0 +1,98 @@
+package recog
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestMatchMany(t *testing.T) {
+	xmlData := `<fingerprints matches="test">
+  <fingerprint pattern="^Acme Server v(\d+) \((\w+)\)$" certainty="1.0">
+    <description>Acme with OS</description>
+    <param pos="0" name="service.product" value="Server"/>
+    <param pos="1" name="service.version"/>
+    <param pos="2" name="os.product"/>
+    <param pos="0" name="service.cpe23" value="cpe:/a:acme:server:{service.version}"/>
+  </fingerprint>
+  <fingerprint pattern="^Acme Server v(\d+)$">
+    <description>Acme</description>
+    <param pos="0" name="service.product" value="Server"/>
+    <param pos="0" name="service.vendor" value="Acme"/>
+    <param pos="1" name="service.version"/>
+    <param pos="0" name="service.cpe23" value="cpe:/a:acme:server:{service.version}"/>
+  </fingerprint>
+</fingerprints>`
+	fdb, err := LoadFingerprintDB("test.xml", []byte(xmlData))
+	if err != nil {
+		t.Fatalf("LoadFingerprintDB() failed: %s", err)
+	}
+
+	m := fdb.MatchMany([]string{"Acme Server v3", "Unknown", "Acme Server v2 (Linux)"})
+	if !m.Matched {
+		t.Fatalf("MatchMany() failed to match")
+	}
+	expected := map[string]string{
+		"service.product": "Server",
+		"service.vendor":  "Acme",
+		"service.version": "2",
+		"os.product":      "Linux",
+		"service.cpe23":   "cpe:/a:acme:server:2",
+		CertaintyKey:      "1",
+		MatchedKey:        "Acme with OS",
+	}
+	for k, v := range expected {
+		if m.Values[k] != v {
+			t.Errorf("MatchMany() %s = %q, expected %q", k, m.Values[k], v)
+		}
+	}
+	if cpes := m.MultiValues["service.cpe23"]; !reflect.DeepEqual(cpes, []string{"cpe:/a:acme:server:2", "cpe:/a:acme:server:3"}) {
+		t.Errorf("MatchMany() CPEs = %q", cpes)
+	}
+	if m.Input != "Acme Server v2 (Linux)" || m.Fingerprint() == nil || m.Fingerprint().Description.Text != "Acme with OS" {
+		t.Errorf("MatchMany() does not refer to the most certain match")
+	}
+	if len(m.Errors) != 1 {
+		t.Errorf("MatchMany() returned errors %v, expected the service.version conflict", m.Errors)
+	}
+
+	if m := fdb.MatchMany([]string{"Unknown"}); m.Matched {
+		t.Errorf("MatchMany() matched unknown input")
+	}
+}
+
+func TestMatchManyCertainty(t *testing.T) {
+	xmlData := `<fingerprints matches="test">
+  <fingerprint pattern="^Acme Server v(\d+)$" certainty="0.8">
+    <description>Acme</description>
+    <param pos="0" name="service.product" value="Server"/>
+    <param pos="1" name="service.version"/>
+  </fingerprint>
+  <fingerprint pattern="^Acme$" certainty="0.5">
+    <description>Acme without a version</description>
+    <param pos="0" name="service.product" value="Server"/>
+  </fingerprint>
+</fingerprints>`
+	fdb, err := LoadFingerprintDB("test.xml", []byte(xmlData))
+	if err != nil {
+		t.Fatalf("LoadFingerprintDB() failed: %s", err)
+	}
+
+	tests := []struct {
+		inputs    []string
+		certainty string
+		input     string
+	}{
+		{[]string{"Acme Server v2"}, "0.8", "Acme Server v2"},
+		{[]string{"Acme", "Acme Server v2"}, "0.9", "Acme Server v2"},
+		{[]string{"Acme", "Acme"}, "0.5", "Acme"},
+	}
+	for _, tc := range tests {
+		m := fdb.MatchMany(tc.inputs)
+		if m.Values[CertaintyKey] != tc.certainty || m.Values[legacyCertaintyKey] != tc.certainty {
+			t.Errorf("MatchMany(%q) certainty = %q, expected %q", tc.inputs, m.Values[CertaintyKey], tc.certainty)
+		}
+		if m.Input != tc.input {
+			t.Errorf("MatchMany(%q) refers to %q, expected the most certain match %q", tc.inputs, m.Input, tc.input)
+		}
+	}
+}
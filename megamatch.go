@@ -0,0 +1,199 @@
+package recog
+
+import (
+	"fmt"
+	"regexp/syntax"
+	"strings"
+	"unicode/utf8"
+)
+
+// megaMatcher holds the literal text required by the patterns of every fingerprint
+// of a database, so MatchFirstFast can rule out fingerprints without running their
+// patterns
+type megaMatcher struct {
+	// fingerprints is the database order the matcher was built for
+	fingerprints []*Fingerprint
+	// required holds the requirement of each pattern of each fingerprint. A
+	// fingerprint without requirements is always tried.
+	required [][]requiredLiteral
+}
+
+// requiredLiteral is the literal text any input matched by a pattern holds
+type requiredLiteral struct {
+	// prefix starts the input
+	prefix string
+	// text appears anywhere in the input
+	text string
+}
+
+// BuildMegaMatcher indexes the literal text required by the patterns of every
+// fingerprint for MatchFirstFast. A fingerprint is only tried when the input starts
+// with the literal prefix of one of its anchored patterns, or holds the longest
+// literal run that one of its patterns requires. Case-insensitive literals are not
+// indexed, and fingerprints with the REG_LINE_ANY_CRLF flag or without literal text
+// are always tried. The matcher must be built again after the fingerprints change,
+// Normalize discards it.
+//
+// The patterns are not combined into a single alternation: the regexp package has
+// no DFA, so such a regexp simulates every alternative at once and loses the literal
+// prefix optimizations of the individual patterns, which made it several times
+// slower than MatchFirst. See BenchmarkMatchFirstFast.
+func (fdb *FingerprintDB) BuildMegaMatcher() error {
+	fdb.mega = nil
+
+	mega := &megaMatcher{
+		fingerprints: append([]*Fingerprint{}, fdb.Fingerprints...),
+		required:     make([][]requiredLiteral, len(fdb.Fingerprints)),
+	}
+	for i, fp := range fdb.Fingerprints {
+		if err := fp.ensureCompiled(); err != nil {
+			return err
+		}
+		// Input with CR line breaks is matched as normalized to LF
+		if fp.anyCRLF || fp.matchers == nil {
+			continue
+		}
+
+		required := make([]requiredLiteral, 0, len(fp.matchers))
+		for _, re := range fp.matchers {
+			// The expression was generated from a parsed pattern and holds all of its flags
+			parsed, err := syntax.Parse(re.String(), syntax.Perl)
+			if err != nil {
+				return fmt.Errorf("failed to index [%s]: %s", fp.Pattern, err)
+			}
+			req := requirement(parsed.Simplify())
+			if req.prefix == "" && req.text == "" {
+				required = nil
+				break
+			}
+			required = append(required, req)
+		}
+		mega.required[i] = required
+	}
+	fdb.mega = mega
+	return nil
+}
+
+// requirement returns the literal text required by a parsed pattern
+func requirement(re *syntax.Regexp) requiredLiteral {
+	req := requiredLiteral{}
+	if re.Op == syntax.OpConcat {
+		subs := re.Sub
+		anchored := false
+		for len(subs) > 0 && subs[0].Op == syntax.OpBeginText {
+			subs, anchored = subs[1:], true
+		}
+		if anchored {
+			req.prefix = literalPrefix(subs)
+		}
+	}
+	// Text held by the prefix adds nothing to it
+	for _, text := range appendRequired(nil, re) {
+		if len(text) > len(req.text) && !strings.Contains(req.prefix, text) {
+			req.text = text
+		}
+	}
+	return req
+}
+
+// indexable reports whether a literal rune matches itself in the input.
+// utf8.RuneError also matches each byte of invalid UTF-8.
+func indexable(lit *syntax.Regexp, r rune) bool {
+	return lit.Flags&syntax.FoldCase == 0 && r != utf8.RuneError
+}
+
+// literalPrefix returns the literal text at the start of a concatenation
+func literalPrefix(subs []*syntax.Regexp) string {
+	var b strings.Builder
+	for _, sub := range subs {
+		if sub.Op != syntax.OpLiteral {
+			break
+		}
+		for _, r := range sub.Rune {
+			if !indexable(sub, r) {
+				return b.String()
+			}
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// appendRequired appends the runs of literal text that any input matched by a
+// parsed pattern holds
+func appendRequired(runs []string, re *syntax.Regexp) []string {
+	switch re.Op {
+	case syntax.OpLiteral, syntax.OpConcat:
+		subs := re.Sub
+		if re.Op == syntax.OpLiteral {
+			subs = []*syntax.Regexp{re}
+		}
+		var b strings.Builder
+		flush := func() {
+			if b.Len() > 0 {
+				runs = append(runs, b.String())
+				b.Reset()
+			}
+		}
+		for _, sub := range subs {
+			if sub.Op != syntax.OpLiteral {
+				flush()
+				runs = appendRequired(runs, sub)
+				continue
+			}
+			for _, r := range sub.Rune {
+				if !indexable(sub, r) {
+					flush()
+					continue
+				}
+				b.WriteRune(r)
+			}
+		}
+		flush()
+	case syntax.OpCapture, syntax.OpPlus:
+		return appendRequired(runs, re.Sub[0])
+	case syntax.OpRepeat:
+		if re.Min > 0 {
+			return appendRequired(runs, re.Sub[0])
+		}
+	}
+	return runs
+}
+
+// try reports whether the fingerprint at index i may match the input
+func (mega *megaMatcher) try(i int, data string) bool {
+	required := mega.required[i]
+	if required == nil {
+		return true
+	}
+	for _, req := range required {
+		if strings.HasPrefix(data, req.prefix) && strings.Contains(data, req.text) {
+			return true
+		}
+	}
+	return false
+}
+
+// current reports whether the matcher was built for the current fingerprints
+func (mega *megaMatcher) current(fps []*Fingerprint) bool {
+	if len(fps) != len(mega.fingerprints) {
+		return false
+	}
+	for i := range fps {
+		if fps[i] != mega.fingerprints[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// MatchFirstFast finds the first match for a given string like MatchFirst, skipping
+// the fingerprints that BuildMegaMatcher found cannot match the input. It falls back
+// to MatchFirst when the matcher was not built or the fingerprints changed since.
+func (fdb *FingerprintDB) MatchFirstFast(data string) *FingerprintMatch {
+	mega := fdb.mega
+	if mega == nil || !mega.current(fdb.Fingerprints) {
+		return fdb.MatchFirst(data)
+	}
+	return fdb.matchFirst(data, mega.try, fdb.match)
+}
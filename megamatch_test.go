@@ -0,0 +1,154 @@
+package recog
+
+import (
+	"regexp/syntax"
+	"testing"
+)
+
+func TestMatchFirstFast(t *testing.T) {
+	xmlData := `<fingerprints matches="test">
+  <fingerprint pattern="Server v(\d+)">
+    <description>Generic server</description>
+    <param pos="1" name="service.version"/>
+    <anti-pattern>^Widget</anti-pattern>
+  </fingerprint>
+  <fingerprint pattern="^Widget Server v(\d+)">
+    <description>Widget server</description>
+    <param pos="1" name="service.version"/>
+  </fingerprint>
+  <fingerprint pattern="^Acme$.Server" flags="REG_LINE_ANY_CRLF">
+    <description>Acme server</description>
+  </fingerprint>
+  <fingerprint pattern="^Acme">
+    <description>Acme</description>
+  </fingerprint>
+  <fingerprint pattern="^\x{fffd}Gadget">
+    <description>Invalid gadget</description>
+  </fingerprint>
+</fingerprints>`
+	fdb, err := LoadFingerprintDB("test.xml", []byte(xmlData))
+	if err != nil {
+		t.Fatalf("LoadFingerprintDB() failed: %s", err)
+	}
+	if err := fdb.BuildMegaMatcher(); err != nil {
+		t.Fatalf("BuildMegaMatcher() failed: %s", err)
+	}
+
+	tests := []struct {
+		data     string
+		expected string
+		version  string
+	}{
+		{"Acme Server v2", "Generic server", "2"},
+		{"Widget Server v3", "Widget server", "3"},
+		{"Acme\r\nServer", "Acme server", ""},
+		{"Acme", "Acme", ""},
+		{"Gadget", "", ""},
+		{"\xffGadget", "Invalid gadget", ""},
+	}
+	for _, tc := range tests {
+		m := fdb.MatchFirstFast(tc.data)
+		desc := ""
+		if m.Matched {
+			desc = m.Fingerprint().Description.Text
+		}
+		if desc != tc.expected || m.Values["service.version"] != tc.version {
+			t.Errorf("MatchFirstFast(%q) matched %q %#v, expected %q", tc.data, desc, m.Values, tc.expected)
+		}
+	}
+
+	// Reordering the fingerprints falls back to MatchFirst
+	fdb.Fingerprints[0], fdb.Fingerprints[3] = fdb.Fingerprints[3], fdb.Fingerprints[0]
+	if m := fdb.MatchFirstFast("Acme Server v2"); !m.Matched || m.Fingerprint().Description.Text != "Acme" {
+		t.Errorf("MatchFirstFast() used a stale combined regexp: %#v", m)
+	}
+}
+
+func TestRequirement(t *testing.T) {
+	tests := []struct {
+		pattern string
+		prefix  string
+		text    string
+	}{
+		{`^OpenSSH_(\S+)$`, "OpenSSH_", ""},
+		{`^OpenSSH_(\S+) Debian`, "OpenSSH_", " Debian"},
+		{`Server v(\d+)`, "", "Server v"},
+		{`(?m)^Acme`, "", "Acme"},
+		{`(?i)^Acme`, "", ""},
+		{`^Acme|^Widget`, "", ""},
+		{`^(?:Widget)+ v\d`, "", "Widget"},
+		{`^\x{fffd}Acme`, "", "Acme"},
+		{`^\d*Acme`, "", "Acme"},
+	}
+	for _, tc := range tests {
+		parsed, err := syntax.Parse(tc.pattern, syntax.Perl)
+		if err != nil {
+			t.Fatalf("syntax.Parse(%q) failed: %s", tc.pattern, err)
+		}
+		if req := requirement(parsed.Simplify()); req.prefix != tc.prefix || req.text != tc.text {
+			t.Errorf("requirement(%q) = %+v, expected prefix %q and text %q", tc.pattern, req, tc.prefix, tc.text)
+		}
+	}
+}
+
+func TestMatchFirstFastExamples(t *testing.T) {
+	names := []string{"ssh_banners.xml", "ftp_banners.xml", "http_servers.xml"}
+	fs, err := LoadFingerprintsSubset(names...)
+	if err != nil {
+		t.Fatalf("LoadFingerprintsSubset() failed: %s", err)
+	}
+	for _, name := range names {
+		fdb := *fs.Databases[name]
+		if err := fdb.BuildMegaMatcher(); err != nil {
+			t.Fatalf("BuildMegaMatcher() failed for %s: %s", name, err)
+		}
+		for _, fp := range fdb.Fingerprints {
+			for _, ex := range fp.Examples {
+				data, err := fp.exampleData(ex, fdb.ExamplesPath)
+				if err != nil {
+					continue
+				}
+				expected, m := fdb.MatchFirst(data), fdb.MatchFirstFast(data)
+				if m.Matched != expected.Matched || m.Fingerprint() != expected.Fingerprint() {
+					t.Errorf("%s: MatchFirstFast(%q) differs from MatchFirst()", name, data)
+				}
+			}
+		}
+	}
+}
+
+func benchmarkExamples(b *testing.B, name string) (*FingerprintDB, []string) {
+	fs, err := LoadFingerprintsSubset(name)
+	if err != nil {
+		b.Fatalf("LoadFingerprintsSubset() failed: %s", err)
+	}
+	fdb := fs.Databases[name]
+	inputs := []string{}
+	for _, fp := range fdb.Fingerprints {
+		for _, ex := range fp.Examples {
+			if data, err := fp.exampleData(ex, ""); err == nil {
+				inputs = append(inputs, data)
+			}
+		}
+	}
+	return fdb, inputs
+}
+
+func BenchmarkMatchFirst(b *testing.B) {
+	fdb, inputs := benchmarkExamples(b, "ssh_banners.xml")
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		fdb.MatchFirst(inputs[i%len(inputs)])
+	}
+}
+
+func BenchmarkMatchFirstFast(b *testing.B) {
+	fdb, inputs := benchmarkExamples(b, "ssh_banners.xml")
+	if err := fdb.BuildMegaMatcher(); err != nil {
+		b.Fatalf("BuildMegaMatcher() failed: %s", err)
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		fdb.MatchFirstFast(inputs[i%len(inputs)])
+	}
+}
@@ -3,9 +3,17 @@ package recog
 //go:generate go run gen/vfsdata/main.go
 
 import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
-	"io/ioutil"
+	"io"
 	"net/http"
+	"path"
+	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
 
 	log "github.com/sirupsen/logrus"
@@ -14,19 +22,75 @@ import (
 // FingerprintSet is a collection of loaded Recog fingerprint databases
 type FingerprintSet struct {
 	Databases map[string]*FingerprintDB
-	Logger    *log.Logger
+	// Logger is applied to databases as they are loaded, use SetLogger to change
+	// the logger of databases that are already loaded
+	Logger *log.Logger
+	// LazyCompile sets FingerprintDB.LazyCompile on the databases loaded into the set
+	LazyCompile bool
+	// SniffProtocols makes MatchFirstOrdered try the databases of the protocol
+	// recognized by SniffProtocol before the other databases
+	SniffProtocols bool
+
+	// recorder captures match calls, see WithRecorder
+	recorder *sessionRecorder
+	// pending holds partial databases loaded before the base they extend, by base name
+	pending map[string][]*FingerprintDB
+	// merged maps the file names and "matches" aliases of partial databases to the
+	// base database they were added to, so partials may extend other partials
+	merged map[string]*FingerprintDB
+	// checksum caches the result of Fingerprint, it is recomputed after each load
+	checksum string
+}
+
+// Option configures a FingerprintSet before any databases are loaded
+type Option func(fs *FingerprintSet)
+
+// WithLogger sets the logger of the set and of every database loaded into it
+func WithLogger(l *log.Logger) Option {
+	return func(fs *FingerprintSet) {
+		fs.Logger = l
+	}
+}
+
+// WithLazyCompile defers compiling the patterns of loaded databases until first use
+func WithLazyCompile() Option {
+	return func(fs *FingerprintSet) {
+		fs.LazyCompile = true
+	}
+}
+
+// WithProtocolSniffing sets SniffProtocols, see MatchFirstOrdered
+func WithProtocolSniffing() Option {
+	return func(fs *FingerprintSet) {
+		fs.SniffProtocols = true
+	}
 }
 
 // NewFingerprintSet returns an allocated FingerprintSet structure
-func NewFingerprintSet() *FingerprintSet {
+func NewFingerprintSet(opts ...Option) *FingerprintSet {
 	fs := &FingerprintSet{}
 	fs.Databases = make(map[string]*FingerprintDB)
+	for _, opt := range opts {
+		opt(fs)
+	}
 	return fs
 }
 
-// MatchFirst matches data to a given fingerprint database
+// MatchFirst matches data to a given fingerprint database. An empty name matches
+// data against every database in descending order of preference instead.
 func (fs *FingerprintSet) MatchFirst(name string, data string) *FingerprintMatch {
+	m := fs.matchFirst(name, data)
+	fs.record("first", name, data, []*FingerprintMatch{m})
+	return m
+}
+
+// matchFirst implements MatchFirst without recording the call
+func (fs *FingerprintSet) matchFirst(name string, data string) *FingerprintMatch {
 	nomatch := &FingerprintMatch{Matched: false}
+	if name == "" {
+		_, m := fs.MatchFirstOrdered(data)
+		return m
+	}
 	fdb, ok := fs.Databases[name]
 	if !ok {
 		nomatch.Errors = append(nomatch.Errors, fmt.Errorf("database %s is missing", name))
@@ -35,8 +99,63 @@ func (fs *FingerprintSet) MatchFirst(name string, data string) *FingerprintMatch
 	return fdb.MatchFirst(data)
 }
 
+// MatchFirstOrdered matches data against every database in descending order of
+// preference, returning the first match and the name of the database it came from.
+// The name is empty when no database matched. When SniffProtocols is set and the
+// protocol of data is recognized, the databases of that protocol are tried first,
+// so obvious banners are matched without evaluating unrelated databases.
+func (fs *FingerprintSet) MatchFirstOrdered(data string) (string, *FingerprintMatch) {
+	fdbs := fs.databasesByPreference()
+	if fs.SniffProtocols {
+		if proto := SniffProtocol(data); proto != "" {
+			sort.SliceStable(fdbs, func(i, j int) bool {
+				return strings.EqualFold(fdbs[i].Protocol, proto) && !strings.EqualFold(fdbs[j].Protocol, proto)
+			})
+		}
+	}
+	for _, fdb := range fdbs {
+		if m := fdb.MatchFirst(data); m.Matched {
+			return fdb.Name, m
+		}
+	}
+	return "", &FingerprintMatch{Matched: false}
+}
+
+// MatchFirstIn matches data against the named databases in the given order,
+// returning the first match and the name of the database it came from. No
+// matching is attempted if any of the named databases is missing.
+func (fs *FingerprintSet) MatchFirstIn(names []string, data string) (string, *FingerprintMatch) {
+	nomatch := &FingerprintMatch{Matched: false}
+	fdbs := make([]*FingerprintDB, 0, len(names))
+	for _, name := range names {
+		fdb, ok := fs.Databases[name]
+		if !ok {
+			nomatch.Errors = append(nomatch.Errors, fmt.Errorf("database %s is missing", name))
+			continue
+		}
+		fdbs = append(fdbs, fdb)
+	}
+	if len(nomatch.Errors) > 0 {
+		return "", nomatch
+	}
+
+	for i, fdb := range fdbs {
+		if m := fdb.MatchFirst(data); m.Matched {
+			return names[i], m
+		}
+	}
+	return "", nomatch
+}
+
 // MatchAll matches data to a given fingerprint database
 func (fs *FingerprintSet) MatchAll(name string, data string) []*FingerprintMatch {
+	ret := fs.matchAll(name, data)
+	fs.record("all", name, data, ret)
+	return ret
+}
+
+// matchAll implements MatchAll without recording the call
+func (fs *FingerprintSet) matchAll(name string, data string) []*FingerprintMatch {
 	nomatch := &FingerprintMatch{Matched: false}
 	fdb, ok := fs.Databases[name]
 	if !ok {
@@ -46,6 +165,173 @@ func (fs *FingerprintSet) MatchAll(name string, data string) []*FingerprintMatch
 	return fdb.MatchAll(data)
 }
 
+// SetMatch is a match from one of the databases in a FingerprintSet
+type SetMatch struct {
+	Database string
+	Match    *FingerprintMatch
+}
+
+// MatchEverywhere matches data against every fingerprint of every unique database,
+// returning all matches ordered by descending certainty. Matches with equal
+// certainty are ordered by descending database preference, then database order.
+func (fs *FingerprintSet) MatchEverywhere(data string) []SetMatch {
+	type rankedMatch struct {
+		certainty float64
+		match     SetMatch
+	}
+
+	ranked := []rankedMatch{}
+	for _, fdb := range fs.databasesByPreference() {
+		for _, m := range fdb.MatchAll(data) {
+			certainty, err := strconv.ParseFloat(m.Values[CertaintyKey], 64)
+			if err != nil {
+				fdb.DebugLogf("invalid certainty %q: %s", m.Values[CertaintyKey], err)
+			}
+			ranked = append(ranked, rankedMatch{certainty: certainty, match: SetMatch{Database: fdb.Name, Match: m}})
+		}
+	}
+
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return ranked[i].certainty > ranked[j].certainty
+	})
+
+	ret := make([]SetMatch, 0, len(ranked))
+	for _, r := range ranked {
+		ret = append(ret, r.match)
+	}
+	return ret
+}
+
+// RankedMatch is a match from one of the databases in a FingerprintSet, with the
+// values it was ranked by
+type RankedMatch struct {
+	Database    string
+	Description string
+	Certainty   float64
+	Preference  float64
+	Match       *FingerprintMatch
+}
+
+// RankedMatches matches data against every fingerprint of every unique database like
+// MatchEverywhere, returning all matches ordered by descending certainty, then by
+// descending database preference
+func (fs *FingerprintSet) RankedMatches(data string) []RankedMatch {
+	matches := fs.MatchEverywhere(data)
+	ret := make([]RankedMatch, 0, len(matches))
+	for _, sm := range matches {
+		certainty, _ := strconv.ParseFloat(sm.Match.Values[CertaintyKey], 64)
+		rm := RankedMatch{
+			Database:    sm.Database,
+			Description: sm.Match.Values[MatchedKey],
+			Certainty:   certainty,
+			Match:       sm.Match,
+		}
+		if fdb, ok := fs.Databases[sm.Database]; ok {
+			rm.Preference = fdb.PreferenceValue
+		}
+		ret = append(ret, rm)
+	}
+	return ret
+}
+
+// MatchByProtocol matches data against each database whose Protocol attribute
+// matches proto (case-insensitive), returning the first match from each database
+// keyed by database name. Databases without a Protocol attribute, such as the
+// util.os helper databases, are never consulted.
+func (fs *FingerprintSet) MatchByProtocol(proto string, data string) map[string]*FingerprintMatch {
+	ret := make(map[string]*FingerprintMatch)
+	fs.EachDatabase(func(name string, fdb *FingerprintDB) {
+		if fdb.Protocol == "" || !strings.EqualFold(fdb.Protocol, proto) {
+			return
+		}
+		if m := fdb.MatchFirst(data); m.Matched {
+			ret[name] = m
+		}
+	})
+	return ret
+}
+
+// Protocols returns the distinct protocols of the databases in the set, sorted. Databases
+// without a protocol attribute are skipped. Protocols are returned in lower case, since
+// MatchByProtocol compares them case-insensitively.
+func (fs *FingerprintSet) Protocols() []string {
+	seen := make(map[string]bool)
+	ret := []string{}
+	fs.EachDatabase(func(name string, fdb *FingerprintDB) {
+		proto := strings.ToLower(fdb.Protocol)
+		if proto == "" || seen[proto] {
+			return
+		}
+		seen[proto] = true
+		ret = append(ret, proto)
+	})
+	sort.Strings(ret)
+	return ret
+}
+
+// EachDatabase calls fn once for each unique database in the set, in order of
+// the database name. Aliases created for the "matches" attribute are skipped.
+func (fs *FingerprintSet) EachDatabase(fn func(name string, fdb *FingerprintDB)) {
+	seen := make(map[*FingerprintDB]bool)
+	fdbs := []*FingerprintDB{}
+	for _, fdb := range fs.Databases {
+		if seen[fdb] {
+			continue
+		}
+		seen[fdb] = true
+		fdbs = append(fdbs, fdb)
+	}
+
+	sort.Slice(fdbs, func(i, j int) bool {
+		return fdbs[i].Name < fdbs[j].Name
+	})
+
+	for _, fdb := range fdbs {
+		fn(fdb.Name, fdb)
+	}
+}
+
+// databasesByPreference returns the unique databases in descending order of preference
+func (fs *FingerprintSet) databasesByPreference() []*FingerprintDB {
+	fdbs := []*FingerprintDB{}
+	fs.EachDatabase(func(name string, fdb *FingerprintDB) {
+		fdbs = append(fdbs, fdb)
+	})
+	sort.SliceStable(fdbs, func(i, j int) bool {
+		return fdbs[i].PreferenceValue > fdbs[j].PreferenceValue
+	})
+	return fdbs
+}
+
+// Len returns the number of unique databases and the total number of fingerprints in the set
+func (fs *FingerprintSet) Len() (databases int, fingerprints int) {
+	fs.EachDatabase(func(name string, fdb *FingerprintDB) {
+		databases++
+		fingerprints += len(fdb.Fingerprints)
+	})
+	return databases, fingerprints
+}
+
+// Fingerprint returns a stable checksum over the contents of every loaded
+// database, which identifies the fingerprint corpus used to produce a result.
+// The checksum is computed once each time databases are loaded, databases added
+// to the Databases map directly are only included once the set is loaded again.
+func (fs *FingerprintSet) Fingerprint() string {
+	if fs.checksum != "" {
+		return fs.checksum
+	}
+	return fs.computeChecksum()
+}
+
+// computeChecksum hashes the names and checksums of the databases, see Fingerprint
+func (fs *FingerprintSet) computeChecksum() string {
+	h := sha256.New()
+	fs.EachDatabase(func(name string, fdb *FingerprintDB) {
+		fmt.Fprintf(h, "%s\x00%s\x00", name, fdb.Checksum)
+	})
+	return hex.EncodeToString(h.Sum(nil))
+}
+
 // LoadFingerprints parses the embedded Recog XML databases, returning a FingerprintSet
 func (fs *FingerprintSet) LoadFingerprints() error {
 	return fs.LoadFingerprintsFromFS(RecogXML)
@@ -56,8 +342,45 @@ func (fs *FingerprintSet) LoadFingerprintsDir(dname string) error {
 	return fs.LoadFingerprintsFromFS(http.Dir(dname))
 }
 
+// LoadFingerprintsSubset parses the embedded Recog XML databases matching the given
+// names, which may be a file name or a "matches" attribute. Other databases are
+// skipped without compiling their patterns.
+func (fs *FingerprintSet) LoadFingerprintsSubset(names ...string) error {
+	wanted := make(map[string]bool)
+	for _, name := range names {
+		wanted[name] = false
+	}
+
+	err := fs.loadFromFS(RecogXML, func(name string, fdb *FingerprintDB) bool {
+		if _, ok := wanted[name]; ok {
+			wanted[name] = true
+		} else if _, ok := wanted[fdb.Matches]; ok && fdb.Matches != "" {
+			wanted[fdb.Matches] = true
+		} else {
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		if !wanted[name] {
+			return fmt.Errorf("database %s is missing", name)
+		}
+	}
+	return nil
+}
+
 // LoadFingerprintsFromFS parses an embedded Recog XML database, returning a FingerprintSet
 func (fs *FingerprintSet) LoadFingerprintsFromFS(efs http.FileSystem) error {
+	return fs.loadFromFS(efs, nil)
+}
+
+// loadFromFS parses the Recog XML files of efs, skipping any that filter rejects based
+// on the name and root attributes of the database. Each file is decoded as it is read.
+func (fs *FingerprintSet) loadFromFS(efs http.FileSystem, filter func(name string, fdb *FingerprintDB) bool) error {
 	rootfs, err := efs.Open("/")
 	if err != nil {
 		return fmt.Errorf("failed to open root: %s", err.Error())
@@ -80,39 +403,219 @@ func (fs *FingerprintSet) LoadFingerprintsFromFS(efs http.FileSystem) error {
 			return fmt.Errorf("failed to open %s: %s", f.Name(), err.Error())
 		}
 
-		xmlData, err := ioutil.ReadAll(fd)
-		if err != nil {
-			fd.Close()
-			return fmt.Errorf("failed to read %s: %s", f.Name(), err.Error())
+		fdb := FingerprintDB{Name: f.Name(), LazyCompile: fs.LazyCompile}
+		var keep func(*FingerprintDB) bool
+		if filter != nil {
+			keep = func(fdb *FingerprintDB) bool { return filter(f.Name(), fdb) }
 		}
+		loaded, err := fdb.decodeStream(fd, keep)
 		fd.Close()
-
-		fdb, err := LoadFingerprintDB(f.Name(), xmlData)
 		if err != nil {
 			return fmt.Errorf("failed to load %s: %s", f.Name(), err.Error())
 		}
+		if !loaded {
+			continue
+		}
+
+		// Track the example directory for databases loaded from disk
+		if dname, ok := efs.(http.Dir); ok {
+			fdb.ExamplesPath = filepath.Join(string(dname), strings.TrimSuffix(f.Name(), filepath.Ext(f.Name())))
+		}
 
-		fdb.Logger = fs.Logger
+		if err := fs.addDatabase(&fdb); err != nil {
+			return err
+		}
+	}
 
-		// Create an alias for the file name
-		fs.Databases[f.Name()] = &fdb
+	return fs.finishLoad()
+}
 
-		// Create an alias for the "matches" attribute
-		fs.Databases[fdb.Matches] = &fdb
+// LoadFingerprintsFromTarGz parses Recog XML files from a gzip-compressed tar stream.
+// Each .xml entry is loaded as a database named after its path within the archive.
+func (fs *FingerprintSet) LoadFingerprintsFromTarGz(r io.Reader) error {
+	gz, err := gzip.NewReader(r)
+	if err != nil {
+		return fmt.Errorf("failed to gunzip: %s", err.Error())
 	}
+	defer gz.Close()
 
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read archive: %s", err.Error())
+		}
+
+		if hdr.Typeflag != tar.TypeReg || path.Ext(hdr.Name) != ".xml" {
+			continue
+		}
+
+		name := strings.TrimPrefix(path.Clean(hdr.Name), "./")
+		fdb := FingerprintDB{Name: name, LazyCompile: fs.LazyCompile}
+		if _, err := fdb.decodeStream(tr, nil); err != nil {
+			return fmt.Errorf("failed to load %s: %s", name, err.Error())
+		}
+
+		if err := fs.addDatabase(&fdb); err != nil {
+			return err
+		}
+	}
+
+	return fs.finishLoad()
+}
+
+// SetLogger sets the logger of the set and of every database already loaded into it.
+// Databases loaded later also use the logger.
+func (fs *FingerprintSet) SetLogger(l *log.Logger) {
+	fs.Logger = l
+	for _, fdb := range fs.Databases {
+		fdb.Logger = l
+	}
+}
+
+// Unload releases the databases of the set so their compiled patterns can be collected,
+// even while references to the set or its databases remain. The set is empty afterward
+// and is not meant to be used again, load the fingerprints into a new set instead.
+// Matches returned earlier remain valid.
+func (fs *FingerprintSet) Unload() {
+	for _, fdb := range fs.Databases {
+		fdb.Fingerprints = nil
+		fdb.byDescription = nil
+		fdb.mega = nil
+	}
+	fs.Databases = make(map[string]*FingerprintDB)
+	fs.merged = nil
+	fs.recorder = nil
+	fs.checksum = ""
+}
+
+// addDatabase stores a loaded database under its name and "matches" aliases. A partial
+// database is added to the database it extends instead, once that has been loaded. A
+// partial database may extend another partial database, in which case it is added to
+// the base database at the end of the chain.
+func (fs *FingerprintSet) addDatabase(fdb *FingerprintDB) error {
+	fdb.Logger = fs.Logger
+	fs.checksum = ""
+
+	if fdb.Extends != "" {
+		base, ok := fs.Databases[fdb.Extends]
+		if !ok {
+			base, ok = fs.merged[fdb.Extends]
+		}
+		if !ok {
+			if fs.pending == nil {
+				fs.pending = make(map[string][]*FingerprintDB)
+			}
+			fs.pending[fdb.Extends] = append(fs.pending[fdb.Extends], fdb)
+			return nil
+		}
+		return fs.extendDatabase(base, fdb)
+	}
+
+	// Create an alias for the file name
+	fs.Databases[fdb.Name] = fdb
+
+	// Create an alias for the "matches" attribute
+	if fdb.Matches != "" {
+		fs.Databases[fdb.Matches] = fdb
+	}
+
+	// Apply any partial databases loaded before this one
+	return fs.applyPending(fdb, fdb)
+}
+
+// extendDatabase adds a partial database to base, followed by any pending partial
+// databases that extend the partial database
+func (fs *FingerprintSet) extendDatabase(base *FingerprintDB, partial *FingerprintDB) error {
+	if err := base.extend(partial); err != nil {
+		return err
+	}
+	if fs.merged == nil {
+		fs.merged = make(map[string]*FingerprintDB)
+	}
+	fs.merged[partial.Name] = base
+	if partial.Matches != "" {
+		fs.merged[partial.Matches] = base
+	}
+	return fs.applyPending(base, partial)
+}
+
+// applyPending adds the pending partial databases extending fdb, by file name or
+// "matches" attribute, to base
+func (fs *FingerprintSet) applyPending(base *FingerprintDB, fdb *FingerprintDB) error {
+	for _, name := range []string{fdb.Name, fdb.Matches} {
+		partials := fs.pending[name]
+		delete(fs.pending, name)
+		for _, partial := range partials {
+			if err := fs.extendDatabase(base, partial); err != nil {
+				return err
+			}
+		}
+	}
 	return nil
 }
 
+// finishLoad checks the databases added by a load and computes the checksum of the set
+func (fs *FingerprintSet) finishLoad() error {
+	if err := fs.checkPending(); err != nil {
+		return err
+	}
+	fs.checksum = fs.computeChecksum()
+	return nil
+}
+
+// checkPending reports partial databases whose base database was not loaded,
+// including partial databases that extend each other in a cycle
+func (fs *FingerprintSet) checkPending() error {
+	names := make([]string, 0, len(fs.pending))
+	partials := make(map[string]bool)
+	for name, pending := range fs.pending {
+		names = append(names, name)
+		for _, partial := range pending {
+			partials[partial.Name] = true
+			partials[partial.Matches] = true
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+
+	// Report a missing base database before the partial databases waiting on it,
+	// sorting the names for a stable error
+	sort.Strings(names)
+	for _, name := range names {
+		if !partials[name] {
+			return fmt.Errorf("failed to load %s: base database %s is missing", fs.pending[name][0].Name, name)
+		}
+	}
+	return fmt.Errorf("failed to load %s: base database %s is a partial database extending it in a cycle", fs.pending[names[0]][0].Name, names[0])
+}
+
 // LoadFingerprints parses embedded Recog XML databases, returning a FingerprintSet
-func LoadFingerprints() (*FingerprintSet, error) {
-	res := NewFingerprintSet()
+func LoadFingerprints(opts ...Option) (*FingerprintSet, error) {
+	res := NewFingerprintSet(opts...)
 	return res, res.LoadFingerprints()
 }
 
-// LoadFingerprintsDir parses Recog XML files from a local directory, returning a FingerprintSet
-func LoadFingerprintsDir(dname string) (*FingerprintSet, error) {
+// LoadFingerprintsWithLogger parses embedded Recog XML databases, returning a
+// FingerprintSet whose databases log to l
+func LoadFingerprintsWithLogger(l *log.Logger) (*FingerprintSet, error) {
+	return LoadFingerprints(WithLogger(l))
+}
+
+// LoadFingerprintsSubset parses the embedded Recog XML databases matching the given
+// file names or "matches" attributes, returning a FingerprintSet
+func LoadFingerprintsSubset(names ...string) (*FingerprintSet, error) {
 	res := NewFingerprintSet()
+	return res, res.LoadFingerprintsSubset(names...)
+}
+
+// LoadFingerprintsDir parses Recog XML files from a local directory, returning a FingerprintSet
+func LoadFingerprintsDir(dname string, opts ...Option) (*FingerprintSet, error) {
+	res := NewFingerprintSet(opts...)
 	return res, res.LoadFingerprintsDir(dname)
 }
 
@@ -1,10 +1,87 @@
 package recog
 
 import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/xml"
+	"io/ioutil"
+	"net/http"
 	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
 	"testing"
+
+	log "github.com/sirupsen/logrus"
 )
 
+// testDatabases is a small corpus in the layout of the embedded databases, used by
+// tests of the FingerprintSet API that do not depend on the embedded fingerprints
+var testDatabases = map[string]string{
+	"ssh_banners.xml": `<fingerprints matches="ssh.banner" protocol="ssh" database_type="service" preference="0.90">
+  <fingerprint pattern="^OpenSSH_([\w.]+)$">
+    <description>OpenSSH with just a version, no comment by vendor</description>
+    <example service.version="7.4">OpenSSH_7.4</example>
+    <param pos="0" name="service.vendor" value="OpenBSD"/>
+    <param pos="0" name="service.product" value="OpenSSH"/>
+    <param pos="1" name="service.version"/>
+  </fingerprint>
+</fingerprints>`,
+	"http_servers.xml": `<fingerprints matches="http_header.server" protocol="http" database_type="service" preference="0.90">
+  <fingerprint pattern="^Apache/(\d[\d.]*)(?: \(([^)]+)\))?$">
+    <description>Apache</description>
+    <example service.version="2.4.6">Apache/2.4.6 (Red Hat Enterprise Linux)</example>
+    <param pos="0" name="service.vendor" value="Apache"/>
+    <param pos="0" name="service.product" value="HTTPD"/>
+    <param pos="1" name="service.version"/>
+    <param pos="2" name="apache.info"/>
+  </fingerprint>
+</fingerprints>`,
+	"apache_os.xml": `<fingerprints matches="apache_os" database_type="util.os" preference="0.10">
+  <fingerprint pattern="\(Red Hat Enterprise Linux\)" certainty="0.5">
+    <description>Red Hat Enterprise Linux</description>
+    <example>Apache/2.4.6 (Red Hat Enterprise Linux)</example>
+    <param pos="0" name="os.vendor" value="Red Hat"/>
+    <param pos="0" name="os.product" value="Enterprise Linux"/>
+  </fingerprint>
+</fingerprints>`,
+	"architecture.xml": `<fingerprints matches="architecture" database_type="util.os">
+  <fingerprint pattern="^x86_64$">
+    <description>x86_64</description>
+    <example>x86_64</example>
+    <param pos="0" name="os.arch" value="x86_64"/>
+  </fingerprint>
+</fingerprints>`,
+	"hp_pjl_id.xml": `<fingerprints protocol="pjl" database_type="service" preference="0.10">
+  <fingerprint pattern="^Xerox ColorQube (\S+)$">
+    <description>Xerox ColorQube</description>
+    <example os.product="8570DT">Xerox ColorQube 8570DT</example>
+    <param pos="0" name="os.vendor" value="Xerox"/>
+    <param pos="1" name="os.product"/>
+  </fingerprint>
+</fingerprints>`,
+}
+
+// loadTestDatabases returns a new FingerprintSet loaded from testDatabases
+func loadTestDatabases(tb testing.TB) *FingerprintSet {
+	tb.Helper()
+	dir := tb.TempDir()
+	for name, xmlData := range testDatabases {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(xmlData), 0o644); err != nil {
+			tb.Fatal(err)
+		}
+	}
+	fset, err := LoadFingerprintsDir(dir)
+	if err != nil {
+		tb.Fatalf("LoadFingerprintsDir() failed: %s", err)
+	}
+	return fset
+}
+
 func TestLoad(t *testing.T) {
 	fset, err := LoadFingerprints()
 	if err != nil {
@@ -15,6 +92,67 @@ func TestLoad(t *testing.T) {
 	}
 }
 
+func TestLen(t *testing.T) {
+	fset := loadTestDatabases(t)
+
+	databases, fingerprints := fset.Len()
+	if databases == 0 || fingerprints == 0 {
+		t.Fatalf("Len() returned %d databases and %d fingerprints", databases, fingerprints)
+	}
+	if databases >= len(fset.Databases) {
+		t.Errorf("Len() counted %d databases, aliases should not be counted (%d entries)", databases, len(fset.Databases))
+	}
+	if fingerprints < databases {
+		t.Errorf("Len() counted fewer fingerprints (%d) than databases (%d)", fingerprints, databases)
+	}
+}
+
+func TestUnload(t *testing.T) {
+	fset, err := LoadFingerprintsSubset("ssh.banner")
+	if err != nil {
+		t.Fatalf("LoadFingerprintsSubset() failed: %s", err)
+	}
+	fdb := fset.Databases["ssh.banner"]
+	m := fset.MatchFirst("ssh.banner", "OpenSSH_7.4")
+	if !m.Matched {
+		t.Fatalf("MatchFirst() failed to match")
+	}
+
+	fset.Unload()
+	if databases, fingerprints := fset.Len(); databases != 0 || fingerprints != 0 || len(fset.Databases) != 0 {
+		t.Errorf("Len() returned %d databases and %d fingerprints after Unload()", databases, fingerprints)
+	}
+	if len(fdb.Fingerprints) != 0 {
+		t.Errorf("Unload() kept %d fingerprints of a database", len(fdb.Fingerprints))
+	}
+	if m := fset.MatchFirst("ssh.banner", "OpenSSH_7.4"); m.Matched {
+		t.Errorf("MatchFirst() matched after Unload()")
+	}
+	if m.Values["service.product"] != "OpenSSH" {
+		t.Errorf("Unload() changed an earlier match: %v", m.Values)
+	}
+}
+
+func TestProtocols(t *testing.T) {
+	fset := loadTestDatabases(t)
+	expected := []string{"http", "pjl", "ssh"}
+	if protocols := fset.Protocols(); !reflect.DeepEqual(protocols, expected) {
+		t.Errorf("Protocols() = %q, expected %q", protocols, expected)
+	}
+	if protocols := NewFingerprintSet().Protocols(); len(protocols) != 0 {
+		t.Errorf("Protocols() = %q for an empty set", protocols)
+	}
+
+	// Protocols differing only in case are listed once, like MatchByProtocol treats them
+	fset = NewFingerprintSet()
+	for i, proto := range []string{"HTTP", "http", "Ssh"} {
+		fset.Databases[strconv.Itoa(i)] = &FingerprintDB{Name: strconv.Itoa(i), Protocol: proto}
+	}
+	if protocols := fset.Protocols(); !reflect.DeepEqual(protocols, []string{"http", "ssh"}) {
+		t.Errorf("Protocols() = %q, expected the protocols in lower case", protocols)
+	}
+}
+
 func TestLoadDir(t *testing.T) {
 	xmlPath := "./test/xml"
 	if v := os.Getenv("RECOG_XML"); v != "" {
@@ -39,22 +177,42 @@ func TestExamples(t *testing.T) {
 		t.Errorf("LoadFingerprints() returned an empty set")
 		return
 	}
-	for name, fdb := range fset.Databases {
+	fset.EachDatabase(func(name string, fdb *FingerprintDB) {
 		err := fdb.VerifyExamples(".")
 		if err != nil {
 			t.Errorf("VerifyExamples() failed for %s: %s", name, err)
 		}
+	})
+}
+
+func TestEachDatabase(t *testing.T) {
+	fset := loadTestDatabases(t)
+
+	visited := make(map[*FingerprintDB]int)
+	names := []string{}
+	fset.EachDatabase(func(name string, fdb *FingerprintDB) {
+		visited[fdb]++
+		names = append(names, name)
+	})
+
+	for _, fdb := range fset.Databases {
+		if visited[fdb] != 1 {
+			t.Errorf("EachDatabase() visited %s %d times", fdb.Name, visited[fdb])
+		}
+	}
+	if !sort.StringsAreSorted(names) {
+		t.Errorf("EachDatabase() did not visit databases in sorted order: %v", names)
 	}
 }
 
 func TestPJL(t *testing.T) {
-	fset, err := LoadFingerprints()
+	fset, err := LoadFingerprintsSubset("hp_pjl_id.xml")
 	if err != nil {
-		t.Errorf("LoadFingerprints() failed")
+		t.Errorf("LoadFingerprintsSubset() failed")
 		return
 	}
 	if len(fset.Databases) == 0 {
-		t.Errorf("LoadFingerprints() returned an empty set")
+		t.Errorf("LoadFingerprintsSubset() returned an empty set")
 		return
 	}
 
@@ -70,13 +228,13 @@ func TestPJL(t *testing.T) {
 }
 
 func TestPJLv2(t *testing.T) {
-	fset, err := LoadFingerprints()
+	fset, err := LoadFingerprintsSubset("hp_pjl_id.xml")
 	if err != nil {
-		t.Errorf("LoadFingerprints() failed")
+		t.Errorf("LoadFingerprintsSubset() failed")
 		return
 	}
 	if len(fset.Databases) == 0 {
-		t.Errorf("LoadFingerprints() returned an empty set")
+		t.Errorf("LoadFingerprintsSubset() returned an empty set")
 		return
 	}
 
@@ -98,13 +256,13 @@ func TestPJLv2(t *testing.T) {
 }
 
 func TestHTMLTitle(t *testing.T) {
-	fset, err := LoadFingerprints()
+	fset, err := LoadFingerprintsSubset("html_title.xml")
 	if err != nil {
-		t.Errorf("LoadFingerprints() failed")
+		t.Errorf("LoadFingerprintsSubset() failed")
 		return
 	}
 	if len(fset.Databases) == 0 {
-		t.Errorf("LoadFingerprints() returned an empty set")
+		t.Errorf("LoadFingerprintsSubset() returned an empty set")
 		return
 	}
 
@@ -119,13 +277,13 @@ func TestHTMLTitle(t *testing.T) {
 	}
 }
 func TestX509Subjects(t *testing.T) {
-	fset, err := LoadFingerprints()
+	fset, err := LoadFingerprintsSubset("x509.subject")
 	if err != nil {
-		t.Errorf("LoadFingerprints() failed")
+		t.Errorf("LoadFingerprintsSubset() failed")
 		return
 	}
 	if len(fset.Databases) == 0 {
-		t.Errorf("LoadFingerprints() returned an empty set")
+		t.Errorf("LoadFingerprintsSubset() returned an empty set")
 		return
 	}
 
@@ -139,3 +297,621 @@ func TestX509Subjects(t *testing.T) {
 		t.Errorf("Failed to match 'iDRAC' expected product or vendor")
 	}
 }
+
+func TestVerifyExamplesDefaultPath(t *testing.T) {
+	dir := t.TempDir()
+	xmlData := `<fingerprints matches="test" protocol="test" database_type="service" preference="0.90">
+  <fingerprint pattern="^Example Server v([\d.]+)">
+    <description>Example server</description>
+    <example _filename="banner.txt" service.version="1.2.3"/>
+    <param pos="0" name="service.product" value="Example"/>
+    <param pos="1" name="service.version"/>
+  </fingerprint>
+</fingerprints>`
+	if err := os.WriteFile(filepath.Join(dir, "example.xml"), []byte(xmlData), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Mkdir(filepath.Join(dir, "example"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "example", "banner.txt"), []byte("Example Server v1.2.3"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	fset, err := LoadFingerprintsDir(dir)
+	if err != nil {
+		t.Fatalf("LoadFingerprintsDir() failed: %s", err)
+	}
+	if err := fset.Databases["example.xml"].VerifyExamples(""); err != nil {
+		t.Errorf("VerifyExamples() failed for directory load: %s", err)
+	}
+
+	fdb, err := LoadFingerprintDBFromFile(filepath.Join(dir, "example.xml"))
+	if err != nil {
+		t.Fatalf("LoadFingerprintDBFromFile() failed: %s", err)
+	}
+	if err := fdb.VerifyExamples(""); err != nil {
+		t.Errorf("VerifyExamples() failed for file load: %s", err)
+	}
+}
+
+func TestMatchByProtocol(t *testing.T) {
+	fset := loadTestDatabases(t)
+
+	ms := fset.MatchByProtocol("ssh", "OpenSSH_7.4")
+	m, ok := ms["ssh_banners.xml"]
+	if !ok || m.Values["service.product"] != "OpenSSH" {
+		t.Errorf("MatchByProtocol() failed to match the ssh banner: %#v", ms)
+	}
+	for name := range ms {
+		if fset.Databases[name].Protocol != "ssh" {
+			t.Errorf("MatchByProtocol() consulted non-ssh database %s", name)
+		}
+	}
+
+	if ms := fset.MatchByProtocol("", "OpenSSH_7.4"); len(ms) != 0 {
+		t.Errorf("MatchByProtocol() with an empty protocol should not match: %#v", ms)
+	}
+}
+
+func TestLoadFingerprintsFromTarGz(t *testing.T) {
+	xmlData, err := os.ReadFile("./test/xml/html_title.xml")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gz)
+	entries := []struct {
+		name string
+		data []byte
+		typ  byte
+	}{
+		{"xml/", nil, tar.TypeDir},
+		{"xml/README.md", []byte("not a database"), tar.TypeReg},
+		{"xml/http/html_title.xml", xmlData, tar.TypeReg},
+	}
+	for _, e := range entries {
+		hdr := &tar.Header{Name: e.name, Typeflag: e.typ, Mode: 0o644, Size: int64(len(e.data))}
+		if err := tw.WriteHeader(hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write(e.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := gz.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	fset := NewFingerprintSet()
+	if err := fset.LoadFingerprintsFromTarGz(&buf); err != nil {
+		t.Fatalf("LoadFingerprintsFromTarGz() failed: %s", err)
+	}
+	if databases, _ := fset.Len(); databases != 1 {
+		t.Errorf("LoadFingerprintsFromTarGz() loaded %d databases, expected 1", databases)
+	}
+
+	m := fset.MatchFirst("xml/http/html_title.xml", "MoinMoinWiki - MoinMoin")
+	if !m.Matched || m.Values["service.product"] != "MoinMoin" {
+		t.Errorf("Failed to match 'MoinMoinWiki' from the archive: %#v", m)
+	}
+	if fset.Databases["html_title"] != fset.Databases["xml/http/html_title.xml"] {
+		t.Errorf("LoadFingerprintsFromTarGz() did not alias the matches attribute")
+	}
+}
+
+func TestFingerprint(t *testing.T) {
+	xmlData := `<fingerprints matches="test" version="1.2" updated="2024-01-01">
+  <fingerprint pattern="^Acme Server">
+    <description>Acme server</description>
+    <param pos="0" name="service.product" value="Acme"/>
+  </fingerprint>
+</fingerprints>`
+
+	dir := t.TempDir()
+	fpath := filepath.Join(dir, "test.xml")
+	if err := os.WriteFile(fpath, []byte(xmlData), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	fset, err := LoadFingerprintsDir(dir)
+	if err != nil {
+		t.Fatalf("LoadFingerprintsDir() failed: %s", err)
+	}
+	if fdb := fset.Databases["test"]; fdb.Version != "1.2" || fdb.Updated != "2024-01-01" {
+		t.Errorf("failed to parse version metadata: %q %q", fdb.Version, fdb.Updated)
+	}
+
+	again, err := LoadFingerprintsDir(dir)
+	if err != nil {
+		t.Fatalf("LoadFingerprintsDir() failed: %s", err)
+	}
+	if fset.Fingerprint() != again.Fingerprint() {
+		t.Errorf("Fingerprint() is not stable across loads")
+	}
+
+	modified := strings.Replace(xmlData, "Acme server", "Acme server v2", 1)
+	if err := os.WriteFile(fpath, []byte(modified), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	changed, err := LoadFingerprintsDir(dir)
+	if err != nil {
+		t.Fatalf("LoadFingerprintsDir() failed: %s", err)
+	}
+	if fset.Fingerprint() == changed.Fingerprint() {
+		t.Errorf("Fingerprint() did not change when a database changed")
+	}
+
+	// The checksum is computed by each load rather than by each call
+	sum := fset.Fingerprint()
+	if fset.checksum != sum {
+		t.Errorf("Fingerprint() was not computed when the set was loaded")
+	}
+	other := t.TempDir()
+	if err := os.WriteFile(filepath.Join(other, "other.xml"), []byte(strings.Replace(xmlData, "test", "other", 1)), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := fset.LoadFingerprintsDir(other); err != nil {
+		t.Fatalf("LoadFingerprintsDir() failed: %s", err)
+	}
+	if fset.Fingerprint() == sum || fset.Fingerprint() != fset.computeChecksum() {
+		t.Errorf("Fingerprint() did not change when a database was added")
+	}
+	fset.Unload()
+	if fset.Fingerprint() != NewFingerprintSet().Fingerprint() {
+		t.Errorf("Fingerprint() did not change when the databases were unloaded")
+	}
+}
+
+func TestPreference(t *testing.T) {
+	fset := loadTestDatabases(t)
+
+	if pref := fset.Databases["http_servers.xml"].PreferenceValue; pref != 0.90 {
+		t.Errorf("http_servers.xml preference = %f, expected 0.90", pref)
+	}
+	if pref := fset.Databases["architecture.xml"].PreferenceValue; pref != DefaultPreference {
+		t.Errorf("architecture.xml preference = %f, expected the default", pref)
+	}
+	if _, ok := fset.Databases[""]; ok {
+		t.Errorf("databases without a matches attribute should not be aliased to an empty name")
+	}
+
+	fdbs := fset.databasesByPreference()
+	for i := 1; i < len(fdbs); i++ {
+		if fdbs[i].PreferenceValue > fdbs[i-1].PreferenceValue {
+			t.Errorf("%s is ordered before the higher preference %s", fdbs[i-1].Name, fdbs[i].Name)
+		}
+	}
+
+	m := fset.MatchFirst("", "Apache/2.4.6 (Red Hat Enterprise Linux)")
+	if !m.Matched || m.Values["service.product"] != "HTTPD" {
+		t.Errorf("MatchFirst() by preference should match the HTTP server database first: %#v", m)
+	}
+}
+
+func TestMatchFirstOrdered(t *testing.T) {
+	fset := loadTestDatabases(t)
+
+	// The Apache/RHEL banner also matches the lower preference apache_os.xml database
+	data := "Apache/2.4.6 (Red Hat Enterprise Linux)"
+	if m := fset.MatchFirst("apache_os.xml", data); !m.Matched {
+		t.Fatalf("expected %q to match apache_os.xml", data)
+	}
+
+	name, m := fset.MatchFirstOrdered(data)
+	if name != "http_servers.xml" {
+		t.Errorf("MatchFirstOrdered() matched %s, expected http_servers.xml", name)
+	}
+	if !m.Matched || m.Values["service.vendor"] != "Apache" {
+		t.Errorf("MatchFirstOrdered() returned an unexpected match: %#v", m)
+	}
+
+	if name, m := fset.MatchFirstOrdered("\x00\x01 no such banner"); name != "" || m.Matched {
+		t.Errorf("MatchFirstOrdered() matched unexpected data in %s: %#v", name, m)
+	}
+}
+
+func TestMatchFirstIn(t *testing.T) {
+	fset := loadTestDatabases(t)
+
+	data := "Apache/2.4.6 (Red Hat Enterprise Linux)"
+	name, m := fset.MatchFirstIn([]string{"apache_os.xml", "http_header.server"}, data)
+	if name != "apache_os.xml" || !m.Matched {
+		t.Errorf("MatchFirstIn() matched %s, expected apache_os.xml", name)
+	}
+
+	name, m = fset.MatchFirstIn([]string{"ssh.banner", "http_header.server"}, data)
+	if name != "http_header.server" || m.Values["service.vendor"] != "Apache" {
+		t.Errorf("MatchFirstIn() matched %s, expected http_header.server: %#v", name, m)
+	}
+
+	name, m = fset.MatchFirstIn([]string{"no_such_db", "http_header.server"}, data)
+	if name != "" || m.Matched || len(m.Errors) != 1 {
+		t.Errorf("MatchFirstIn() should fail for an unknown database: %s %#v", name, m)
+	}
+}
+
+func TestMatchEverywhere(t *testing.T) {
+	fset := loadTestDatabases(t)
+
+	data := "Apache/2.4.6 (Red Hat Enterprise Linux)"
+	matches := fset.MatchEverywhere(data)
+
+	found := map[string]int{}
+	for i, sm := range matches {
+		found[sm.Database]++
+		if !sm.Match.Matched {
+			t.Errorf("MatchEverywhere() returned an unmatched result for %s", sm.Database)
+		}
+		if i > 0 {
+			prev, _ := strconv.ParseFloat(matches[i-1].Match.Values["fp.certainty"], 64)
+			cur, _ := strconv.ParseFloat(sm.Match.Values["fp.certainty"], 64)
+			if cur > prev {
+				t.Errorf("MatchEverywhere() result %d is not ordered by certainty", i)
+			}
+		}
+	}
+	for _, name := range []string{"http_servers.xml", "apache_os.xml"} {
+		if found[name] == 0 {
+			t.Errorf("MatchEverywhere() did not return a match from %s: %v", name, found)
+		}
+	}
+	if found["http_header.server"] > 0 || found["apache_os"] > 0 {
+		t.Errorf("MatchEverywhere() returned matches for database aliases: %v", found)
+	}
+
+	if matches := fset.MatchEverywhere("\x00\x01 no such banner"); len(matches) != 0 {
+		t.Errorf("MatchEverywhere() matched unexpected data: %#v", matches)
+	}
+}
+
+func TestRankedMatches(t *testing.T) {
+	fset := NewFingerprintSet()
+	for _, xmlData := range []string{
+		`<fingerprints matches="low.banner" preference="0.20">
+  <fingerprint pattern="^Acme" certainty="0.9">
+    <description>Low Acme</description>
+  </fingerprint>
+  <fingerprint pattern="Server" certainty="0.5">
+    <description>Low server</description>
+  </fingerprint>
+</fingerprints>`,
+		`<fingerprints matches="high.banner" preference="0.80">
+  <fingerprint pattern="^Acme Server" certainty="0.9">
+    <description>High Acme server</description>
+  </fingerprint>
+  <fingerprint pattern="v\d">
+    <description>High version</description>
+  </fingerprint>
+</fingerprints>`,
+	} {
+		fdb, err := LoadFingerprintDB(databaseMatches([]byte(xmlData))+".xml", []byte(xmlData))
+		if err != nil {
+			t.Fatalf("LoadFingerprintDB() failed: %s", err)
+		}
+		fset.addDatabase(&fdb)
+	}
+
+	expected := []RankedMatch{
+		{Database: "high.banner.xml", Description: "High Acme server", Certainty: 0.9, Preference: 0.8},
+		{Database: "low.banner.xml", Description: "Low Acme", Certainty: 0.9, Preference: 0.2},
+		{Database: "high.banner.xml", Description: "High version", Certainty: 0.85, Preference: 0.8},
+		{Database: "low.banner.xml", Description: "Low server", Certainty: 0.5, Preference: 0.2},
+	}
+	matches := fset.RankedMatches("Acme Server v2")
+	if len(matches) != len(expected) {
+		t.Fatalf("RankedMatches() returned %d matches, expected %d: %#v", len(matches), len(expected), matches)
+	}
+	for i, rm := range matches {
+		if rm.Match == nil || !rm.Match.Matched {
+			t.Errorf("RankedMatches() result %d has no match", i)
+		}
+		rm.Match = nil
+		if rm != expected[i] {
+			t.Errorf("RankedMatches() result %d = %+v, expected %+v", i, rm, expected[i])
+		}
+	}
+}
+
+func TestLoadFingerprintsSubset(t *testing.T) {
+	fset, err := LoadFingerprintsSubset("ssh.banner", "http_servers.xml")
+	if err != nil {
+		t.Fatalf("LoadFingerprintsSubset() failed: %s", err)
+	}
+	if databases, _ := fset.Len(); databases != 2 {
+		t.Errorf("LoadFingerprintsSubset() loaded %d databases, expected 2", databases)
+	}
+	for _, name := range []string{"ssh_banners.xml", "ssh.banner", "http_servers.xml", "http_header.server"} {
+		if _, ok := fset.Databases[name]; !ok {
+			t.Errorf("LoadFingerprintsSubset() did not load %s", name)
+		}
+	}
+	if _, ok := fset.Databases["apache_os.xml"]; ok {
+		t.Errorf("LoadFingerprintsSubset() loaded an unrequested database")
+	}
+	if m := fset.MatchFirst("ssh.banner", "OpenSSH_7.4"); !m.Matched {
+		t.Errorf("failed to match a subset database: %#v", m)
+	}
+
+	if _, err := LoadFingerprintsSubset("ssh.banner", "no_such_db"); err == nil {
+		t.Errorf("LoadFingerprintsSubset() should fail for an unknown database")
+	}
+}
+
+func TestWithLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := log.New()
+	logger.Out = &buf
+
+	fset, err := LoadFingerprints(WithLogger(logger), WithLazyCompile())
+	if err != nil {
+		t.Fatalf("LoadFingerprints() failed: %s", err)
+	}
+	if fset.Logger != logger || !fset.LazyCompile {
+		t.Errorf("LoadFingerprints() did not apply the options")
+	}
+	fset.EachDatabase(func(name string, fdb *FingerprintDB) {
+		if fdb.Logger != logger {
+			t.Errorf("%s does not use the logger", name)
+		}
+	})
+
+	if m := fset.MatchFirst("ssh.banner", "OpenSSH_7.4"); !m.Matched {
+		t.Fatalf("MatchFirst() failed to match")
+	}
+	if !strings.Contains(buf.String(), "FP-MATCH") {
+		t.Errorf("expected debug output from the database, got %q", buf.String())
+	}
+}
+
+func TestSetLogger(t *testing.T) {
+	var buf bytes.Buffer
+	logger := log.New()
+	logger.Out = &buf
+
+	fset := NewFingerprintSet(WithLazyCompile())
+	if err := fset.LoadFingerprints(); err != nil {
+		t.Fatalf("LoadFingerprints() failed: %s", err)
+	}
+	fset.SetLogger(logger)
+	fset.EachDatabase(func(name string, fdb *FingerprintDB) {
+		if fdb.Logger != logger {
+			t.Errorf("%s does not use the logger", name)
+		}
+	})
+	if m := fset.MatchFirst("ssh.banner", "OpenSSH_7.4"); !m.Matched {
+		t.Fatalf("MatchFirst() failed to match")
+	}
+	if !strings.Contains(buf.String(), "FP-MATCH") {
+		t.Errorf("expected debug output from the database, got %q", buf.String())
+	}
+
+	// A logger set on the field before loading is applied to the loaded databases
+	buf.Reset()
+	fset = NewFingerprintSet(WithLazyCompile())
+	fset.Logger = logger
+	if err := fset.LoadFingerprints(); err != nil {
+		t.Fatalf("LoadFingerprints() failed: %s", err)
+	}
+	if m := fset.MatchFirst("ssh.banner", "OpenSSH_7.4"); !m.Matched {
+		t.Fatalf("MatchFirst() failed to match")
+	}
+	if !strings.Contains(buf.String(), "FP-MATCH") {
+		t.Errorf("expected debug output from the database, got %q", buf.String())
+	}
+
+	fset.SetLogger(nil)
+	buf.Reset()
+	fset.MatchFirst("ssh.banner", "OpenSSH_7.4")
+	if buf.Len() > 0 {
+		t.Errorf("expected no debug output after removing the logger, got %q", buf.String())
+	}
+}
+
+func BenchmarkLoadFingerprintsHeap(b *testing.B) {
+	var fset *FingerprintSet
+	for i := 0; i < b.N; i++ {
+		var before, after runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+
+		var err error
+		fset, err = LoadFingerprints()
+		if err != nil {
+			b.Fatalf("LoadFingerprints() failed: %s", err)
+		}
+
+		runtime.GC()
+		runtime.ReadMemStats(&after)
+		b.ReportMetric(float64(after.HeapAlloc-before.HeapAlloc), "heap-bytes")
+	}
+	runtime.KeepAlive(fset)
+}
+
+func benchmarkSubsetMatch(b *testing.B, lazy bool) {
+	for i := 0; i < b.N; i++ {
+		fset := NewFingerprintSet()
+		fset.LazyCompile = lazy
+		if err := fset.LoadFingerprints(); err != nil {
+			b.Fatalf("LoadFingerprints() failed: %s", err)
+		}
+		if m := fset.MatchFirst("ssh.banner", "OpenSSH_7.4"); !m.Matched {
+			b.Fatalf("MatchFirst() failed to match")
+		}
+	}
+}
+
+func BenchmarkSubsetMatchEager(b *testing.B) {
+	benchmarkSubsetMatch(b, false)
+}
+
+func BenchmarkSubsetMatchLazy(b *testing.B) {
+	benchmarkSubsetMatch(b, true)
+}
+
+func TestExtendDatabase(t *testing.T) {
+	dir, err := ioutil.TempDir("", "extends")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"a_overlay.xml": `<fingerprints extends="test.server">
+  <fingerprint pattern="^Acme Server v9$">
+    <description>Custom Acme</description>
+    <param pos="0" name="service.product" value="Custom"/>
+  </fingerprint>
+</fingerprints>`,
+		"b_base.xml": `<fingerprints matches="test.server">
+  <fingerprint pattern="^Acme Server v\d+$">
+    <description>Acme</description>
+    <param pos="0" name="service.product" value="Base"/>
+  </fingerprint>
+</fingerprints>`,
+		"c_fallback.xml": `<fingerprints extends="b_base.xml" extend_order="last">
+  <fingerprint pattern="^Acme">
+    <description>Acme fallback</description>
+    <param pos="0" name="service.product" value="Fallback"/>
+  </fingerprint>
+</fingerprints>`,
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fset := NewFingerprintSet()
+	if err := fset.LoadFingerprintsFromFS(http.Dir(dir)); err != nil {
+		t.Fatalf("LoadFingerprintsFromFS() failed: %s", err)
+	}
+	if databases, fingerprints := fset.Len(); databases != 1 || fingerprints != 3 {
+		t.Fatalf("Len() returned %d databases and %d fingerprints, expected 1 and 3", databases, fingerprints)
+	}
+
+	tests := []struct {
+		data    string
+		product string
+	}{
+		{"Acme Server v9", "Custom"},
+		{"Acme Server v2", "Base"},
+		{"Acme Other", "Fallback"},
+	}
+	for _, tc := range tests {
+		m := fset.MatchFirst("test.server", tc.data)
+		if m.Values["service.product"] != tc.product {
+			t.Errorf("MatchFirst(%q) = %q, expected %q", tc.data, m.Values["service.product"], tc.product)
+		}
+	}
+	if _, ok := fset.Databases["test.server"].FindByDescription("Custom Acme"); !ok {
+		t.Errorf("FindByDescription() did not find an added fingerprint")
+	}
+
+	// A partial database without its base fails to load
+	if err := os.Remove(filepath.Join(dir, "b_base.xml")); err != nil {
+		t.Fatal(err)
+	}
+	if err := NewFingerprintSet().LoadFingerprintsFromFS(http.Dir(dir)); err == nil || !strings.Contains(err.Error(), "base database") {
+		t.Errorf("LoadFingerprintsFromFS() returned %v for a missing base database", err)
+	}
+}
+
+func TestExtendDatabaseChain(t *testing.T) {
+	dir, err := ioutil.TempDir("", "extends")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"a_site.xml": `<fingerprints extends="vendor.overlay">
+  <fingerprint pattern="^Acme Server v9$">
+    <description>Site Acme</description>
+    <example _filename="site.txt"/>
+    <param pos="0" name="service.product" value="Site"/>
+  </fingerprint>
+</fingerprints>`,
+		"b_vendor.xml": `<fingerprints matches="vendor.overlay" extends="test.server" extend_order="last">
+  <fingerprint pattern="^Acme">
+    <description>Vendor Acme</description>
+    <param pos="0" name="service.product" value="Vendor"/>
+  </fingerprint>
+</fingerprints>`,
+		"c_base.xml": `<fingerprints matches="test.server">
+  <fingerprint pattern="^Acme Server v\d+$">
+    <description>Acme</description>
+    <param pos="0" name="service.product" value="Base"/>
+  </fingerprint>
+</fingerprints>`,
+		"a_site/site.txt": "Acme Server v9",
+	}
+	if err := os.Mkdir(filepath.Join(dir, "a_site"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, data := range files {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(data), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	fset := NewFingerprintSet()
+	if err := fset.LoadFingerprintsFromFS(http.Dir(dir)); err != nil {
+		t.Fatalf("LoadFingerprintsFromFS() failed: %s", err)
+	}
+	if databases, fingerprints := fset.Len(); databases != 1 || fingerprints != 3 {
+		t.Fatalf("Len() returned %d databases and %d fingerprints, expected 1 and 3", databases, fingerprints)
+	}
+	for data, product := range map[string]string{"Acme Server v9": "Site", "Acme Server v2": "Base", "Acme Other": "Vendor"} {
+		if m := fset.MatchFirst("test.server", data); m.Values["service.product"] != product {
+			t.Errorf("MatchFirst(%q) = %q, expected %q", data, m.Values["service.product"], product)
+		}
+	}
+
+	// The external examples of a partial database are read from its own directory
+	if err := fset.Databases["test.server"].VerifyExamples(""); err != nil {
+		t.Errorf("VerifyExamples() failed for an example of a partial database: %s", err)
+	}
+
+	// A chain of partial databases that never reaches a base database fails to load
+	if err := os.Remove(filepath.Join(dir, "c_base.xml")); err != nil {
+		t.Fatal(err)
+	}
+	err = NewFingerprintSet().LoadFingerprintsFromFS(http.Dir(dir))
+	if err == nil || !strings.Contains(err.Error(), "b_vendor.xml: base database test.server is missing") {
+		t.Errorf("LoadFingerprintsFromFS() returned %v for a chain without a base database", err)
+	}
+
+	// Partial databases extending each other in a cycle fail to load
+	cycle := `<fingerprints matches="test.server" extends="a_site.xml"/>`
+	if err := ioutil.WriteFile(filepath.Join(dir, "c_base.xml"), []byte(cycle), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	err = NewFingerprintSet().LoadFingerprintsFromFS(http.Dir(dir))
+	if err == nil || !strings.Contains(err.Error(), "cycle") {
+		t.Errorf("LoadFingerprintsFromFS() returned %v for a cycle of partial databases", err)
+	}
+}
+
+// databaseMatches returns the "matches" attribute of the root element of a database
+func databaseMatches(xmlData []byte) string {
+	dec := xml.NewDecoder(bytes.NewReader(xmlData))
+	for {
+		tok, err := dec.Token()
+		if err != nil {
+			return ""
+		}
+		if se, ok := tok.(xml.StartElement); ok {
+			for _, attr := range se.Attr {
+				if attr.Name.Local == "matches" {
+					return attr.Value
+				}
+			}
+			return ""
+		}
+	}
+}
@@ -0,0 +1,507 @@
+package recog
+
+import (
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// isHex reports whether every byte of s is a hexadecimal digit
+func isHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F') {
+			return false
+		}
+	}
+	return len(s) > 0
+}
+
+// allHex reports whether every string is a hexadecimal number of up to 6 digits
+func allHex(points []string) bool {
+	for _, point := range points {
+		if len(point) > 6 || !isHex(point) {
+			return false
+		}
+	}
+	return true
+}
+
+// translatePattern rewrites Ruby regular expression syntax that Go does not
+// support, returning the translated pattern and a description of each rewrite.
+// The extended argument enables extended mode, as if the pattern began with (?x),
+// and fold reports whether the pattern is compiled case-insensitively.
+func translatePattern(pattern string, extended bool, fold bool) (string, []string, error) {
+	var notes []string
+	translated := translateExtended(pattern, extended)
+	if translated != pattern {
+		notes = append(notes, "removed extended mode whitespace and comments")
+	}
+
+	unescaped := translated
+	translated = translateUnicodeEscapes(unescaped)
+	if translated != unescaped {
+		notes = append(notes, "translated unicode escapes")
+	}
+
+	translated, possessive, err := translatePossessive(translated, fold)
+	if err != nil {
+		return "", nil, err
+	}
+	return translated, append(notes, possessive...), nil
+}
+
+// leadingFlagsPattern matches a group of inline flags at the start of a pattern
+var leadingFlagsPattern = regexp.MustCompile(`^\(\?([imx]+)\)`)
+
+// translateExtended implements Ruby extended mode, which Go does not support, by
+// removing unescaped whitespace and # comments outside of character classes.
+// Extended mode is enabled by the extended argument or a leading (?x) flag group,
+// and the x flag is removed from the group.
+func translateExtended(pattern string, extended bool) string {
+	if m := leadingFlagsPattern.FindStringSubmatch(pattern); m != nil && strings.Contains(m[1], "x") {
+		extended = true
+		flags := strings.Replace(m[1], "x", "", -1)
+		rest := pattern[len(m[0]):]
+		if flags == "" {
+			pattern = rest
+		} else {
+			pattern = "(?" + flags + ")" + rest
+		}
+	}
+	if !extended {
+		return pattern
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; c {
+		case '\\':
+			end := escapeEnd(pattern, i)
+			b.WriteString(pattern[i:end])
+			i = end - 1
+		case '[':
+			end := classEnd(pattern, i)
+			b.WriteString(pattern[i:end])
+			i = end - 1
+		case ' ', '\t', '\n', '\r', '\f', '\v':
+		case '#':
+			if end := strings.IndexByte(pattern[i:], '\n'); end >= 0 {
+				i += end
+			} else {
+				i = len(pattern)
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
+
+// translateUnicodeEscapes rewrites Ruby \uXXXX and \u{X...} escapes in a pattern
+// to the \x{XXXX} form understood by Go. The braced form may list several code
+// points separated by spaces, such as \u{48 49}. Escaped backslashes are
+// preserved, so a literal "\\u0000" in a pattern is not treated as an escape.
+func translateUnicodeEscapes(pattern string) string {
+	if !strings.Contains(pattern, `\u`) {
+		return pattern
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(pattern); i++ {
+		if pattern[i] != '\\' || i+1 >= len(pattern) {
+			b.WriteByte(pattern[i])
+			continue
+		}
+		if pattern[i+1] == 'u' && i+6 <= len(pattern) && isHex(pattern[i+2:i+6]) {
+			b.WriteString(`\x{` + pattern[i+2:i+6] + `}`)
+			i += 5
+			continue
+		}
+		if strings.HasPrefix(pattern[i+1:], "u{") {
+			if end := strings.IndexByte(pattern[i+3:], '}'); end >= 0 {
+				if points := strings.Fields(pattern[i+3 : i+3+end]); len(points) > 0 && allHex(points) {
+					for _, point := range points {
+						b.WriteString(`\x{` + point + `}`)
+					}
+					i += 3 + end
+					continue
+				}
+			}
+		}
+		// Copy any other escape sequence as-is
+		b.WriteString(pattern[i : i+2])
+		i++
+	}
+	return b.String()
+}
+
+// translatePossessive rewrites Ruby possessive quantifiers (a++) and atomic groups
+// ((?>a)) to greedy quantifiers and non-capturing groups. RE2 does not backtrack
+// into these constructs differently, so the rewrite preserves behavior when the
+// construct cannot give back characters to what follows it: at the end of the
+// pattern or an alternative, before an anchor, or before a token matching none of
+// the characters the quantified atom matches (case-folded when fold is set or the
+// pattern has an inline i flag). Any other use returns an error. Quoted \Q...\E
+// spans are copied unchanged. A description of each rewrite is returned for logging.
+func translatePossessive(pattern string, fold bool) (string, []string, error) {
+	fold = fold || inlineFoldPattern.MatchString(pattern)
+
+	type group struct {
+		start  int
+		atomic bool
+	}
+
+	var b strings.Builder
+	var notes []string
+	var stack []group
+	for i := 0; i < len(pattern); {
+		start := i
+		isGroup := false
+		switch c := pattern[i]; c {
+		case '\\':
+			i = quoteEnd(pattern, i)
+		case '[':
+			i = classEnd(pattern, i)
+		case '(':
+			if strings.HasPrefix(pattern[i:], "(?>") {
+				stack = append(stack, group{start: i, atomic: true})
+				b.WriteString("(?:")
+				i += 3
+				continue
+			}
+			stack = append(stack, group{start: i})
+			b.WriteByte(c)
+			i++
+			continue
+		case ')':
+			i++
+			if len(stack) > 0 {
+				g := stack[len(stack)-1]
+				stack = stack[:len(stack)-1]
+				start = g.start
+				isGroup = true
+				if g.atomic {
+					if hasBacktracking(pattern[g.start+3:i-1]) && !isTerminal(pattern[i:]) {
+						return "", nil, fmt.Errorf("atomic group %s at offset %d has no RE2 equivalent", pattern[g.start:i], g.start)
+					}
+					notes = append(notes, fmt.Sprintf("atomic group %s converted to a non-capturing group", pattern[g.start:i]))
+				}
+			}
+			b.WriteByte(c)
+		case '|', '^', '$':
+			b.WriteByte(c)
+			i++
+			continue
+		default:
+			_, size := utf8.DecodeRuneInString(pattern[i:])
+			i += size
+		}
+
+		if !isGroup && pattern[start] != ')' {
+			b.WriteString(pattern[start:i])
+		}
+
+		// Check for a quantifier following the atom
+		qend := quantifierEnd(pattern, i)
+		if qend == i {
+			continue
+		}
+		b.WriteString(pattern[i:qend])
+		if qend < len(pattern) && pattern[qend] == '+' {
+			atom := pattern[start:i]
+			if !isTerminal(pattern[qend+1:]) && (isGroup || !isDisjoint(atom, pattern[qend+1:], fold)) {
+				return "", nil, fmt.Errorf("possessive quantifier %s at offset %d has no RE2 equivalent", pattern[start:qend+1], start)
+			}
+			notes = append(notes, fmt.Sprintf("possessive quantifier %s converted to %s", pattern[start:qend+1], pattern[start:qend]))
+			qend++
+		} else if qend < len(pattern) && pattern[qend] == '?' {
+			b.WriteByte('?')
+			qend++
+		}
+		i = qend
+	}
+	return b.String(), notes, nil
+}
+
+// quoteEnd returns the index following a \Q...\E quoted span starting at i, which is
+// copied through unchanged, or following any other escape sequence
+func quoteEnd(pattern string, i int) int {
+	if !strings.HasPrefix(pattern[i:], `\Q`) {
+		return escapeEnd(pattern, i)
+	}
+	if end := strings.Index(pattern[i+2:], `\E`); end >= 0 {
+		return i + 2 + end + 2
+	}
+	return len(pattern)
+}
+
+// escapeEnd returns the index following the escape sequence starting at i
+func escapeEnd(pattern string, i int) int {
+	if i+1 >= len(pattern) {
+		return len(pattern)
+	}
+	switch pattern[i+1] {
+	case 'x', 'p', 'P':
+		if i+2 < len(pattern) && pattern[i+2] == '{' {
+			if end := strings.IndexByte(pattern[i:], '}'); end >= 0 {
+				return i + end + 1
+			}
+		}
+		if pattern[i+1] == 'x' && i+4 <= len(pattern) {
+			return i + 4
+		}
+	}
+	_, size := utf8.DecodeRuneInString(pattern[i+1:])
+	return i + 1 + size
+}
+
+// classEnd returns the index following the character class starting at i
+func classEnd(pattern string, i int) int {
+	depth := 0
+	for j := i; j < len(pattern); j++ {
+		switch pattern[j] {
+		case '\\':
+			j = escapeEnd(pattern, j) - 1
+		case '[':
+			depth++
+			// A closing bracket at the start of a class is a literal
+			if strings.HasPrefix(pattern[j+1:], "]") {
+				j++
+			} else if strings.HasPrefix(pattern[j+1:], "^]") {
+				j += 2
+			}
+		case ']':
+			depth--
+			if depth == 0 {
+				return j + 1
+			}
+		}
+	}
+	return len(pattern)
+}
+
+// quantifierEnd returns the index following a quantifier starting at i, or i if there is none
+func quantifierEnd(pattern string, i int) int {
+	if i >= len(pattern) {
+		return i
+	}
+	switch pattern[i] {
+	case '*', '+', '?':
+		return i + 1
+	case '{':
+		if m := repeatPattern.FindStringIndex(pattern[i:]); m != nil {
+			return i + m[1]
+		}
+	}
+	return i
+}
+
+var repeatPattern = regexp.MustCompile(`^\{\d+(?:,\d*)?\}`)
+
+// isTerminal reports whether nothing that follows a construct can consume the
+// characters it matched: the end of the pattern or alternative, or an end anchor
+func isTerminal(rest string) bool {
+	for strings.HasPrefix(rest, ")") {
+		rest = rest[1:]
+		if quantifierEnd(rest, 0) != 0 {
+			return false
+		}
+	}
+	return rest == "" || rest[0] == '|' || rest[0] == '$' || strings.HasPrefix(rest, `\z`) || strings.HasPrefix(rest, `\Z`)
+}
+
+// inlineFoldPattern matches a flag group that may enable case folding, such as (?i) or (?mi:
+var inlineFoldPattern = regexp.MustCompile(`\(\?[a-zA-Z-]*i`)
+
+// isDisjoint reports whether the tokens that can follow a quantified atom match
+// none of the characters the atom matches, so the atom could never give any back.
+// The full rune ranges of the parsed tokens are compared, case-folded when fold is set.
+func isDisjoint(atom string, rest string, fold bool) bool {
+	atomRanges, ok := tokenRanges(atom, fold)
+	if !ok {
+		return false
+	}
+
+	nexts, ok := followingTokens(rest)
+	if !ok {
+		return false
+	}
+	for _, next := range nexts {
+		nextRanges, ok := tokenRanges(next, fold)
+		if !ok {
+			return false
+		}
+		for i := 0; i < len(atomRanges); i += 2 {
+			for j := 0; j < len(nextRanges); j += 2 {
+				if atomRanges[i] <= nextRanges[j+1] && nextRanges[j] <= atomRanges[i+1] {
+					return false
+				}
+			}
+		}
+	}
+	return true
+}
+
+// tokenRanges returns the rune ranges, as lo-hi pairs, matched by a single-character
+// token such as a literal, escape, or character class. It returns false for any
+// other token.
+func tokenRanges(token string, fold bool) ([]rune, bool) {
+	flags := syntax.Perl
+	if fold {
+		flags |= syntax.FoldCase
+	}
+	re, err := syntax.Parse(token, flags)
+	if err != nil {
+		return nil, false
+	}
+	switch re.Op {
+	case syntax.OpLiteral:
+		if len(re.Rune) != 1 {
+			return nil, false
+		}
+		r := re.Rune[0]
+		ranges := []rune{r, r}
+		if re.Flags&syntax.FoldCase != 0 {
+			for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+				ranges = append(ranges, f, f)
+			}
+		}
+		return ranges, true
+	case syntax.OpCharClass:
+		return re.Rune, true
+	case syntax.OpAnyCharNotNL:
+		return []rune{0, '\n' - 1, '\n' + 1, unicode.MaxRune}, true
+	case syntax.OpAnyChar:
+		return []rune{0, unicode.MaxRune}, true
+	}
+	return nil, false
+}
+
+// followingTokens returns the single-character tokens that can start a match of
+// rest, stepping out of closed groups and into the alternatives of opened groups.
+// It returns false when these cannot be determined, such as when a token may match
+// nothing or is followed by a quantifier that allows zero repetitions.
+func followingTokens(rest string) ([]string, bool) {
+	for strings.HasPrefix(rest, ")") {
+		rest = rest[1:]
+		if quantifierEnd(rest, 0) != 0 {
+			return nil, false
+		}
+	}
+	if isTerminal(rest) {
+		return nil, true
+	}
+
+	var end int
+	switch rest[0] {
+	case '\\':
+		end = escapeEnd(rest, 0)
+	case '[':
+		end = classEnd(rest, 0)
+	case '(':
+		end = groupEnd(rest, 0)
+	case '.', '|', '^', '*', '+', '?', '{':
+		return nil, false
+	default:
+		_, end = utf8.DecodeRuneInString(rest)
+	}
+	if q := quantifierEnd(rest, end); q != end && (rest[end] == '*' || rest[end] == '?' || strings.HasPrefix(rest[end:], "{0")) {
+		return nil, false
+	}
+	if rest[0] != '(' {
+		// Word boundaries and other zero-width escapes do not consume characters
+		if strings.ContainsRune("bBAG", rune(rest[end-1])) && end == 2 {
+			return nil, false
+		}
+		return []string{rest[:end]}, true
+	}
+
+	// Collect the first tokens of each alternative in the group
+	content := rest[1 : end-1]
+	if strings.HasPrefix(content, "?") {
+		if strings.HasPrefix(content, "?:") {
+			content = content[2:]
+		} else if strings.HasPrefix(content, "?<") && strings.Contains(content, ">") {
+			content = content[strings.IndexByte(content, '>')+1:]
+		} else {
+			return nil, false
+		}
+	}
+	var ret []string
+	for _, alt := range splitAlternatives(content) {
+		if alt == "" {
+			return nil, false
+		}
+		tokens, ok := followingTokens(alt)
+		if !ok {
+			return nil, false
+		}
+		ret = append(ret, tokens...)
+	}
+	return ret, true
+}
+
+// groupEnd returns the index following the group starting at i
+func groupEnd(pattern string, i int) int {
+	depth := 0
+	for j := i; j < len(pattern); j++ {
+		switch pattern[j] {
+		case '\\':
+			j = escapeEnd(pattern, j) - 1
+		case '[':
+			j = classEnd(pattern, j) - 1
+		case '(':
+			depth++
+		case ')':
+			depth--
+			if depth == 0 {
+				return j + 1
+			}
+		}
+	}
+	return len(pattern)
+}
+
+// splitAlternatives splits a subpattern on alternation outside of nested groups and classes
+func splitAlternatives(sub string) []string {
+	var ret []string
+	start := 0
+	for i := 0; i < len(sub); i++ {
+		switch sub[i] {
+		case '\\':
+			i = escapeEnd(sub, i) - 1
+		case '[':
+			i = classEnd(sub, i) - 1
+		case '(':
+			i = groupEnd(sub, i) - 1
+		case '|':
+			ret = append(ret, sub[start:i])
+			start = i + 1
+		}
+	}
+	return append(ret, sub[start:])
+}
+
+// hasBacktracking reports whether a subpattern contains quantifiers or alternation,
+// which an atomic group would prevent from backtracking
+func hasBacktracking(sub string) bool {
+	for i := 0; i < len(sub); i++ {
+		switch sub[i] {
+		case '\\':
+			i = escapeEnd(sub, i) - 1
+		case '[':
+			i = classEnd(sub, i) - 1
+		case '*', '+', '?', '{', '|':
+			// A '?' directly after an opening parenthesis is a group modifier
+			if sub[i] == '?' && i > 0 && sub[i-1] == '(' {
+				continue
+			}
+			return true
+		}
+	}
+	return false
+}
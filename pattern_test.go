@@ -0,0 +1,214 @@
+package recog
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestTranslateUnicodeEscapes(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		expected string
+	}{
+		{`^foo$`, `^foo$`},
+		{`^\u0000\xff`, `^\x{0000}\xff`},
+		{`^\u00e9t\u00E9$`, `^\x{00e9}t\x{00E9}$`},
+		{`[\u0041-\u005a]+`, `[\x{0041}-\x{005a}]+`},
+		{`^\\u0000`, `^\\u0000`},
+		{`^\\\u0000`, `^\\\x{0000}`},
+		{`^\u00`, `^\u00`},
+		{`^\u00zz`, `^\u00zz`},
+		{`^\u{e9}`, `^\x{e9}`},
+		{`^\u{1F600}$`, `^\x{1F600}$`},
+		{`^\u{48 49}`, `^\x{48}\x{49}`},
+		{`[\u{1F300}-\u{1F5FF}]`, `[\x{1F300}-\x{1F5FF}]`},
+		{`^\\u{41}`, `^\\u{41}`},
+		{`^\u{zz}`, `^\u{zz}`},
+		{`^\u{1234567}`, `^\u{1234567}`},
+	}
+	for _, tc := range tests {
+		if got := translateUnicodeEscapes(tc.pattern); got != tc.expected {
+			t.Errorf("translateUnicodeEscapes(%q) = %q, expected %q", tc.pattern, got, tc.expected)
+		}
+	}
+}
+
+func TestUnicodeEscapeMatch(t *testing.T) {
+	tests := []struct {
+		pattern string
+		data    string
+		matched bool
+	}{
+		{`^\u0000\u0001login`, "\x00\x01login", true},
+		{`^caf\u00e9$`, "café", true},
+		{`^\u2603 snow`, "☃ snow", true},
+		{`^\u{1F600}$`, "😀", true},
+		{`^smile [\u{1F600}-\u{1F64F}]+$`, "smile 😀😃", true},
+		{`^\u{48 49}$`, "HI", true},
+		{`^\u{1F600}$`, `\u{1F600}`, false},
+		{`^\\u0000$`, `\u0000`, true},
+		{`^\\u0000$`, "\x00", false},
+	}
+	for _, tc := range tests {
+		fp := &Fingerprint{Pattern: tc.pattern}
+		if err := fp.Normalize(); err != nil {
+			t.Fatalf("Normalize(%q) failed: %s", tc.pattern, err)
+		}
+		if fp.Pattern != tc.pattern {
+			t.Errorf("Normalize() modified the pattern %q to %q", tc.pattern, fp.Pattern)
+		}
+		if m := fp.Match(tc.data); m.Matched != tc.matched {
+			t.Errorf("%q matching %q = %v, expected %v", tc.pattern, tc.data, m.Matched, tc.matched)
+		}
+	}
+}
+
+func TestTranslatePossessive(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		expected string
+	}{
+		{`^foo$`, `^foo$`},
+		{`^\d++$`, `^\d+$`},
+		{`^\d++\.\d*+`, `^\d+\.\d*`},
+		{`^[a-z]++ v(\d+)`, `^[a-z]+ v(\d+)`},
+		{`^Server: (\w++)(?:/|$)`, `^Server: (\w+)(?:/|$)`},
+		{`^a{2,3}+b`, `^a{2,3}b`},
+		{`^a?+b|c++`, `^a?b|c+`},
+		{`^(?>foo)bar`, `^(?:foo)bar`},
+		{`^(?>\d+)$`, `^(?:\d+)$`},
+		{`^\d+?x \d+`, `^\d+?x \d+`},
+		{`^[+]+ \++$`, `^[+]+ \++$`},
+		{`^[+]++ \+++$`, `^[+]+ \++$`},
+		{`^[a-z]++\x{4e2d}`, `^[a-z]+\x{4e2d}`},
+		{`^\Qa++\E$`, `^\Qa++\E$`},
+	}
+	for _, tc := range tests {
+		got, notes, err := translatePossessive(tc.pattern, false)
+		if err != nil {
+			t.Errorf("translatePossessive(%q) failed: %s", tc.pattern, err)
+			continue
+		}
+		if got != tc.expected {
+			t.Errorf("translatePossessive(%q) = %q, expected %q", tc.pattern, got, tc.expected)
+		}
+		if got != tc.pattern && len(notes) == 0 {
+			t.Errorf("translatePossessive(%q) did not describe the conversion", tc.pattern)
+		}
+	}
+
+	for _, pattern := range []string{
+		`^\w++\d`,
+		`^.*+foo`,
+		`^(ab)++a`,
+		`^(?:a|b)++c`,
+		`^(?>\d+|x)\d`,
+		`^[a-z]++[m-z]`,
+		`^\d++(?:x|\d)`,
+		`^\d++\b`,
+		`^\d*+x?\d`,
+		`^[^a]++\x{4e2d}`,
+		`^\D++中`,
+	} {
+		if got, _, err := translatePossessive(pattern, false); err == nil {
+			t.Errorf("translatePossessive(%q) = %q, expected an error", pattern, got)
+		}
+	}
+}
+
+func TestTranslatePossessiveFold(t *testing.T) {
+	if got, _, err := translatePossessive(`^a++A`, false); err != nil || got != `^a+A` {
+		t.Errorf("translatePossessive(`^a++A`) = %q, %v", got, err)
+	}
+	for _, tc := range []struct {
+		pattern string
+		fold    bool
+	}{
+		{`(?i)^a++A`, false},
+		{`^(?i:a++A)`, false},
+		{`^[a-c]++B`, true},
+	} {
+		if got, _, err := translatePossessive(tc.pattern, tc.fold); err == nil {
+			t.Errorf("translatePossessive(%q) = %q, expected an error when folding case", tc.pattern, got)
+		}
+	}
+	if got, _, err := translatePossessive(`(?i)^a++\d`, false); err != nil || got != `(?i)^a+\d` {
+		t.Errorf("translatePossessive(`(?i)^a++\\d`) = %q, %v", got, err)
+	}
+
+	fp := &Fingerprint{Pattern: `^a++A`, Flags: "REG_ICASE"}
+	if err := fp.Normalize(); err == nil {
+		t.Errorf("Normalize() should fail for a possessive quantifier overlapping a case-folded token")
+	}
+	fp = &Fingerprint{Pattern: `^a++A`}
+	if err := fp.Normalize(); err != nil {
+		t.Errorf("Normalize() failed: %s", err)
+	}
+}
+
+func TestPossessiveNormalize(t *testing.T) {
+	fp := &Fingerprint{Pattern: `^Acme/(\d++)\.(\d++)$`, Params: []*FingerprintParam{{Position: "1", Name: "a"}, {Position: "2", Name: "b"}}}
+	if err := fp.Normalize(); err != nil {
+		t.Fatalf("Normalize() failed: %s", err)
+	}
+	if m := fp.Match("Acme/12.34"); !m.Matched || m.Values["a"] != "12" || m.Values["b"] != "34" {
+		t.Errorf("possessive pattern failed to match: %#v", m)
+	}
+
+	fp = &Fingerprint{Pattern: `^\Qa++\E$`}
+	if err := fp.Normalize(); err != nil || !fp.Match("a++").Matched {
+		t.Errorf("Normalize() should keep quoted spans: %v", err)
+	}
+
+	fp = &Fingerprint{Pattern: `^\w++\d`, Description: &FingerprintDescription{Text: "Unsupported"}}
+	err := fp.Normalize()
+	if err == nil || !strings.Contains(err.Error(), "Unsupported") {
+		t.Errorf("Normalize() should fail naming the fingerprint: %v", err)
+	}
+}
+
+func TestTranslateExtended(t *testing.T) {
+	tests := []struct {
+		pattern  string
+		extended bool
+		expected string
+	}{
+		{`^foo bar$`, false, `^foo bar$`},
+		{`^foo bar$`, true, `^foobar$`},
+		{`(?x)^foo \s+ bar$`, false, `^foo\s+bar$`},
+		{`(?mx)^foo bar$`, false, `(?m)^foobar$`},
+		{`(?m)^foo bar$`, false, `(?m)^foo bar$`},
+		{"^foo # comment\n(bar)$", true, `^foo(bar)$`},
+		{`^foo\ bar\#[ #]$`, true, `^foo\ bar\#[ #]$`},
+		{`^foo # trailing comment`, true, `^foo`},
+	}
+	for _, tc := range tests {
+		if got := translateExtended(tc.pattern, tc.extended); got != tc.expected {
+			t.Errorf("translateExtended(%q, %v) = %q, expected %q", tc.pattern, tc.extended, got, tc.expected)
+		}
+	}
+}
+
+func TestExtendedNormalize(t *testing.T) {
+	pattern := `^Acme \s+ Server       # product name
+	  /(\d+)\.(\d+)              # major.minor version
+	  [ ]build$`
+	for _, tc := range []struct {
+		pattern string
+		flags   string
+	}{
+		{pattern, "REG_EXTENDED"},
+		{"(?x)" + pattern, ""},
+	} {
+		fp := &Fingerprint{Pattern: tc.pattern, Flags: tc.flags, Params: []*FingerprintParam{{Position: "1", Name: "a"}, {Position: "2", Name: "b"}}}
+		if err := fp.Normalize(); err != nil {
+			t.Fatalf("Normalize() failed: %s", err)
+		}
+		if m := fp.Match("Acme  Server/1.2 build"); !m.Matched || m.Values["a"] != "1" || m.Values["b"] != "2" {
+			t.Errorf("extended pattern with flags %q failed to match: %#v", tc.flags, m)
+		}
+		if m := fp.Match("Acme Server/1.2build"); m.Matched {
+			t.Errorf("extended pattern with flags %q should keep the escaped space", tc.flags)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package recog
+
+import (
+	"regexp"
+	"sync"
+	"sync/atomic"
+)
+
+// patternCache shares compiled patterns between fingerprints, see SetPatternCache
+var patternCache struct {
+	sync.Mutex
+	// enabled is read atomically so compiles skip the lock while the cache is off
+	enabled  int32
+	compiled map[string]*regexp.Regexp
+	hits     int
+}
+
+// SetPatternCache enables or disables a process-wide cache of compiled patterns.
+// While enabled, fingerprints normalized with identical patterns and flags, such as
+// rules repeated across databases, share one compiled *regexp.Regexp. Compiled patterns
+// are safe for concurrent use, so sharing them is not observable. Disabling the cache
+// releases the patterns it holds. The cache is disabled by default.
+func SetPatternCache(enabled bool) {
+	patternCache.Lock()
+	defer patternCache.Unlock()
+	flag := int32(0)
+	if enabled {
+		flag = 1
+	}
+	atomic.StoreInt32(&patternCache.enabled, flag)
+	patternCache.compiled = nil
+	patternCache.hits = 0
+}
+
+// PatternCacheStats returns the number of compiled patterns held by the cache and the
+// number of compiles it has saved since it was enabled
+func PatternCacheStats() (patterns int, hits int) {
+	patternCache.Lock()
+	defer patternCache.Unlock()
+	return len(patternCache.compiled), patternCache.hits
+}
+
+// compileCached compiles a normalized expression, sharing the result when the cache is enabled
+func compileCached(expr string) (*regexp.Regexp, error) {
+	if atomic.LoadInt32(&patternCache.enabled) == 0 {
+		return regexp.Compile(expr)
+	}
+
+	patternCache.Lock()
+	if re, ok := patternCache.compiled[expr]; ok {
+		patternCache.hits++
+		patternCache.Unlock()
+		return re, nil
+	}
+	patternCache.Unlock()
+
+	// Compile without holding the lock, a concurrent compile of the same pattern is harmless
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, err
+	}
+
+	patternCache.Lock()
+	defer patternCache.Unlock()
+	if atomic.LoadInt32(&patternCache.enabled) == 0 {
+		return re, nil
+	}
+	if cached, ok := patternCache.compiled[expr]; ok {
+		patternCache.hits++
+		return cached, nil
+	}
+	if patternCache.compiled == nil {
+		patternCache.compiled = make(map[string]*regexp.Regexp)
+	}
+	patternCache.compiled[expr] = re
+	return re, nil
+}
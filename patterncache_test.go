@@ -0,0 +1,70 @@
+package recog
+
+import (
+	"runtime"
+	"testing"
+)
+
+func TestPatternCache(t *testing.T) {
+	SetPatternCache(true)
+	defer SetPatternCache(false)
+
+	newFingerprint := func(flags string) *Fingerprint {
+		fp := &Fingerprint{Pattern: `^Acme Server v(\d+)$`, Flags: flags, Params: []*FingerprintParam{{Position: "1", Name: "service.version"}}}
+		if err := fp.Normalize(); err != nil {
+			t.Fatalf("Normalize() failed: %s", err)
+		}
+		return fp
+	}
+
+	a, b := newFingerprint(""), newFingerprint("")
+	if a.PatternCompiled != b.PatternCompiled {
+		t.Errorf("identical patterns were not shared")
+	}
+	if c := newFingerprint("REG_ICASE"); c.PatternCompiled == a.PatternCompiled {
+		t.Errorf("patterns with different flags were shared")
+	}
+	if patterns, hits := PatternCacheStats(); patterns != 2 || hits != 1 {
+		t.Errorf("PatternCacheStats() = %d, %d, expected 2, 1", patterns, hits)
+	}
+	if m := b.Match("Acme Server v2"); m.Values["service.version"] != "2" {
+		t.Errorf("Match() with a shared pattern returned %v", m.Values)
+	}
+
+	SetPatternCache(false)
+	if d := newFingerprint(""); d.PatternCompiled == a.PatternCompiled {
+		t.Errorf("patterns were shared with the cache disabled")
+	}
+}
+
+func benchmarkLoadPatternCache(b *testing.B, enabled bool) {
+	var fset *FingerprintSet
+	for i := 0; i < b.N; i++ {
+		SetPatternCache(enabled)
+		var before, after runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+
+		var err error
+		fset, err = LoadFingerprints()
+		if err != nil {
+			b.Fatalf("LoadFingerprints() failed: %s", err)
+		}
+
+		runtime.GC()
+		runtime.ReadMemStats(&after)
+		b.ReportMetric(float64(after.HeapAlloc-before.HeapAlloc), "heap-bytes")
+		_, hits := PatternCacheStats()
+		b.ReportMetric(float64(hits), "shared")
+	}
+	runtime.KeepAlive(fset)
+	SetPatternCache(false)
+}
+
+func BenchmarkLoadPatternCacheDisabled(b *testing.B) {
+	benchmarkLoadPatternCache(b, false)
+}
+
+func BenchmarkLoadPatternCacheEnabled(b *testing.B) {
+	benchmarkLoadPatternCache(b, true)
+}
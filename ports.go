@@ -0,0 +1,77 @@
+package recog
+
+import (
+	"strings"
+)
+
+// PortKey identifies a transport protocol and port number
+type PortKey struct {
+	Port  int
+	Proto string
+}
+
+var (
+	httpDatabases = []string{"http_header.server", "http_header.cookie", "http_header.wwwauth", "html_title"}
+	tlsDatabases  = []string{"x509.subject", "x509.issuer", "tls.jarm"}
+)
+
+// PortDatabases maps well-known ports to the names of the databases that match their
+// banners, in the order they should be consulted. The names are "matches" attributes,
+// or file names for databases without one. Callers may add or replace entries during
+// initialization, or use RegisterPortDatabases; the map is not safe for concurrent
+// modification.
+var PortDatabases = map[PortKey][]string{
+	{21, "tcp"}:   {"ftp.banner"},
+	{22, "tcp"}:   {"ssh.banner"},
+	{23, "tcp"}:   {"telnet_banners.xml"},
+	{25, "tcp"}:   {"smtp.banner"},
+	{53, "tcp"}:   {"dns.versionbind"},
+	{53, "udp"}:   {"dns.versionbind"},
+	{67, "udp"}:   {"dhcp_vendor_class"},
+	{80, "tcp"}:   httpDatabases,
+	{110, "tcp"}:  {"pop3.banner"},
+	{119, "tcp"}:  {"nntp.banner"},
+	{123, "udp"}:  {"ntp.readvar"},
+	{139, "tcp"}:  {"smb.native_os", "smb.native_lm"},
+	{143, "tcp"}:  {"imap4.banner"},
+	{161, "udp"}:  {"snmp.sys_description", "snmp.sys_object_id"},
+	{389, "tcp"}:  {"ldap.search_result"},
+	{443, "tcp"}:  append(append([]string{}, httpDatabases...), tlsDatabases...),
+	{445, "tcp"}:  {"smb.native_os", "smb.native_lm"},
+	{465, "tcp"}:  append([]string{"smtp.banner"}, tlsDatabases...),
+	{554, "tcp"}:  {"rtsp_header.server"},
+	{587, "tcp"}:  {"smtp.banner"},
+	{993, "tcp"}:  append([]string{"imap4.banner"}, tlsDatabases...),
+	{995, "tcp"}:  append([]string{"pop3.banner"}, tlsDatabases...),
+	{3306, "tcp"}: {"mysql.banners", "mysql.error"},
+	{5060, "tcp"}: {"sip_header.server", "sip_header.user_agent"},
+	{5060, "udp"}: {"sip_header.server", "sip_header.user_agent"},
+	{5353, "udp"}: {"mdns.device-info.txt", "mdns.workstation.txt"},
+	{6000, "tcp"}: {"x11.vendor"},
+	{8080, "tcp"}: httpDatabases,
+	{8443, "tcp"}: append(append([]string{}, httpDatabases...), tlsDatabases...),
+	{9100, "tcp"}: {"hp_pjl_id.xml"},
+}
+
+// DatabaseForPort returns the names of the databases for banners from a port, such
+// as ssh.banner for 22/tcp, for use with FingerprintSet.MatchFirstIn. The proto is
+// compared case-insensitively and an empty proto is treated as tcp.
+func DatabaseForPort(port int, proto string) []string {
+	names := PortDatabases[portKey(port, proto)]
+	return append([]string{}, names...)
+}
+
+// RegisterPortDatabases appends database names to the PortDatabases entry for a port
+func RegisterPortDatabases(port int, proto string, names ...string) {
+	key := portKey(port, proto)
+	PortDatabases[key] = append(DatabaseForPort(port, proto), names...)
+}
+
+// portKey returns the PortDatabases key for a port and protocol
+func portKey(port int, proto string) PortKey {
+	proto = strings.ToLower(proto)
+	if proto == "" {
+		proto = "tcp"
+	}
+	return PortKey{Port: port, Proto: proto}
+}
@@ -0,0 +1,68 @@
+package recog
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestDatabaseForPort(t *testing.T) {
+	tests := []struct {
+		port     int
+		proto    string
+		expected []string
+	}{
+		{22, "tcp", []string{"ssh.banner"}},
+		{22, "", []string{"ssh.banner"}},
+		{21, "TCP", []string{"ftp.banner"}},
+		{80, "tcp", []string{"http_header.server", "http_header.cookie", "http_header.wwwauth", "html_title"}},
+		{161, "udp", []string{"snmp.sys_description", "snmp.sys_object_id"}},
+		{161, "tcp", []string{}},
+		{12345, "tcp", []string{}},
+	}
+	for _, tc := range tests {
+		if got := DatabaseForPort(tc.port, tc.proto); !reflect.DeepEqual(got, tc.expected) {
+			t.Errorf("DatabaseForPort(%d, %q) = %v, expected %v", tc.port, tc.proto, got, tc.expected)
+		}
+	}
+
+	if got := DatabaseForPort(443, "tcp"); len(got) == 0 || got[0] != "http_header.server" {
+		t.Errorf("DatabaseForPort(443) = %v, expected the HTTP databases first", got)
+	}
+}
+
+func TestPortDatabasesExist(t *testing.T) {
+	fset, err := LoadFingerprints(WithLazyCompile())
+	if err != nil {
+		t.Fatalf("LoadFingerprints() failed: %s", err)
+	}
+	for key, names := range PortDatabases {
+		for _, name := range names {
+			if _, ok := fset.Databases[name]; !ok {
+				t.Errorf("%d/%s refers to missing database %s", key.Port, key.Proto, name)
+			}
+		}
+	}
+
+	name, m := fset.MatchFirstIn(DatabaseForPort(22, "tcp"), "OpenSSH_7.4")
+	if name != "ssh.banner" || !m.Matched {
+		t.Errorf("MatchFirstIn() failed to match the database for port 22: %s %#v", name, m)
+	}
+}
+
+func TestRegisterPortDatabases(t *testing.T) {
+	key := PortKey{Port: 2222, Proto: "tcp"}
+	defer delete(PortDatabases, key)
+
+	RegisterPortDatabases(2222, "TCP", "ssh.banner")
+	RegisterPortDatabases(2222, "tcp", "telnet_banners.xml")
+	if got := DatabaseForPort(2222, "tcp"); !reflect.DeepEqual(got, []string{"ssh.banner", "telnet_banners.xml"}) {
+		t.Errorf("DatabaseForPort(2222) = %v after registering databases", got)
+	}
+
+	// Changing the result must not modify the mapping
+	got := DatabaseForPort(22, "tcp")
+	got[0] = "modified"
+	if DatabaseForPort(22, "tcp")[0] != "ssh.banner" {
+		t.Errorf("DatabaseForPort() returned the mapping instead of a copy")
+	}
+}
@@ -0,0 +1,75 @@
+package recog
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+// FingerprintTiming is the time spent matching a fingerprint, see FingerprintDB.MatchProfile
+type FingerprintTiming struct {
+	Fingerprint *Fingerprint
+	// Calls is the number of inputs the fingerprint was matched against
+	Calls int
+	// Total and Max are the total and the longest time spent on a single input
+	Total time.Duration
+	Max   time.Duration
+}
+
+// matchProfile accumulates the FingerprintTiming of each fingerprint of a database
+type matchProfile struct {
+	mu      sync.Mutex
+	timings map[*Fingerprint]*FingerprintTiming
+}
+
+// record adds the time since start to the timing of a fingerprint
+func (p *matchProfile) record(f *Fingerprint, start time.Time) {
+	d := time.Since(start)
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	t, ok := p.timings[f]
+	if !ok {
+		t = &FingerprintTiming{Fingerprint: f}
+		p.timings[f] = t
+	}
+	t.Calls++
+	t.Total += d
+	if d > t.Max {
+		t.Max = d
+	}
+}
+
+// EnableMatchProfile starts recording the time spent matching each fingerprint with
+// MatchFirst, MatchAll, and the other methods that match fingerprints one at a time,
+// discarding any earlier profile. Profiling is off by default to avoid the overhead.
+// It must not be enabled while the database is being matched.
+func (fdb *FingerprintDB) EnableMatchProfile() {
+	fdb.profile = &matchProfile{timings: make(map[*Fingerprint]*FingerprintTiming)}
+}
+
+// DisableMatchProfile stops recording and discards the profile
+func (fdb *FingerprintDB) DisableMatchProfile() {
+	fdb.profile = nil
+}
+
+// MatchProfile returns the timing of each fingerprint matched since EnableMatchProfile,
+// slowest first by total time, or nil when profiling is not enabled
+func (fdb *FingerprintDB) MatchProfile() []FingerprintTiming {
+	if fdb.profile == nil {
+		return nil
+	}
+	fdb.profile.mu.Lock()
+	ret := make([]FingerprintTiming, 0, len(fdb.profile.timings))
+	for _, t := range fdb.profile.timings {
+		ret = append(ret, *t)
+	}
+	fdb.profile.mu.Unlock()
+
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Total != ret[j].Total {
+			return ret[i].Total > ret[j].Total
+		}
+		return ret[i].Fingerprint.Pattern < ret[j].Fingerprint.Pattern
+	})
+	return ret
+}
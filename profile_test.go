@@ -0,0 +1,56 @@
+package recog
+
+import (
+	"testing"
+)
+
+func TestMatchProfile(t *testing.T) {
+	xmlData := `<fingerprints matches="test">
+  <fingerprint pattern="^Acme Server v(\d+)$">
+    <description>Acme</description>
+    <param pos="1" name="service.version"/>
+  </fingerprint>
+  <fingerprint pattern="^Other (\d+)$">
+    <description>Other</description>
+    <param pos="1" name="service.version"/>
+  </fingerprint>
+  <fingerprint pattern="^Unused (\d+)$">
+    <description>Unused</description>
+    <param pos="1" name="service.version"/>
+  </fingerprint>
+</fingerprints>`
+	fdb, err := LoadFingerprintDB("test.xml", []byte(xmlData))
+	if err != nil {
+		t.Fatalf("LoadFingerprintDB() failed: %s", err)
+	}
+
+	fdb.MatchFirst("Acme Server v2")
+	if profile := fdb.MatchProfile(); profile != nil {
+		t.Errorf("MatchProfile() returned %d timings without profiling", len(profile))
+	}
+
+	fdb.EnableMatchProfile()
+	fdb.MatchFirst("Acme Server v2")
+	fdb.MatchFirst("Other 2")
+	fdb.MatchAll("Other 3")
+
+	calls := make(map[string]int)
+	for _, timing := range fdb.MatchProfile() {
+		if timing.Total < 0 || timing.Max > timing.Total {
+			t.Errorf("MatchProfile() recorded invalid durations for %s: %+v", timing.Fingerprint.Description.Text, timing)
+		}
+		calls[timing.Fingerprint.Description.Text] = timing.Calls
+	}
+	// MatchFirst stops at the first match, MatchAll evaluates every fingerprint
+	expected := map[string]int{"Acme": 3, "Other": 2, "Unused": 1}
+	for desc, n := range expected {
+		if calls[desc] != n {
+			t.Errorf("MatchProfile() recorded %d calls for %s, expected %d", calls[desc], desc, n)
+		}
+	}
+
+	fdb.DisableMatchProfile()
+	if profile := fdb.MatchProfile(); profile != nil {
+		t.Errorf("MatchProfile() returned %d timings after DisableMatchProfile()", len(profile))
+	}
+}
@@ -0,0 +1,123 @@
+package recog
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/xml"
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+)
+
+// Limits caps the resources an untrusted fingerprint database may use. A zero
+// value leaves that resource unlimited, except for MaxCaptureGroups which then
+// defaults to DefaultMaxCaptureGroups.
+type Limits struct {
+	// MaxFingerprints limits the number of fingerprints in the database
+	MaxFingerprints int
+	// MaxPatternLength limits the length of each pattern and anti-pattern
+	MaxPatternLength int
+	// MaxCaptureGroups limits the capture groups of each pattern
+	MaxCaptureGroups int
+	// MaxProgramSize limits the total number of instructions in the compiled
+	// regexp programs of every pattern and anti-pattern in the database
+	MaxProgramSize int
+}
+
+// LoadUntrustedFingerprintDB parses a Recog XML file from an untrusted source, such
+// as a user upload, and returns a FingerprintDB. Databases exceeding the limits are
+// rejected before they are used to match anything.
+func LoadUntrustedFingerprintDB(name string, xmlData []byte, limits Limits) (FingerprintDB, error) {
+	fdb := FingerprintDB{MaxCaptureGroups: limits.MaxCaptureGroups}
+	err := xml.Unmarshal(xmlData, &fdb)
+	if err != nil {
+		return fdb, err
+	}
+	fdb.Name = name
+	sum := sha256.Sum256(xmlData)
+	fdb.Checksum = hex.EncodeToString(sum[:])
+
+	// Check the sizes of the source before compiling anything
+	if limits.MaxFingerprints > 0 && len(fdb.Fingerprints) > limits.MaxFingerprints {
+		return fdb, fmt.Errorf("database %s has %d fingerprints, the limit is %d", name, len(fdb.Fingerprints), limits.MaxFingerprints)
+	}
+	if limits.MaxPatternLength > 0 {
+		for _, fp := range fdb.Fingerprints {
+			sources := append([]string{fp.Pattern}, fp.Patterns...)
+			for _, source := range append(sources, fp.AntiPatterns...) {
+				if len(source) > limits.MaxPatternLength {
+					return fdb, fmt.Errorf("regexp [%.40s...] has length %d, the limit is %d", source, len(source), limits.MaxPatternLength)
+				}
+			}
+		}
+	}
+
+	// Normalize enforces the capture group and program size limits as it compiles
+	if limits.MaxProgramSize > 0 {
+		fdb.budget = &programBudget{name: name, limit: limits.MaxProgramSize}
+	}
+	err = fdb.Normalize()
+	if err != nil {
+		return fdb, err
+	}
+	return fdb, nil
+}
+
+// programBudget tracks the program size of the patterns compiled for a database
+type programBudget struct {
+	name  string
+	limit int
+	used  int
+}
+
+// spend charges the program size of a pattern expression to the budget, failing
+// once the total exceeds the limit so no further patterns are compiled
+func (b *programBudget) spend(expr string) error {
+	b.used += exprProgramSize(expr)
+	if b.used > b.limit {
+		return fmt.Errorf("database %s exceeds the regexp program size limit of %d", b.name, b.limit)
+	}
+	return nil
+}
+
+// ProgramSize returns the total number of instructions in the compiled regexp
+// programs of the patterns and anti-patterns of the fingerprint, a measure of their
+// complexity that can be checked before patterns approach the limits of the regexp
+// package. Fingerprints whose patterns fail to compile have a size of zero.
+func (fp *Fingerprint) ProgramSize() int {
+	if err := fp.ensureCompiled(); err != nil {
+		return 0
+	}
+	matchers := fp.matchers
+	if matchers == nil {
+		matchers = []*regexp.Regexp{fp.PatternCompiled}
+	}
+
+	total := 0
+	for _, re := range matchers {
+		total += programSize(re)
+	}
+	for _, re := range fp.AntiPatternsCompiled {
+		total += programSize(re)
+	}
+	return total
+}
+
+// programSize returns the number of instructions in the compiled program of re
+func programSize(re *regexp.Regexp) int {
+	return exprProgramSize(re.String())
+}
+
+// exprProgramSize returns the number of instructions in the compiled program of an
+// expression generated from a parsed pattern, which holds all of its flags
+func exprProgramSize(expr string) int {
+	parsed, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return 0
+	}
+	prog, err := syntax.Compile(parsed.Simplify())
+	if err != nil {
+		return 0
+	}
+	return len(prog.Inst)
+}
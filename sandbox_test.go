@@ -0,0 +1,124 @@
+package recog
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestLoadUntrustedFingerprintDB(t *testing.T) {
+	xmlData := `<fingerprints matches="test">
+  <fingerprint pattern="^Acme Server v(\d+)$">
+    <description>Acme server</description>
+    <param pos="1" name="service.version"/>
+  </fingerprint>
+  <fingerprint pattern="^Widget/(\d+)\.(\d+)\.(\d+)">
+    <description>Widget server</description>
+    <anti-pattern>Widget/0\.</anti-pattern>
+  </fingerprint>
+</fingerprints>`
+
+	fdb, err := LoadUntrustedFingerprintDB("test.xml", []byte(xmlData), Limits{
+		MaxFingerprints:  2,
+		MaxPatternLength: 64,
+		MaxCaptureGroups: 3,
+		MaxProgramSize:   1000,
+	})
+	if err != nil {
+		t.Fatalf("LoadUntrustedFingerprintDB() failed within the limits: %s", err)
+	}
+	if m := fdb.MatchFirst("Acme Server v2"); !m.Matched || m.Values["service.version"] != "2" {
+		t.Errorf("MatchFirst() failed: %#v", m)
+	}
+
+	tests := []struct {
+		limits   Limits
+		expected string
+	}{
+		{Limits{MaxFingerprints: 1}, "2 fingerprints, the limit is 1"},
+		{Limits{MaxPatternLength: 20}, "the limit is 20"},
+		{Limits{MaxCaptureGroups: 2}, "3 capture groups, the limit is 2"},
+		{Limits{MaxProgramSize: 20}, "program size limit of 20"},
+	}
+	for _, tc := range tests {
+		_, err := LoadUntrustedFingerprintDB("test.xml", []byte(xmlData), tc.limits)
+		if err == nil || !strings.Contains(err.Error(), tc.expected) {
+			t.Errorf("LoadUntrustedFingerprintDB(%+v) returned %v, expected %q", tc.limits, err, tc.expected)
+		}
+	}
+}
+
+func TestUntrustedProgramSizeStopsCompiling(t *testing.T) {
+	xmlData := `<fingerprints matches="test">
+  <fingerprint pattern="^Acme$"/>
+  <fingerprint pattern="^(?:Acme|Widget|Gadget) Server v(\d{1,3})\.(\d{1,3})(?:\.(\d+))?[a-z]{2,8}$"/>
+  <fingerprint pattern="^Broken ("/>
+</fingerprints>`
+	small := &Fingerprint{Pattern: "^Acme$"}
+	if err := small.Normalize(); err != nil {
+		t.Fatalf("Normalize() failed: %s", err)
+	}
+
+	// The oversized pattern is rejected before the broken pattern that follows it is compiled
+	fdb, err := LoadUntrustedFingerprintDB("test.xml", []byte(xmlData), Limits{MaxProgramSize: small.ProgramSize() + 1})
+	if err == nil || !strings.Contains(err.Error(), "program size limit") {
+		t.Fatalf("LoadUntrustedFingerprintDB() returned %v, expected a program size error", err)
+	}
+	if fdb.Fingerprints[0].PatternCompiled == nil || fdb.Fingerprints[1].PatternCompiled != nil || fdb.Fingerprints[2].PatternCompiled != nil {
+		t.Errorf("LoadUntrustedFingerprintDB() compiled patterns past the program size limit")
+	}
+}
+
+func TestUntrustedProgramSizeOptions(t *testing.T) {
+	xmlData := `<fingerprints matches="test">
+  <fingerprint pattern="^Acme Server v(\d+)$">
+    <anti-pattern>v0</anti-pattern>
+  </fingerprint>
+</fingerprints>`
+	fp := &Fingerprint{Pattern: `^Acme Server v(\d+)$`, AntiPatterns: []string{"v0"}}
+	if err := fp.Normalize(); err != nil {
+		t.Fatalf("Normalize() failed: %s", err)
+	}
+	fdb, err := LoadUntrustedFingerprintDB("test.xml", []byte(xmlData), Limits{MaxProgramSize: fp.ProgramSize()})
+	if err != nil {
+		t.Fatalf("LoadUntrustedFingerprintDB() failed within the limits: %s", err)
+	}
+
+	// Lazy compilation does not defer the limit to the first match
+	fdb.LazyCompile = true
+	if err := fdb.Normalize(); err != nil || fdb.Fingerprints[0].PatternCompiled == nil {
+		t.Errorf("Normalize() should compile an untrusted database with LazyCompile: %v", err)
+	}
+
+	// The case-insensitive variants count toward the limit
+	fdb.FoldCase = true
+	if err := fdb.Normalize(); err == nil || !strings.Contains(err.Error(), "program size limit") {
+		t.Errorf("Normalize() with FoldCase returned %v, expected a program size error", err)
+	}
+	fdb.budget.limit = 2 * fp.ProgramSize()
+	if err := fdb.Normalize(); err != nil {
+		t.Errorf("Normalize() with FoldCase failed within the limits: %s", err)
+	}
+}
+
+func TestProgramSize(t *testing.T) {
+	simple := &Fingerprint{Pattern: "^Acme$"}
+	complex := &Fingerprint{Pattern: `^(?:Acme|Widget|Gadget) Server v(\d{1,3})\.(\d{1,3})(?:\.(\d+))?[a-z]{2,8}$`}
+	for _, fp := range []*Fingerprint{simple, complex} {
+		if err := fp.Normalize(); err != nil {
+			t.Fatalf("Normalize() failed: %s", err)
+		}
+	}
+	if simple.ProgramSize() == 0 || simple.ProgramSize() >= complex.ProgramSize() {
+		t.Errorf("ProgramSize() of %d for a simple pattern should be below %d for a complex pattern", simple.ProgramSize(), complex.ProgramSize())
+	}
+
+	// Anti-patterns add to the size
+	simple.AntiPatterns = []string{"Acme"}
+	size := simple.ProgramSize()
+	if err := simple.Normalize(); err != nil {
+		t.Fatalf("Normalize() failed: %s", err)
+	}
+	if simple.ProgramSize() <= size {
+		t.Errorf("ProgramSize() should include anti-patterns: %d <= %d", simple.ProgramSize(), size)
+	}
+}
@@ -0,0 +1,161 @@
+package recog
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"unicode/utf8"
+)
+
+// SessionRecord is one match call captured by a session recorder
+type SessionRecord struct {
+	// Call is "first" for MatchFirst and "all" for MatchAll
+	Call     string `json:"call"`
+	Database string `json:"database"`
+	// Input holds the input when it is valid UTF-8, otherwise InputBase64 holds it
+	Input       string          `json:"input"`
+	InputBase64 string          `json:"input_base64,omitempty"`
+	Results     []SessionResult `json:"results"`
+}
+
+// SessionResult is one match result of a recorded call
+type SessionResult struct {
+	Matched bool              `json:"matched"`
+	Values  map[string]string `json:"values,omitempty"`
+	Errors  []string          `json:"errors,omitempty"`
+}
+
+// data returns the recorded input
+func (r *SessionRecord) data() (string, error) {
+	if r.InputBase64 == "" {
+		return r.Input, nil
+	}
+	data, err := base64.StdEncoding.DecodeString(r.InputBase64)
+	if err != nil {
+		return "", fmt.Errorf("invalid input_base64: %s", err)
+	}
+	return string(data), nil
+}
+
+// newSessionRecord captures a match call and its results
+func newSessionRecord(call string, name string, data string, matches []*FingerprintMatch) SessionRecord {
+	rec := SessionRecord{Call: call, Database: name, Input: data}
+	if !utf8.ValidString(data) {
+		rec.Input = ""
+		rec.InputBase64 = base64.StdEncoding.EncodeToString([]byte(data))
+	}
+	rec.Results = make([]SessionResult, 0, len(matches))
+	for _, m := range matches {
+		res := SessionResult{Matched: m.Matched, Values: m.Values}
+		for _, err := range m.Errors {
+			res.Errors = append(res.Errors, err.Error())
+		}
+		rec.Results = append(rec.Results, res)
+	}
+	return rec
+}
+
+// sessionRecorder writes session records as JSON lines
+type sessionRecorder struct {
+	mu  sync.Mutex
+	enc *json.Encoder
+}
+
+// WithRecorder records every MatchFirst and MatchAll call of the set to w as a JSON
+// line holding the database, the input, and the results, for use with Replay. A nil
+// writer stops recording.
+func (fs *FingerprintSet) WithRecorder(w io.Writer) *FingerprintSet {
+	fs.recorder = nil
+	if w != nil {
+		fs.recorder = &sessionRecorder{enc: json.NewEncoder(w)}
+	}
+	return fs
+}
+
+// record writes a match call to the recorder, if one is set
+func (fs *FingerprintSet) record(call string, name string, data string, matches []*FingerprintMatch) {
+	if fs.recorder == nil {
+		return
+	}
+	rec := newSessionRecord(call, name, data, matches)
+
+	fs.recorder.mu.Lock()
+	defer fs.recorder.mu.Unlock()
+	if err := fs.recorder.enc.Encode(rec); err != nil && fs.Logger != nil {
+		fs.Logger.Printf("[recog] failed to record match: %s", err)
+	}
+}
+
+// ReplayDiff is a recorded match call whose results differ when replayed
+type ReplayDiff struct {
+	// Line is the line number of the call in the recorded session
+	Line     int
+	Recorded SessionRecord
+	Replayed SessionRecord
+}
+
+// Replay re-runs each match call of a recorded session against the set and returns
+// the calls whose results differ from the recording. Replayed calls are not recorded.
+func (fs *FingerprintSet) Replay(r io.Reader) ([]ReplayDiff, error) {
+	ret := []ReplayDiff{}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 64*1024*1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+
+		var rec SessionRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return ret, fmt.Errorf("line %d: %s", line, err)
+		}
+		data, err := rec.data()
+		if err != nil {
+			return ret, fmt.Errorf("line %d: %s", line, err)
+		}
+
+		var matches []*FingerprintMatch
+		switch rec.Call {
+		case "first":
+			matches = []*FingerprintMatch{fs.matchFirst(rec.Database, data)}
+		case "all":
+			matches = fs.matchAll(rec.Database, data)
+		default:
+			return ret, fmt.Errorf("line %d: unknown call %q", line, rec.Call)
+		}
+
+		replayed := newSessionRecord(rec.Call, rec.Database, data, matches)
+		if !sameResults(rec.Results, replayed.Results) {
+			ret = append(ret, ReplayDiff{Line: line, Recorded: rec, Replayed: replayed})
+		}
+	}
+	return ret, scanner.Err()
+}
+
+// sameResults reports whether two sets of recorded results are equivalent
+func sameResults(a []SessionResult, b []SessionResult) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].Matched != b[i].Matched || len(a[i].Values) != len(b[i].Values) || len(a[i].Errors) != len(b[i].Errors) {
+			return false
+		}
+		for k, v := range a[i].Values {
+			if bv, ok := b[i].Values[k]; !ok || bv != v {
+				return false
+			}
+		}
+		for j := range a[i].Errors {
+			if a[i].Errors[j] != b[i].Errors[j] {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,67 @@
+package recog
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestRecordReplay(t *testing.T) {
+	newSet := func(version string) *FingerprintSet {
+		xmlData := `<fingerprints matches="test.banner">
+  <fingerprint pattern="^Acme Server v(\d+)">
+    <description>Acme server</description>
+    <param pos="1" name="service.version"/>
+    <param pos="0" name="service.product" value="` + version + `"/>
+  </fingerprint>
+</fingerprints>`
+		fdb, err := LoadFingerprintDB("test.xml", []byte(xmlData))
+		if err != nil {
+			t.Fatalf("LoadFingerprintDB() failed: %s", err)
+		}
+		fs := NewFingerprintSet()
+		fs.addDatabase(&fdb)
+		return fs
+	}
+
+	var session bytes.Buffer
+	fs := newSet("Acme").WithRecorder(&session)
+	fs.MatchFirst("test.banner", "Acme Server v2")
+	fs.MatchFirst("test.banner", "Widget\xff")
+	fs.MatchAll("test.banner", "Acme Server v3")
+	fs.MatchFirst("missing", "Acme Server v2")
+
+	lines := strings.Split(strings.TrimSpace(session.String()), "\n")
+	if len(lines) != 4 {
+		t.Fatalf("expected 4 recorded calls, got %d: %s", len(lines), session.String())
+	}
+	if !strings.Contains(lines[1], `"input_base64":"V2lkZ2V0/w=="`) {
+		t.Errorf("invalid UTF-8 input should be recorded as base64: %s", lines[1])
+	}
+
+	diffs, err := fs.Replay(bytes.NewReader(session.Bytes()))
+	if err != nil {
+		t.Fatalf("Replay() failed: %s", err)
+	}
+	if len(diffs) != 0 {
+		t.Errorf("Replay() against the same set should not differ: %#v", diffs)
+	}
+	if n := len(strings.Split(strings.TrimSpace(session.String()), "\n")); n != 4 {
+		t.Errorf("Replay() should not record the replayed calls, got %d lines", n)
+	}
+
+	diffs, err = newSet("Acme Corp").Replay(bytes.NewReader(session.Bytes()))
+	if err != nil {
+		t.Fatalf("Replay() failed: %s", err)
+	}
+	if len(diffs) != 2 || diffs[0].Line != 1 || diffs[1].Line != 3 {
+		t.Fatalf("Replay() should report the changed matches: %#v", diffs)
+	}
+	if diffs[0].Recorded.Results[0].Values["service.product"] != "Acme" || diffs[0].Replayed.Results[0].Values["service.product"] != "Acme Corp" {
+		t.Errorf("unexpected diff: %#v", diffs[0])
+	}
+
+	if _, err := fs.Replay(strings.NewReader(`{"call":"none"}`)); err == nil {
+		t.Errorf("Replay() should fail for an unknown call")
+	}
+}
@@ -0,0 +1,56 @@
+package recog
+
+import (
+	"strings"
+)
+
+// sniffRules recognize the banners of common protocols, returning the protocol as
+// used by the protocol attribute of the databases. Each rule looks only at the
+// start of the banner or its first line, and rules are tried in order.
+var sniffRules = []struct {
+	protocol string
+	match    func(data string, line string) bool
+}{
+	// SSH-2.0-OpenSSH_7.4
+	{"ssh", func(data string, line string) bool { return strings.HasPrefix(data, "SSH-") }},
+	// HTTP/1.1 200 OK
+	{"http", func(data string, line string) bool { return strings.HasPrefix(data, "HTTP/") }},
+	// RTSP/1.0 200 OK
+	{"rtsp", func(data string, line string) bool { return strings.HasPrefix(data, "RTSP/") }},
+	// SIP/2.0 200 OK
+	{"sip", func(data string, line string) bool { return strings.HasPrefix(data, "SIP/") }},
+	// 220 ProFTPD Server ready, or a multiline 220- greeting naming FTP
+	{"ftp", func(data string, line string) bool {
+		return is220(line) && strings.Contains(strings.ToUpper(line), "FTP")
+	}},
+	// 220 mail.example.com ESMTP Postfix
+	{"smtp", func(data string, line string) bool {
+		return is220(line) && strings.Contains(strings.ToUpper(line), "SMTP")
+	}},
+	// +OK Dovecot ready.
+	{"pop3", func(data string, line string) bool { return strings.HasPrefix(data, "+OK") }},
+	// * OK [CAPABILITY IMAP4rev1] Dovecot ready.
+	{"imap", func(data string, line string) bool { return strings.HasPrefix(data, "* OK") }},
+}
+
+// is220 reports whether line is a 220 greeting, as sent by FTP and SMTP servers
+func is220(line string) bool {
+	return strings.HasPrefix(line, "220 ") || strings.HasPrefix(line, "220-")
+}
+
+// SniffProtocol returns the likely protocol of a raw banner, such as "ssh" for
+// SSH-2.0-OpenSSH_7.4, or an empty string if it is not recognized. The protocol
+// names match the protocol attribute of the databases. Only a few unambiguous
+// banner prefixes are recognized, see sniffRules.
+func SniffProtocol(data string) string {
+	line := data
+	if i := strings.IndexAny(line, "\r\n"); i >= 0 {
+		line = line[:i]
+	}
+	for _, rule := range sniffRules {
+		if rule.match(data, line) {
+			return rule.protocol
+		}
+	}
+	return ""
+}
@@ -0,0 +1,67 @@
+package recog
+
+import (
+	"testing"
+)
+
+func TestSniffProtocol(t *testing.T) {
+	tests := []struct {
+		data     string
+		expected string
+	}{
+		{"SSH-2.0-OpenSSH_7.4", "ssh"},
+		{"SSH-1.99-Cisco-1.25", "ssh"},
+		{"HTTP/1.1 200 OK\r\nServer: Apache\r\n", "http"},
+		{"220 ProFTPD 1.3.5 Server (Debian) [::ffff:10.0.0.1]", "ftp"},
+		{"220-Welcome to Pure-FTPd\r\n220 You are user number 1", "ftp"},
+		{"220 mail.example.com ESMTP Postfix", "smtp"},
+		{"220 Microsoft FTP Service\r\n", "ftp"},
+		{"+OK Dovecot ready.", "pop3"},
+		{"* OK [CAPABILITY IMAP4rev1] Dovecot ready.", "imap"},
+		{"220 Welcome\r\nftp.example.com", ""},
+		{"OpenSSH_7.4", ""},
+		{"", ""},
+	}
+	for _, tc := range tests {
+		if got := SniffProtocol(tc.data); got != tc.expected {
+			t.Errorf("SniffProtocol(%q) = %q, expected %q", tc.data, got, tc.expected)
+		}
+	}
+}
+
+func TestMatchFirstOrderedSniffing(t *testing.T) {
+	newSet := func(opts ...Option) *FingerprintSet {
+		fs := NewFingerprintSet(opts...)
+		for _, xmlData := range []string{
+			`<fingerprints matches="generic.banner" preference="0.90">
+  <fingerprint pattern="^220 ">
+    <description>Generic greeting</description>
+  </fingerprint>
+</fingerprints>`,
+			`<fingerprints matches="ftp.banner" protocol="ftp" preference="0.50">
+  <fingerprint pattern="^220 ProFTPD">
+    <description>ProFTPD</description>
+  </fingerprint>
+</fingerprints>`,
+		} {
+			fdb, err := LoadFingerprintDB(databaseMatches([]byte(xmlData)), []byte(xmlData))
+			if err != nil {
+				t.Fatalf("LoadFingerprintDB() failed: %s", err)
+			}
+			fs.addDatabase(&fdb)
+		}
+		return fs
+	}
+
+	banner := "220 ProFTPD 1.3.5 Server"
+	if name, _ := newSet().MatchFirstOrdered(banner); name != "generic.banner" {
+		t.Errorf("MatchFirstOrdered() without sniffing matched %q, expected generic.banner", name)
+	}
+	fs := newSet(WithProtocolSniffing())
+	if name, _ := fs.MatchFirstOrdered(banner); name != "ftp.banner" {
+		t.Errorf("MatchFirstOrdered() with sniffing matched %q, expected ftp.banner", name)
+	}
+	if name, _ := fs.MatchFirstOrdered("220 Welcome"); name != "generic.banner" {
+		t.Errorf("MatchFirstOrdered() should fall back to the other databases, matched %q", name)
+	}
+}
@@ -0,0 +1,63 @@
+package recog
+
+import (
+	"crypto/md5"
+	"encoding/hex"
+	"strconv"
+	"strings"
+)
+
+// TLSClientHello holds the ClientHello fields used to build a JA3 fingerprint
+type TLSClientHello struct {
+	Version      uint16
+	Ciphers      []uint16
+	Extensions   []uint16
+	Curves       []uint16
+	PointFormats []uint16
+}
+
+// isGREASE reports whether v is a GREASE value (RFC 8701), which JA3 ignores
+func isGREASE(v uint16) bool {
+	return v&0x0f0f == 0x0a0a && v>>8 == v&0xff
+}
+
+// joinJA3 joins values with dashes, skipping GREASE values
+func joinJA3(values []uint16) string {
+	parts := make([]string, 0, len(values))
+	for _, v := range values {
+		if isGREASE(v) {
+			continue
+		}
+		parts = append(parts, strconv.Itoa(int(v)))
+	}
+	return strings.Join(parts, "-")
+}
+
+// JA3 returns the JA3 string of the ClientHello: the decimal version, ciphers,
+// extensions, curves, and point formats, with each list joined by dashes and the
+// fields joined by commas. GREASE values are omitted.
+func (h TLSClientHello) JA3() string {
+	return strings.Join([]string{
+		strconv.Itoa(int(h.Version)),
+		joinJA3(h.Ciphers),
+		joinJA3(h.Extensions),
+		joinJA3(h.Curves),
+		joinJA3(h.PointFormats),
+	}, ",")
+}
+
+// JA3Hash returns the hex-encoded MD5 hash of the JA3 string
+func (h TLSClientHello) JA3Hash() string {
+	sum := md5.Sum([]byte(h.JA3()))
+	return hex.EncodeToString(sum[:])
+}
+
+// MatchTLS matches the JA3 string of a ClientHello against the named database,
+// falling back to the JA3 hash, so fingerprints may be written for either form
+func (fs *FingerprintSet) MatchTLS(name string, h TLSClientHello) *FingerprintMatch {
+	m := fs.MatchFirst(name, h.JA3())
+	if m.Matched || len(m.Errors) > 0 {
+		return m
+	}
+	return fs.MatchFirst(name, h.JA3Hash())
+}
@@ -0,0 +1,66 @@
+package recog
+
+import (
+	"testing"
+)
+
+func TestJA3(t *testing.T) {
+	h := TLSClientHello{
+		Version:      769,
+		Ciphers:      []uint16{0x0a0a, 47, 53, 5, 10, 49161, 49162, 49171, 49172, 50, 56, 19, 4},
+		Extensions:   []uint16{0, 10, 0x1a1a, 11},
+		Curves:       []uint16{23, 24, 25},
+		PointFormats: []uint16{0},
+	}
+	if got, expected := h.JA3(), "769,47-53-5-10-49161-49162-49171-49172-50-56-19-4,0-10-11,23-24-25,0"; got != expected {
+		t.Errorf("JA3() = %q, expected %q", got, expected)
+	}
+	if got, expected := h.JA3Hash(), "ada70206e40642a3e4461f35503241d5"; got != expected {
+		t.Errorf("JA3Hash() = %q, expected %q", got, expected)
+	}
+	if got := (TLSClientHello{Version: 771}).JA3(); got != "771,,,," {
+		t.Errorf("JA3() of an empty ClientHello = %q", got)
+	}
+}
+
+func TestMatchTLS(t *testing.T) {
+	xmlData := `<fingerprints matches="tls.ja3" protocol="tls">
+  <fingerprint pattern="^769,47-53-5-10-49161-49162-49171-49172-50-56-19-4,0-10-11,">
+    <description>Legacy client</description>
+    <param pos="0" name="service.product" value="Legacy"/>
+  </fingerprint>
+  <fingerprint pattern="^ea1e247991e541e39bf918cb7cfa5139$">
+    <description>Hashed client</description>
+    <param pos="0" name="service.product" value="Hashed"/>
+  </fingerprint>
+</fingerprints>`
+	fdb, err := LoadFingerprintDB("tls_ja3.xml", []byte(xmlData))
+	if err != nil {
+		t.Fatalf("LoadFingerprintDB() failed: %s", err)
+	}
+	fset := NewFingerprintSet()
+	fset.addDatabase(&fdb)
+
+	legacy := TLSClientHello{
+		Version:      769,
+		Ciphers:      []uint16{47, 53, 5, 10, 49161, 49162, 49171, 49172, 50, 56, 19, 4},
+		Extensions:   []uint16{0, 10, 11},
+		Curves:       []uint16{23, 24, 25},
+		PointFormats: []uint16{0},
+	}
+	if m := fset.MatchTLS("tls.ja3", legacy); !m.Matched || m.Values["service.product"] != "Legacy" {
+		t.Errorf("MatchTLS() failed to match the JA3 string: %#v", m)
+	}
+
+	hashed := TLSClientHello{Version: 771, Ciphers: []uint16{4865}}
+	if hashed.JA3Hash() != "ea1e247991e541e39bf918cb7cfa5139" {
+		t.Fatalf("unexpected JA3 hash %s for %s", hashed.JA3Hash(), hashed.JA3())
+	}
+	if m := fset.MatchTLS("tls.ja3", hashed); !m.Matched || m.Values["service.product"] != "Hashed" {
+		t.Errorf("MatchTLS() failed to match the JA3 hash: %#v", m)
+	}
+
+	if m := fset.MatchTLS("no_such_db", legacy); m.Matched || len(m.Errors) == 0 {
+		t.Errorf("MatchTLS() should fail for a missing database: %#v", m)
+	}
+}
@@ -0,0 +1,73 @@
+package recog
+
+import (
+	"fmt"
+	"regexp/syntax"
+	"strings"
+)
+
+// Warnings reports likely authoring mistakes that Validate accepts, such as a pattern
+// using ^ or $ without (?m) while its examples span several lines. Patterns are
+// compiled with Ruby semantics, where ^ and $ match at every line and . stops at line
+// breaks unless (?m) is used, so such a pattern may match a different line than intended.
+func (fp *Fingerprint) Warnings() []string {
+	if err := fp.ensureCompiled(); err != nil {
+		return nil
+	}
+	if strings.Contains(fp.Flags, "REG_MULTILINE") || strings.Contains(fp.Flags, "REG_DOT_NEWLINE") || strings.Contains(fp.Flags, "REG_LINE_ANY_CRLF") {
+		return nil
+	}
+
+	// The matchers hold the pattern attribute, if any, followed by the pattern elements
+	sources := fp.Patterns
+	if fp.Pattern != "" || len(fp.Patterns) == 0 {
+		sources = append([]string{fp.Pattern}, fp.Patterns...)
+	}
+
+	var ret []string
+	for i, re := range fp.matchers {
+		source := sources[i]
+		if strings.HasPrefix(source, "(?m)") || !usesLineAnchors(re.String()) {
+			continue
+		}
+		for _, ex := range fp.Examples {
+			data, err := fp.exampleData(ex, "")
+			if err != nil || !strings.ContainsAny(strings.TrimRight(data, "\r\n"), "\r\n") {
+				continue
+			}
+			ret = append(ret, fmt.Sprintf("'%s' uses ^ or $ without (?m), but an example spans several lines and ^ and $ match at each line", source))
+			break
+		}
+	}
+	return ret
+}
+
+// usesLineAnchors reports whether a compiled expression has a ^ or $ that matches at line breaks
+func usesLineAnchors(expr string) bool {
+	re, err := syntax.Parse(expr, syntax.Perl)
+	if err != nil {
+		return false
+	}
+	var walk func(re *syntax.Regexp) bool
+	walk = func(re *syntax.Regexp) bool {
+		if re.Op == syntax.OpBeginLine || re.Op == syntax.OpEndLine {
+			return true
+		}
+		for _, sub := range re.Sub {
+			if walk(sub) {
+				return true
+			}
+		}
+		return false
+	}
+	return walk(re)
+}
+
+// Warnings returns the warnings of every fingerprint in the database, see Fingerprint.Warnings
+func (fdb *FingerprintDB) Warnings() []string {
+	var ret []string
+	for _, fp := range fdb.Fingerprints {
+		ret = append(ret, fp.Warnings()...)
+	}
+	return ret
+}
@@ -0,0 +1,40 @@
+package recog
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestWarnings(t *testing.T) {
+	xmlData := `<fingerprints matches="test">
+  <fingerprint pattern="^Server: Acme (\S+)$">
+    <description>Acme line</description>
+    <example service.version="2">HTTP/1.0 200 OK
+Server: Acme 2</example>
+    <param pos="1" name="service.version"/>
+  </fingerprint>
+  <fingerprint pattern="(?m)^Server: Other (\S+)$">
+    <description>Other line</description>
+    <example service.version="2">HTTP/1.0 200 OK
+Server: Other 2</example>
+    <param pos="1" name="service.version"/>
+  </fingerprint>
+  <fingerprint pattern="^Single (\S+)$">
+    <description>Single line</description>
+    <example service.version="2">Single 2</example>
+    <param pos="1" name="service.version"/>
+  </fingerprint>
+</fingerprints>`
+	fdb, err := LoadFingerprintDB("test.xml", []byte(xmlData))
+	if err != nil {
+		t.Fatalf("LoadFingerprintDB() failed: %s", err)
+	}
+	if err := fdb.Validate(); err != nil {
+		t.Fatalf("Validate() failed: %s", err)
+	}
+
+	warnings := fdb.Warnings()
+	if len(warnings) != 1 || !strings.Contains(warnings[0], "^Server: Acme") {
+		t.Errorf("Warnings() = %q, expected a warning for the Acme pattern only", warnings)
+	}
+}
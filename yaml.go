@@ -0,0 +1,129 @@
+package recog
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"gopkg.in/yaml.v3"
+)
+
+// LoadFingerprintDBFromYAML parses a YAML fingerprint database and returns a FingerprintDB.
+// The YAML schema mirrors the XML model, using the same names as the JSON encoding:
+//
+//	matches: ssh.banner
+//	protocol: ssh
+//	preference: 0.90
+//	fingerprint:
+//	  - pattern: '^OpenSSH_([\d.]+)$'
+//	    description: OpenSSH
+//	    example:
+//	      - text: OpenSSH_7.4
+//	        attrs:
+//	          service.version: "7.4"
+//	    param:
+//	      - {pos: 0, name: service.product, value: OpenSSH}
+//
+// Descriptions and examples may also be given as plain strings. Every field of the
+// model is a string, like the XML attributes it mirrors, so scalars keep the text they
+// were written with: preference: 0.90 is stored as "0.90". The database is normalized
+// like one loaded from XML.
+func LoadFingerprintDBFromYAML(name string, yamlData []byte) (FingerprintDB, error) {
+	fdb := FingerprintDB{}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(yamlData, &root); err != nil {
+		return fdb, fmt.Errorf("yaml: %s", err)
+	}
+	doc, err := yamlValue(&root)
+	if err != nil {
+		return fdb, fmt.Errorf("yaml: %s", err)
+	}
+
+	// Allow the shorthand string forms for descriptions and examples
+	if root, ok := doc.(map[string]interface{}); ok {
+		if fps, ok := root["fingerprint"].([]interface{}); ok {
+			for _, fp := range fps {
+				fpm, ok := fp.(map[string]interface{})
+				if !ok {
+					continue
+				}
+				if desc, ok := fpm["description"].(string); ok {
+					fpm["description"] = map[string]interface{}{"text": desc}
+				}
+				if exs, ok := fpm["example"].([]interface{}); ok {
+					for i, ex := range exs {
+						if text, ok := ex.(string); ok {
+							exs[i] = map[string]interface{}{"text": text}
+						}
+					}
+				}
+			}
+		}
+	}
+
+	jsonData, err := json.Marshal(doc)
+	if err != nil {
+		return fdb, fmt.Errorf("yaml: %s", err)
+	}
+	if err := json.Unmarshal(jsonData, &fdb); err != nil {
+		return fdb, fmt.Errorf("yaml: %s", err)
+	}
+
+	// Store the source name and a checksum of the contents
+	fdb.Name = name
+	sum := sha256.Sum256(yamlData)
+	fdb.Checksum = hex.EncodeToString(sum[:])
+
+	return fdb, nil
+}
+
+// yamlValue converts a decoded YAML node to the nested maps, slices, and strings
+// of the JSON encoding. Scalars are kept as their text, null scalars become nil.
+func yamlValue(n *yaml.Node) (interface{}, error) {
+	switch n.Kind {
+	case 0:
+		return nil, nil
+	case yaml.DocumentNode:
+		if len(n.Content) == 0 {
+			return nil, nil
+		}
+		return yamlValue(n.Content[0])
+	case yaml.AliasNode:
+		return yamlValue(n.Alias)
+	case yaml.ScalarNode:
+		if n.Tag == "!!null" {
+			return nil, nil
+		}
+		return n.Value, nil
+	case yaml.SequenceNode:
+		ret := make([]interface{}, 0, len(n.Content))
+		for _, c := range n.Content {
+			v, err := yamlValue(c)
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, v)
+		}
+		return ret, nil
+	case yaml.MappingNode:
+		ret := make(map[string]interface{}, len(n.Content)/2)
+		for i := 0; i+1 < len(n.Content); i += 2 {
+			k := n.Content[i]
+			if k.Kind != yaml.ScalarNode {
+				return nil, fmt.Errorf("line %d: mapping keys must be scalars", k.Line)
+			}
+			if _, ok := ret[k.Value]; ok {
+				return nil, fmt.Errorf("line %d: key %q is already defined", k.Line, k.Value)
+			}
+			v, err := yamlValue(n.Content[i+1])
+			if err != nil {
+				return nil, err
+			}
+			ret[k.Value] = v
+		}
+		return ret, nil
+	}
+	return nil, fmt.Errorf("line %d: unsupported node", n.Line)
+}
@@ -0,0 +1,146 @@
+package recog
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestLoadFingerprintDBFromYAML(t *testing.T) {
+	xmlData := `<fingerprints matches="test" protocol="test" database_type="service" preference="0.50">
+  <fingerprint pattern="^Acme Server v([\d.]+) #(\w+)" flags="REG_ICASE" certainty="0.9">
+    <description>Acme server</description>
+    <example service.version="1.2" service.edition="pro">Acme Server v1.2 #pro</example>
+    <param pos="0" name="service.vendor" value="Acme"/>
+    <param pos="0" name="service.product" value="Acme Server"/>
+    <param pos="1" name="service.version"/>
+    <param pos="2" name="service.edition"/>
+  </fingerprint>
+  <fingerprint pattern="^Acme: (\S+)$">
+    <description>Acme: generic</description>
+    <example service.version="3.0">QWNtZTogMy4w</example>
+    <param pos="0" name="service.vendor" value="Acme"/>
+    <param pos="1" name="service.version"/>
+  </fingerprint>
+</fingerprints>`
+
+	yamlData := `# Equivalent to the XML database
+matches: test
+protocol: test
+database_type: service
+preference: 0.50
+fingerprint:
+  - pattern: '^Acme Server v([\d.]+) #(\w+)'  # a trailing comment
+    flags: REG_ICASE
+    certainty: "0.9"
+    description: Acme server
+    example:
+      - text: "Acme Server v1.2 #pro"
+        attrs:
+          service.version: "1.2"
+          service.edition: pro
+    param:
+      - {pos: "0", name: service.vendor, value: Acme}
+      - pos: 0
+        name: service.product
+        value: 'Acme Server'
+      - {pos: "1", name: service.version}
+      - {pos: "2", name: service.edition}
+
+  - pattern: "^Acme: (\\S+)$"
+    description:
+      text: 'Acme: generic'
+    example:
+    - text: |-
+        QWNtZTogMy4w
+      attrs: {_encoding: base64, service.version: "3.0"}
+    param:
+    - {pos: "0", name: service.vendor, value: Acme}
+    - {pos: "1", name: service.version}
+`
+	// The XML example uses base64 so both sources describe the same data
+	xmlData = replaceOnce(xmlData, `<example service.version="3.0">`, `<example _encoding="base64" service.version="3.0">`)
+
+	xdb, err := LoadFingerprintDB("test.xml", []byte(xmlData))
+	if err != nil {
+		t.Fatalf("LoadFingerprintDB() failed: %s", err)
+	}
+	ydb, err := LoadFingerprintDBFromYAML("test.yaml", []byte(yamlData))
+	if err != nil {
+		t.Fatalf("LoadFingerprintDBFromYAML() failed: %s", err)
+	}
+
+	if ydb.Matches != xdb.Matches || ydb.Protocol != xdb.Protocol || ydb.PreferenceValue != xdb.PreferenceValue {
+		t.Errorf("YAML database attributes differ: %#v", ydb)
+	}
+	if len(ydb.Fingerprints) != len(xdb.Fingerprints) {
+		t.Fatalf("YAML database has %d fingerprints, expected %d", len(ydb.Fingerprints), len(xdb.Fingerprints))
+	}
+	for i, xfp := range xdb.Fingerprints {
+		yfp := ydb.Fingerprints[i]
+		if yfp.Pattern != xfp.Pattern || yfp.Flags != xfp.Flags || yfp.Certainty != xfp.Certainty || yfp.Description.Text != xfp.Description.Text {
+			t.Errorf("fingerprint %d differs: %#v != %#v", i, yfp, xfp)
+		}
+		if !reflect.DeepEqual(yfp.Params, xfp.Params) {
+			t.Errorf("fingerprint %d params differ", i)
+		}
+		if !reflect.DeepEqual(yfp.Examples[0].AttributeMap, xfp.Examples[0].AttributeMap) {
+			t.Errorf("fingerprint %d example attributes differ: %v != %v", i, yfp.Examples[0].AttributeMap, xfp.Examples[0].AttributeMap)
+		}
+	}
+
+	if err := ydb.VerifyExamples("."); err != nil {
+		t.Errorf("VerifyExamples() failed for YAML database: %s", err)
+	}
+	for _, data := range []string{"acme server v1.2 #pro", "Acme: 3.0", "Other"} {
+		if xm, ym := xdb.MatchFirst(data), ydb.MatchFirst(data); xm.Matched != ym.Matched || !reflect.DeepEqual(xm.Values, ym.Values) {
+			t.Errorf("MatchFirst(%q) differs: %#v != %#v", data, ym, xm)
+		}
+	}
+}
+
+func TestLoadFingerprintDBFromYAMLErrors(t *testing.T) {
+	for _, yamlData := range []string{
+		"matches: test\n\tprotocol: test\n",
+		"fingerprint:\n  - pattern: \"^unterminated\n",
+		"fingerprint: [a, b]\n",
+		"matches: a\nmatches: b\n",
+		"fingerprint:\n  - pattern: '(unbalanced'\n",
+	} {
+		if _, err := LoadFingerprintDBFromYAML("test.yaml", []byte(yamlData)); err == nil {
+			t.Errorf("LoadFingerprintDBFromYAML() should fail for %q", yamlData)
+		}
+	}
+}
+
+func TestLoadFingerprintDBFromYAMLScalars(t *testing.T) {
+	yamlData := `matches: test
+preference: 0.50
+fingerprint:
+  - pattern: '^It''s #(\d+)$'
+    certainty: 0.9
+    description: &desc Quoted
+    example:
+      - text: "It's #1"
+        attrs: {service.version: 1}
+    param:
+      - {pos: 1, name: service.version}
+  - pattern: '^Other$'
+    description: *desc
+`
+	fdb, err := LoadFingerprintDBFromYAML("test.yaml", []byte(yamlData))
+	if err != nil {
+		t.Fatalf("LoadFingerprintDBFromYAML() failed: %s", err)
+	}
+	fp := fdb.Fingerprints[0]
+	if fdb.Preference != "0.50" || fp.Certainty != "0.9" || fp.Pattern != `^It's #(\d+)$` || fdb.Fingerprints[1].Description.Text != "Quoted" {
+		t.Errorf("LoadFingerprintDBFromYAML() changed scalars: %q %q %q", fdb.Preference, fp.Certainty, fp.Pattern)
+	}
+	if err := fdb.VerifyExamples("."); err != nil {
+		t.Errorf("VerifyExamples() failed: %s", err)
+	}
+}
+
+func replaceOnce(s, old, new string) string {
+	return strings.Replace(s, old, new, 1)
+}